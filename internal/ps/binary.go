@@ -0,0 +1,328 @@
+package ps
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+func (t *tree) WriteFrames(w io.Writer, encodeVal func(Any) ([]byte, error)) error {
+	var buf []byte
+	var err error
+	t.all(func(key string, val Any) bool {
+		var encoded []byte
+		encoded, err = encodeVal(val)
+		if err != nil {
+			err = fmt.Errorf("encoding value of %q: %w", key, err)
+			return false
+		}
+
+		buf = appendFrame(buf[:0], []byte(key))
+		buf = appendFrame(buf, encoded)
+		_, err = w.Write(buf)
+		return err == nil
+	})
+	return err
+}
+
+// ReadFrames reads frames written by WriteFrames until the end of r,
+// decoding values with decodeVal, and returns the resulting map.
+// A stream which ends in the middle of an entry is an error.
+func ReadFrames(r io.Reader, decodeVal func([]byte) (Any, error)) (Map, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		buffered := bufio.NewReader(r)
+		r, br = buffered, buffered
+	}
+
+	m := NewMap()
+	for {
+		key, err := readFrame(r, br)
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading key: %w", err)
+		}
+
+		encoded, err := readFrame(r, br)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading value of %q: %w", key, err)
+		}
+
+		val, err := decodeVal(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decoding value of %q: %w", key, err)
+		}
+		m = m.Set(string(key), val)
+	}
+}
+
+func appendFrame(buf, frame []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(frame)))
+	return append(buf, frame...)
+}
+
+// readFrame returns io.EOF only if r ends before the frame starts. The
+// length prefix isn't trusted: a frame longer than what's left of r, when r
+// can tell, is an error, and otherwise a long frame is read in chunks, so
+// that a corrupt length can't make it allocate much more than r holds.
+func readFrame(r io.Reader, br io.ByteReader) ([]byte, error) {
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxFrameSize {
+		return nil, errors.New("frame too large")
+	}
+	if l, ok := r.(interface{ Len() int }); ok && size > uint64(l.Len()) {
+		return nil, fmt.Errorf("frame of %d bytes with %d left: %w", size, l.Len(), io.ErrUnexpectedEOF)
+	}
+
+	if size <= frameChunkSize {
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return frame, nil
+	}
+	var frame bytes.Buffer
+	if _, err := io.CopyN(&frame, r, int64(size)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return frame.Bytes(), nil
+}
+
+// maxFrameSize bounds the length of a frame
+const maxFrameSize = 1 << 30
+
+// frameChunkSize is the longest frame readFrame allocates in full before
+// reading it
+const frameChunkSize = 64 << 10
+
+// binaryVersion is the first byte written by WriteTo. It changes whenever
+// the format does, so ReadMap can reject streams it doesn't understand.
+const binaryVersion = 1
+
+// the tags prefixed to each value encoded by WriteTo
+const (
+	tagNil byte = iota
+	tagString
+	tagFalse
+	tagTrue
+	tagInt
+	tagFloat64
+	tagBytes
+	tagMap
+	tagGob
+)
+
+func (t *tree) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write([]byte{binaryVersion}); err != nil {
+		return cw.n, err
+	}
+	err := t.WriteFrames(cw, encodeBinaryValue)
+	return cw.n, err
+}
+
+// ReadMap reads a map written by Map.WriteTo. Values which were gob
+// encoded need their types registered with gob.Register, as for writing.
+func ReadMap(r io.Reader) (Map, error) {
+	br := bufio.NewReader(r)
+	version, err := br.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading version: %w", err)
+	}
+	if version != binaryVersion {
+		return nil, fmt.Errorf("unsupported format version %d", version)
+	}
+	return ReadFrames(br, decodeBinaryValue)
+}
+
+func encodeBinaryValue(val Any) ([]byte, error) {
+	switch v := val.(type) {
+	case nil:
+		return []byte{tagNil}, nil
+	case string:
+		return append([]byte{tagString}, v...), nil
+	case bool:
+		if v {
+			return []byte{tagTrue}, nil
+		}
+		return []byte{tagFalse}, nil
+	case int:
+		return binary.AppendVarint([]byte{tagInt}, int64(v)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64([]byte{tagFloat64}, math.Float64bits(v)), nil
+	case []byte:
+		return append([]byte{tagBytes}, v...), nil
+	case Map:
+		var buf bytes.Buffer
+		buf.WriteByte(tagMap)
+		if _, err := v.WriteTo(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(tagGob)
+	if err := gob.NewEncoder(&buf).Encode(&val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeBinaryValue(data []byte) (Any, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty value")
+	}
+	tag, data := data[0], data[1:]
+	switch tag {
+	case tagNil:
+		return nil, nil
+	case tagString:
+		return string(data), nil
+	case tagFalse:
+		return false, nil
+	case tagTrue:
+		return true, nil
+	case tagInt:
+		v, n := binary.Varint(data)
+		if n <= 0 || n != len(data) {
+			return nil, errors.New("malformed int")
+		}
+		return int(v), nil
+	case tagFloat64:
+		if len(data) != 8 {
+			return nil, errors.New("malformed float64")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	case tagBytes:
+		return data, nil
+	case tagMap:
+		return ReadMap(bytes.NewReader(data))
+	case tagGob:
+		var val Any
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&val); err != nil {
+			return nil, err
+		}
+		return val, nil
+	}
+	return nil, fmt.Errorf("unknown value tag %d", tag)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// binaryMagic starts the output of MarshalBinary
+const binaryMagic = "ps\x00M"
+
+// marshalVersion is the format version written by MarshalBinary
+const marshalVersion = 1
+
+func (t *tree) MarshalBinary() ([]byte, error) {
+	// every key is checked against the same time and the count is taken
+	// from the entries written, so that a key expiring while the map is
+	// encoded can't leave the count out of step with the entries
+	var entries []byte
+	count := 0
+	now := time.Now()
+	var err error
+	t.eachEntry(func(key string, stored Any) {
+		val, ok := resolveAt(stored, now)
+		if !ok || err != nil {
+			return
+		}
+		var encoded []byte
+		if encoded, err = encodeBinaryValue(val); err != nil {
+			err = fmt.Errorf("encoding value of %q: %w", key, err)
+			return
+		}
+		entries = appendFrame(appendFrame(entries, []byte(key)), encoded)
+		count++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := append([]byte(binaryMagic), marshalVersion)
+	buf = binary.AppendUvarint(buf, uint64(count))
+	return append(buf, entries...), nil
+}
+
+// UnmarshalBinary replaces the held map with one decoded from data, which
+// must have been produced by Map.MarshalBinary. If the held map restricts
+// its keys, the decoded keys must be accepted by it.
+func (v *MapValue) UnmarshalBinary(data []byte) error {
+	if len(data) < len(binaryMagic)+1 || string(data[:len(binaryMagic)]) != binaryMagic {
+		return errors.New("not a binary encoded map")
+	}
+	if version := data[len(binaryMagic)]; version != marshalVersion {
+		return fmt.Errorf("unsupported binary map version %d", version)
+	}
+
+	r := bytes.NewReader(data[len(binaryMagic)+1:])
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("reading entry count: %w", unexpectedEOF(err))
+	}
+
+	tr := NewMap().AsTransient()
+	if t, ok := v.Map.(*tree); ok {
+		tr = t.empty().AsTransient()
+	}
+	for i := uint64(0); i < count; i++ {
+		key, err := readFrame(r, r)
+		if err != nil {
+			return fmt.Errorf("reading key of entry %d of %d: %w", i+1, count, unexpectedEOF(err))
+		}
+		encoded, err := readFrame(r, r)
+		if err != nil {
+			return fmt.Errorf("reading value of %q: %w", key, unexpectedEOF(err))
+		}
+		val, err := decodeBinaryValue(encoded)
+		if err != nil {
+			return fmt.Errorf("decoding value of %q: %w", key, err)
+		}
+		if err := tr.root.opts.checkKey(tr.root.opts.normalizeKey(string(key))); err != nil {
+			return err
+		}
+		tr.Set(string(key), val)
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%d unexpected bytes after %d entries", r.Len(), count)
+	}
+	v.Map = tr.Persistent()
+	return nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, for data which ends
+// where more was promised
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
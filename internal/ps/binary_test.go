@@ -0,0 +1,251 @@
+package ps
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeInt(v Any) ([]byte, error) {
+	i, ok := v.(int)
+	if !ok {
+		return nil, errors.New("not an int")
+	}
+	return []byte(strconv.Itoa(i)), nil
+}
+
+func decodeInt(b []byte) (Any, error) {
+	return strconv.Atoi(string(b))
+}
+
+func TestFramesRoundTrip(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 10000; i++ {
+		m = m.Set("key"+strconv.Itoa(i), i)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteFrames(&buf, encodeInt); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// hide the buffer's ReadByte to exercise the buffered path
+	read, err := ReadFrames(io.MultiReader(&buf), decodeInt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !read.Equal(m) {
+		t.Errorf("round trip produced a different map")
+	}
+
+	read, err = ReadFrames(&bytes.Buffer{}, decodeInt)
+	if err != nil || read.Size() != 0 {
+		t.Errorf("empty stream should decode to the empty map: %v", err)
+	}
+}
+
+func TestFramesErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewMap().Set("a", "not an int").WriteFrames(&buf, encodeInt); err == nil {
+		t.Errorf("expected an encoding error")
+	}
+
+	buf.Reset()
+	NewMap().Set("a", 1).WriteFrames(&buf, encodeInt)
+	truncated := buf.Bytes()[:buf.Len()-1]
+	if _, err := ReadFrames(bytes.NewReader(truncated), decodeInt); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an unexpected EOF, got %v", err)
+	}
+}
+
+func TestFramesCorruptLength(t *testing.T) {
+	// a key claiming to be 512MB long, followed by a few bytes
+	corrupt := append(binary.AppendUvarint(nil, 512<<20), "short"...)
+
+	for _, r := range []io.Reader{
+		bytes.NewReader(corrupt),
+		io.MultiReader(bytes.NewReader(corrupt)), // can't tell its length
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := ReadFrames(r, decodeInt)
+		runtime.ReadMemStats(&after)
+
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%T: expected an unexpected EOF, got %v", r, err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("%T: reading a corrupt length allocated %d bytes", r, allocated)
+		}
+	}
+
+	// frames longer than a chunk are still read in full
+	long := strings.Repeat("x", 3*frameChunkSize+1)
+	var buf bytes.Buffer
+	identity := func(v Any) ([]byte, error) { return []byte(v.(string)), nil }
+	if err := NewMap().Set("long", long).WriteFrames(&buf, identity); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadFrames(io.MultiReader(&buf), func(b []byte) (Any, error) { return string(b), nil })
+	if v, _ := m.Lookup("long"); err != nil || v != long {
+		t.Errorf("long frame wasn't read back: %v", err)
+	}
+}
+
+func TestWriteToReadMap(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 10000; i++ {
+		m = m.Set("key"+strconv.Itoa(i), i)
+	}
+	m = m.Set("string", "value").
+		Set("true", true).
+		Set("false", false).
+		Set("float", 2.5).
+		Set("bytes", []byte{1, 2, 3}).
+		Set("nil", nil).
+		Set("negative", -7).
+		Set("other", int64(9)).
+		Set("point", gobPoint{1, 2}).
+		Set("nested", NewMap().Set("a", 1))
+
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo() reported %d bytes, wrote %d", n, buf.Len())
+	}
+
+	read, err := ReadMap(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !read.Equal(m) {
+		t.Errorf("round trip produced a different map")
+	}
+	if v, _ := read.Lookup("other"); v != int64(9) {
+		t.Errorf("wrong type after round trip: %T", v)
+	}
+}
+
+func TestReadMapErrors(t *testing.T) {
+	if _, err := ReadMap(&bytes.Buffer{}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an unexpected EOF, got %v", err)
+	}
+	if _, err := ReadMap(bytes.NewReader([]byte{99})); err == nil {
+		t.Errorf("expected an error for an unknown version")
+	}
+
+	var buf bytes.Buffer
+	NewMap().WriteTo(&buf)
+	if read, err := ReadMap(&buf); err != nil || !read.IsNil() {
+		t.Errorf("empty map should round trip: %v", err)
+	}
+
+	buf.Reset()
+	buf.WriteByte(binaryVersion)
+	buf.Write(appendFrame(appendFrame(nil, []byte("a")), []byte{200}))
+	if _, err := ReadMap(&buf); err == nil {
+		t.Errorf("expected an error for an unknown value tag")
+	}
+
+	type unregistered struct{ A int }
+	if _, err := NewMap().Set("a", unregistered{1}).WriteTo(io.Discard); err == nil {
+		t.Errorf("expected an error for an unregistered value type")
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	m := NewMap().
+		Set("string", "value").
+		Set("int", 42).
+		Set("nested", NewMap().Set("a", true)).
+		Set("point", gobPoint{1, 2})
+	for i := 0; i < 1000; i++ {
+		m = m.Set("key"+strconv.Itoa(i), i)
+	}
+
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var v MapValue
+	if err := v.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !v.Equal(m) {
+		t.Errorf("round trip produced a different map")
+	}
+
+	data, _ = NewMap().MarshalBinary()
+	if err := v.UnmarshalBinary(data); err != nil || !v.IsNil() {
+		t.Errorf("empty map should round trip: %v", err)
+	}
+}
+
+// slowValue takes a while to encode, long enough for keys to expire
+type slowValue struct{}
+
+func (slowValue) GobEncode() ([]byte, error) {
+	time.Sleep(20 * time.Millisecond)
+	return []byte{}, nil
+}
+
+func (*slowValue) GobDecode([]byte) error { return nil }
+
+func TestMarshalBinaryExpiring(t *testing.T) {
+	gob.Register(slowValue{})
+	expiresAt := time.Now().Add(10 * time.Millisecond)
+	m := NewMap().Set("slow", slowValue{})
+	for i := 0; i < 50; i++ {
+		m = m.SetTTL("brief"+strconv.Itoa(i), i, expiresAt)
+	}
+
+	// keys expire while the map is encoded, but the count must match the
+	// entries written
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var v MapValue
+	if err := v.UnmarshalBinary(data); err != nil {
+		t.Fatalf("can't decode a map whose keys expired while it was encoded: %v", err)
+	}
+	if _, ok := v.Lookup("slow"); !ok {
+		t.Errorf("lost the key which doesn't expire")
+	}
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	data, _ := NewMap().Set("a", "value").Set("b", 2).MarshalBinary()
+	var v MapValue
+
+	for n := 0; n < len(data); n++ {
+		if err := v.UnmarshalBinary(data[:n]); err == nil {
+			t.Errorf("truncating to %d bytes should fail", n)
+		}
+	}
+	if err := v.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an unexpected EOF, got %v", err)
+	}
+
+	future := append([]byte(nil), data...)
+	future[len(binaryMagic)]++
+	if err := v.UnmarshalBinary(future); err == nil || err.Error() != "unsupported binary map version 2" {
+		t.Errorf("expected the version to be rejected, got %v", err)
+	}
+	if err := v.UnmarshalBinary([]byte("not a map")); err == nil {
+		t.Errorf("expected an error for data without the magic number")
+	}
+	if err := v.UnmarshalBinary(append(data, 0)); err == nil {
+		t.Errorf("expected an error for trailing bytes")
+	}
+}
@@ -0,0 +1,140 @@
+package ps
+
+import "sync/atomic"
+
+// NewMapWithBloom returns a new, empty map which keeps a Bloom filter of
+// its keys beside the tree. Lookup and Contains check the filter first and
+// return straight away for most absent keys, without walking the tree.
+// Every map derived from the result keeps a filter too.
+//
+// Copying a filter for each version would make Set O(N), so versions
+// share one instead: Set adds the new key's bits to the filter of the map
+// it's called on and gives the result the same filter. A filter may then
+// hold keys of other versions as well as this one's, which only makes it
+// claim keys which aren't there, costing a walk of the tree, and never
+// miss ones which are. A Bloom filter can't forget keys, so Delete keeps
+// the filter unchanged, deleted keys included. Once more keys have been
+// added to a filter than it was sized for, the next Set builds a new one
+// for its result from the keys in that map alone, which drops deleted keys
+// and makes room for more.
+//
+// Other operations, such as Merge, MapValues or the edits made through a
+// Transient, give a result without a filter and its lookups walk the tree
+// as usual; the next Set builds a filter for the whole map.
+func NewMapWithBloom() Map {
+	return newMapWithOptions(&options{bloom: true})
+}
+
+const (
+	bloomBlockKeys = 48 // keys a block is sized for, about 10 bits each
+	bloomProbes    = 7  // bits set per key, which all fall in one block
+	bloomMinKeys   = 64 // keys the smallest filter is sized for
+)
+
+// bloomBlock is the part of a filter one key's bits are set in. Keeping
+// them together means a lookup reads a single cache line.
+type bloomBlock [8]atomic.Uint64
+
+// bloomFilter is a Bloom filter over key hashes, shared by the versions of
+// a map. It holds every key stored below the nodes it's attached to, and
+// maybe others. Bits are only ever set, atomically, so maps sharing a
+// filter remain safe to use from several goroutines.
+type bloomFilter struct {
+	blocks   []bloomBlock
+	capacity int          // keys the filter was sized for
+	added    atomic.Int64 // keys added, including deleted ones
+}
+
+// newBloomFilter returns a filter holding every key in t, with room for as
+// many again
+func newBloomFilter(t *tree) *bloomFilter {
+	capacity := 2 * t.count
+	if capacity < bloomMinKeys {
+		capacity = bloomMinKeys
+	}
+	f := &bloomFilter{
+		blocks:   make([]bloomBlock, (capacity+bloomBlockKeys-1)/bloomBlockKeys),
+		capacity: capacity,
+	}
+	f.added.Store(int64(t.count))
+	t.eachNode(func(n *tree) {
+		// every key in a node has the node's hash
+		f.set(n.hash)
+	})
+	return f
+}
+
+// add sets the bits for a key with the given hash, unless the filter is
+// full and should be rebuilt, in which case it returns false
+func (f *bloomFilter) add(hash uint64) bool {
+	if f.added.Add(1) > int64(f.capacity) {
+		return false
+	}
+	f.set(hash)
+	return true
+}
+
+func (f *bloomFilter) set(hash uint64) {
+	i, bits := bloomProbe(hash, len(f.blocks))
+	b := &f.blocks[i]
+	for _, bit := range bits {
+		w, mask := &b[bit/64], uint64(1)<<(bit%64)
+		for old := w.Load(); old&mask == 0 && !w.CompareAndSwap(old, old|mask); old = w.Load() {
+		}
+	}
+}
+
+// mayContain returns false if no key with the given hash is in the filter
+func (f *bloomFilter) mayContain(hash uint64) bool {
+	i, bits := bloomProbe(hash, len(f.blocks))
+	b := &f.blocks[i]
+	for _, bit := range bits {
+		if b[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomProbe returns the block a key hash falls in and the bits it sets
+// there. The hash is mixed first so a custom hash which places keys well in
+// the tree but has poor high bits doesn't crowd the filter. The high half
+// of the mixed hash picks the block and the low half the bits, by double
+// hashing; both avoid division, which costs as much as the rest together.
+func bloomProbe(hash uint64, blocks int) (int, [bloomProbes]uint16) {
+	h := mix64(hash)
+	block := int((h >> 32) * uint64(blocks) >> 32)
+
+	var bits [bloomProbes]uint16
+	bit, step := uint16(h), uint16(h>>16)|1
+	for i := range bits {
+		bits[i] = bit % 512 // one of a block's 512 bits
+		bit += step
+	}
+	return block, bits
+}
+
+// mix64 is the finalizer of the SplitMix64 generator, which spreads every
+// bit of x across the result
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// withBloom attaches a filter to m, a new root derived from t by setting a
+// key with the given hash, if t's options ask for one
+func (t *tree) withBloom(m *tree, hash uint64, added bool) *tree {
+	if !t.opts.wantsBloom() {
+		return m
+	}
+	if t.filter != nil && (!added || t.filter.add(hash)) {
+		m.filter = t.filter
+	} else {
+		m.filter = newBloomFilter(m)
+	}
+	return m
+}
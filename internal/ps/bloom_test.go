@@ -0,0 +1,112 @@
+package ps
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestBloomNoFalseNegatives(t *testing.T) {
+	m := NewMapWithBloom()
+	versions := []Map{m}
+	for i := 0; i < 2000; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+		if i%3 == 0 {
+			m = m.Delete(strconv.Itoa(i / 2))
+		}
+		if i%100 == 0 {
+			versions = append(versions, m)
+		}
+	}
+	versions = append(versions, m)
+
+	// every version must find exactly its own keys, although versions share
+	// filters holding other versions' keys and later ones have rebuilt it
+	for _, v := range versions {
+		for i := 0; i < 2000; i++ {
+			k := strconv.Itoa(i)
+			if _, ok := v.Lookup(k); ok != contains(v, k) {
+				t.Fatalf("Lookup(%q) = %v disagrees with ForEach", k, ok)
+			}
+		}
+		v.ForEach(func(k string, _ Any) {
+			if !v.Contains(k) {
+				t.Errorf("false negative for %q", k)
+			}
+		})
+	}
+}
+
+// contains reports whether ForEach visits key, without using Lookup
+func contains(m Map, key string) bool {
+	found := false
+	m.ForEach(func(k string, _ Any) { found = found || k == key })
+	return found
+}
+
+func TestBloomRejectsMisses(t *testing.T) {
+	m := NewMapWithBloom()
+	for i := 0; i < 5000; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	f := m.(*tree).filter
+	if f == nil {
+		t.Fatalf("expected a filter")
+	}
+	passed := 0
+	for i := 5000; i < 15000; i++ {
+		if f.mayContain(hashKey(strconv.Itoa(i))) {
+			passed++
+		}
+	}
+	if passed > 500 {
+		t.Errorf("too many false positives: %d of 10000", passed)
+	}
+}
+
+func TestBloomDerivedMaps(t *testing.T) {
+	m := NewMapWithBloom().Set("a", 1).Set("b", 2).Set("c", 3)
+
+	doubled := m.MapValues(func(_ string, v Any) Any { return v.(int) * 2 })
+	if doubled.(*tree).filter != nil {
+		t.Errorf("MapValues() shouldn't keep a filter it hasn't checked")
+	}
+	if v, _ := doubled.Lookup("b"); v != 4 {
+		t.Errorf("wrong value after MapValues(): %v", v)
+	}
+
+	rebuilt := doubled.Set("d", 8)
+	if rebuilt.(*tree).filter == nil {
+		t.Errorf("Set() should rebuild the filter")
+	}
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if !rebuilt.Contains(k) {
+			t.Errorf("false negative for %q", k)
+		}
+	}
+
+	if NewMap().Set("a", 1).(*tree).filter != nil {
+		t.Errorf("plain maps shouldn't keep a filter")
+	}
+	if empty := m.Delete("a").Delete("b").Delete("c"); !empty.IsNil() || empty.Contains("a") {
+		t.Errorf("deleting every key should give an empty map")
+	}
+}
+
+func benchmarkMisses(b *testing.B, m Map) {
+	for i := 0; i < 1000000; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	misses := make([]string, 1000)
+	for i := range misses {
+		misses[i] = "missing" + strconv.Itoa(i)
+	}
+	runtime.GC() // don't time collecting the garbage left by building m
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Lookup(misses[i%len(misses)])
+	}
+}
+
+func BenchmarkLookupMiss(b *testing.B)      { benchmarkMisses(b, NewMap()) }
+func BenchmarkLookupMissBloom(b *testing.B) { benchmarkMisses(b, NewMapWithBloom()) }
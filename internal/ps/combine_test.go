@@ -0,0 +1,184 @@
+package ps
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	a := NewMap().Set("a", 1).Set("shared", "a")
+	b := NewMap().Set("b", 2).Set("shared", "b")
+
+	merged := a.Merge(b)
+	expected := NewMap().Set("a", 1).Set("b", 2).Set("shared", "b")
+	if !merged.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, merged)
+	}
+	if v, _ := a.Lookup("shared"); v != "a" || a.Size() != 2 {
+		t.Errorf("Merge() modified the receiving map")
+	}
+}
+
+func TestMergeAll(t *testing.T) {
+	defaults := NewMap().Set("host", "localhost").Set("port", 80).Set("debug", false)
+	env := NewMap().Set("port", 8080).Set("user", "env")
+	overrides := NewMap().Set("debug", true).Set("user", "admin")
+
+	merged := MergeAll(defaults, env, overrides)
+	expected := NewMap().
+		Set("host", "localhost").
+		Set("port", 8080).
+		Set("debug", true).
+		Set("user", "admin")
+	if !merged.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, merged)
+	}
+	if defaults.Size() != 3 || env.Size() != 2 || overrides.Size() != 2 {
+		t.Errorf("MergeAll() modified its arguments")
+	}
+
+	if m := MergeAll(); m == nil || !m.IsNil() {
+		t.Errorf("merging nothing should give an empty map")
+	}
+	if MergeAll(env) != env || MergeAll(nil, env, nil) != env {
+		t.Errorf("merging a single map should return it")
+	}
+}
+
+func TestMergeEmpty(t *testing.T) {
+	m := NewMap().Set("a", 1)
+	if m.Merge(NewMap()) != m || m.Merge(nil) != m {
+		t.Errorf("merging an empty map should return the receiver")
+	}
+	if !NewMap().Merge(m).Equal(m) {
+		t.Errorf("merging into an empty map should give the other map")
+	}
+}
+
+func TestMergeOverlap(t *testing.T) {
+	a, b := NewMap(), NewMap()
+	for i := 0; i < 100; i++ {
+		a = a.Set(strconv.Itoa(i), "a")
+		b = b.Set(strconv.Itoa(i), "b")
+	}
+	merged := a.Merge(b)
+	if !merged.Equal(b) {
+		t.Errorf("full overlap should take every value from other")
+	}
+}
+
+func TestMergeDisjoint(t *testing.T) {
+	small, large := NewMap(), NewMap()
+	for i := 0; i < 1000; i++ {
+		large = large.Set(strconv.Itoa(i), i)
+	}
+	for i := 1000; i < 1010; i++ {
+		small = small.Set(strconv.Itoa(i), i)
+	}
+
+	for _, merged := range []Map{small.Merge(large), large.Merge(small)} {
+		if merged.Size() != 1010 {
+			t.Errorf("wrong size: %d", merged.Size())
+		}
+		// the larger map's structure is reused either way
+		if r := SharingRatio(large, merged); r < 0.9 {
+			t.Errorf("merge didn't reuse the larger map: %f", r)
+		}
+	}
+}
+
+func TestMergeWith(t *testing.T) {
+	a := NewMap().Set("apples", 3).Set("pears", 1)
+	b := NewMap().Set("apples", 2).Set("plums", 5)
+
+	calls := map[string]int{}
+	merged := a.MergeWith(b, func(key string, x, y Any) Any {
+		calls[key]++
+		return x.(int) + y.(int)
+	})
+
+	expected := NewMap().Set("apples", 5).Set("pears", 1).Set("plums", 5)
+	if !merged.Equal(expected) {
+		t.Errorf("wrong merge: %s", merged)
+	}
+	if len(calls) != 1 || calls["apples"] != 1 {
+		t.Errorf("resolve should be called once per conflicting key: %v", calls)
+	}
+	if v, _ := a.Lookup("apples"); v != 3 || a.Size() != 2 {
+		t.Errorf("MergeWith() modified the receiving map")
+	}
+
+	// the receiver's value is always passed first
+	order := a.MergeWith(b, func(_ string, x, _ Any) Any { return x })
+	if v, _ := order.Lookup("apples"); v != 3 {
+		t.Errorf("resolve got its arguments in the wrong order")
+	}
+
+	if a.MergeWith(nil, nil) != a || a.MergeWith(NewMap(), nil) != a {
+		t.Errorf("merging with an empty map should return the receiver")
+	}
+}
+
+func TestIntersection(t *testing.T) {
+	large, subset := NewMap(), NewMap()
+	for i := 0; i < 1000; i++ {
+		large = large.Set(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 10; i++ {
+		subset = subset.Set(strconv.Itoa(i*100), "subset")
+	}
+	subset = subset.Set("missing", "subset")
+
+	both := large.Intersection(subset)
+	if both.Size() != 10 {
+		t.Errorf("expected 10 keys, got %d", both.Size())
+	}
+	if v, _ := both.Lookup("300"); v != 300 {
+		t.Errorf("expected the receiver's value, got %v", v)
+	}
+	if large.Size() != 1000 || subset.Size() != 11 {
+		t.Errorf("Intersection() modified an operand")
+	}
+
+	// dropping a few keys keeps most of the structure
+	most := large.Delete("1").Delete("2")
+	if r := SharingRatio(large, large.Intersection(most)); r < 0.9 {
+		t.Errorf("intersection didn't share untouched nodes: %f", r)
+	}
+	if large.Intersection(large) != large {
+		t.Errorf("intersecting a map with itself should return it")
+	}
+
+	if large.Intersection(nil).Size() != 0 || large.Intersection(NewMap()).Size() != 0 {
+		t.Errorf("intersecting with an empty map should be empty")
+	}
+	if NewMap().Intersection(large).Size() != 0 {
+		t.Errorf("intersecting an empty map should be empty")
+	}
+}
+
+func TestDifference(t *testing.T) {
+	m := NewMap().Set("a", 1).Set("b", 2).Set("c", 3)
+	other := NewMap().Set("b", "x").Set("d", "y")
+
+	diff := m.Difference(other)
+	if !diff.Equal(NewMap().Set("a", 1).Set("c", 3)) {
+		t.Errorf("wrong difference: %s", diff)
+	}
+	if !other.Difference(m).Equal(NewMap().Set("d", "y")) {
+		t.Errorf("wrong reverse difference: %s", other.Difference(m))
+	}
+	if m.Size() != 3 || other.Size() != 2 {
+		t.Errorf("Difference() modified an operand")
+	}
+
+	if m.Difference(m).Size() != 0 {
+		t.Errorf("a map minus itself should be empty")
+	}
+	if m.Difference(nil) != m {
+		t.Errorf("subtracting nil should return the receiver")
+	}
+	if m.Difference(NewMap().Set("z", 0)) != m {
+		t.Errorf("subtracting disjoint keys should return the receiver")
+	}
+}
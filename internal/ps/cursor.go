@@ -0,0 +1,142 @@
+package ps
+
+// Cursor makes a series of changes to a Map, like a Transient, while
+// remembering where the last change was made. It's a zipper: the node
+// holding the last key used is kept in focus along with the path from the
+// root to it, and the counts along that path are only brought up to date
+// when the cursor moves back up past them. A change to a key whose place
+// in the tree shares the first levels of the path therefore only walks
+// and updates the levels below them. Keys are placed by their hash, so
+// this pays off when the same keys are changed over and over, such as
+// counters, rather than for keys which look alike.
+//
+// The Map the Cursor came from is never modified, whether or not Map is
+// ever called. Call Map to get the result, which is the same map the
+// equivalent calls to Map.Set and Map.Delete would have built; the Cursor
+// must not be used after that. A Cursor isn't safe for concurrent use.
+type Cursor struct {
+	tr    Transient     // owns the nodes the cursor has copied
+	src   *tree         // the map the cursor came from
+	path  []cursorFrame // ancestors of focus, root first
+	focus *tree
+	dirty bool // whether focus has changed since the cursor moved to it
+	done  bool
+}
+
+type cursorFrame struct {
+	node  *tree
+	digit uint64 // which child of node the path goes on through
+	dirty bool   // whether node has changed; see Cursor.dirty
+}
+
+func (t *tree) Cursor() *Cursor {
+	return &Cursor{src: t, focus: t}
+}
+
+// Set associates key and value, panicking like Map.Set if the map only
+// accepts keys matching a pattern and key doesn't match it.
+// This operation is O(log N) in the number of keys, but only walks the
+// part of the tree that the path to key doesn't share with the path to
+// the last key used.
+func (c *Cursor) Set(key string, value Any) {
+	c.check()
+	key = c.src.opts.normalizeKey(key)
+	if err := c.src.opts.checkKey(key); err != nil {
+		panic(err)
+	}
+	key = c.src.opts.internKey(key)
+	hash := c.src.opts.hashKey(key)
+	partialHash := c.seek(hash)
+	c.focus = c.tr.set(c.focus, partialHash, hash, key, value)
+	c.dirty = true
+}
+
+// Delete removes the association for key, if any.
+// This operation is O(log N) in the number of keys, like Set.
+func (c *Cursor) Delete(key string) {
+	c.check()
+	key = c.src.opts.normalizeKey(key)
+	hash := c.src.opts.hashKey(key)
+	partialHash := c.seek(hash)
+	if focus, found := c.tr.delete(c.focus, partialHash, hash, key); found {
+		c.focus = focus
+		c.dirty = true
+	}
+}
+
+// Lookup returns the value associated with a key, if any, as Map.Lookup.
+// This operation is O(log N) in the number of keys, like Set.
+func (c *Cursor) Lookup(key string) (Any, bool) {
+	c.check()
+	key = c.src.opts.normalizeKey(key)
+	hash := c.src.opts.hashKey(key)
+	partialHash := c.seek(hash)
+	val, ok := lookupLowLevel(c.focus, partialHash, hash, key)
+	if !ok {
+		return nil, false
+	}
+	return resolve(val)
+}
+
+// Map returns the map built by the Cursor, which must not be used
+// afterwards.
+// This operation is O(log N) in the number of keys.
+func (c *Cursor) Map() Map {
+	c.check()
+	for len(c.path) > 0 {
+		c.up()
+	}
+	c.done = true
+	return c.src.adopt(c.focus)
+}
+
+func (c *Cursor) check() {
+	if c.done {
+		panic("Cursor used after Map")
+	}
+}
+
+// seek moves the cursor to the node holding keys with the given hash, or
+// to the empty node where such a key would go, and returns the partial
+// hash for that node's level
+func (c *Cursor) seek(hash uint64) uint64 {
+	// keep the part of the path the key's path shares; a node whose hash
+	// matches holds the key itself, so the path can't go on past it
+	partialHash := c.src.opts.partialHash(hash)
+	shared := 0
+	for ; shared < len(c.path); shared++ {
+		f := c.path[shared]
+		if f.node.hash == hash || f.digit != partialHash%childCount {
+			break
+		}
+		partialHash >>= shiftSize
+	}
+	for len(c.path) > shared {
+		c.up()
+	}
+
+	for !c.focus.IsNil() && c.focus.hash != hash {
+		i := partialHash % childCount
+		c.path = append(c.path, cursorFrame{c.focus, i, c.dirty})
+		c.focus = c.focus.children[i]
+		c.dirty = false
+		partialHash >>= shiftSize
+	}
+	return partialHash
+}
+
+// up moves the cursor to the parent of the focus, first copying the parent
+// to link in the focus if the focus has changed
+func (c *Cursor) up() {
+	f := c.path[len(c.path)-1]
+	c.path = c.path[:len(c.path)-1]
+	if !c.dirty && !f.dirty {
+		c.focus = f.node
+		return
+	}
+	n := c.tr.editable(f.node)
+	n.children[f.digit] = c.focus
+	recalculateCount(n)
+	c.focus = n
+	c.dirty = true
+}
@@ -0,0 +1,110 @@
+package ps
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	poor := func(key string) uint64 { i, _ := strconv.Atoi(key); return uint64(i % 37) }
+	for _, base := range []Map{numbers(200), NewMapWithHash(poor).SetMany(numbers(50).ToGoMap())} {
+		before := base.Dump()
+		c := base.Cursor()
+		m := base
+		for i := 0; i < 2000; i++ {
+			k := strconv.Itoa(r.Intn(300))
+			switch r.Intn(3) {
+			case 0:
+				c.Set(k, i)
+				m = m.Set(k, i)
+			case 1:
+				c.Delete(k)
+				m = m.Delete(k)
+			default:
+				got, gotOk := c.Lookup(k)
+				expected, ok := m.Lookup(k)
+				if got != expected || gotOk != ok {
+					t.Fatalf("Lookup(%q) = %v, %v; expected %v, %v", k, got, gotOk, expected, ok)
+				}
+			}
+		}
+
+		result := c.Map()
+		if result.Size() != m.Size() || !result.Equal(m) {
+			t.Errorf("cursor built a different map: %d keys, expected %d", result.Size(), m.Size())
+		}
+		if result.Dump() != m.Dump() {
+			t.Errorf("cursor built a differently shaped tree")
+		}
+		if base.Dump() != before {
+			t.Errorf("cursor modified the map it came from")
+		}
+	}
+}
+
+func TestCursorAbandoned(t *testing.T) {
+	base := numbers(100)
+	c := base.Cursor()
+	for i := 0; i < 100; i++ {
+		c.Set(strconv.Itoa(i), "changed")
+		c.Delete(strconv.Itoa(i + 50))
+	}
+	// the cursor is never finished with Map
+	if !base.Equal(numbers(100)) {
+		t.Errorf("an abandoned cursor modified the map it came from")
+	}
+
+	m := NewMapWithBloom().Set("only", 1)
+	c = m.Cursor()
+	c.Delete("only")
+	if empty := c.Map(); !empty.IsNil() || empty.(*tree).opts != m.(*tree).opts {
+		t.Errorf("deleting every key should give an empty map with the same options")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("using a cursor after Map should panic")
+		}
+	}()
+	c.Set("after", 1)
+}
+
+func benchmarkCounters(b *testing.B, update func(m Map, keys []string) Map) {
+	m := numbers(10000)
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		update(m, keys)
+	}
+}
+
+// each iteration bumps a few counters a hundred times each
+func BenchmarkCursorCounters(b *testing.B) {
+	benchmarkCounters(b, func(m Map, keys []string) Map {
+		c := m.Cursor()
+		for _, k := range keys {
+			for j := 0; j < 100; j++ {
+				v, _ := c.Lookup(k)
+				c.Set(k, v.(int)+1)
+			}
+		}
+		return c.Map()
+	})
+}
+
+func BenchmarkSetCounters(b *testing.B) {
+	benchmarkCounters(b, func(m Map, keys []string) Map {
+		for _, k := range keys {
+			for j := 0; j < 100; j++ {
+				v, _ := m.Lookup(k)
+				m = m.Set(k, v.(int)+1)
+			}
+		}
+		return m
+	})
+}
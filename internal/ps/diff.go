@@ -0,0 +1,118 @@
+package ps
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Op is one operation of a patch made by Map.PatchFrom. It's a plain
+// struct, so a patch can be sent to another process with encoding/json or
+// encoding/gob like any other value, as long as the values can be; JSON
+// turns numbers into float64s as usual.
+type Op struct {
+	Kind OpKind
+	Key  string
+	Val  Any `json:",omitempty"` // the value set by an OpSet
+}
+
+// OpKind says what an Op does. It's encoded as text, "set" or "delete",
+// by encoding/json.
+type OpKind uint8
+
+const (
+	// OpSet associates Key with Val.
+	OpSet OpKind = iota + 1
+	// OpDelete removes the association for Key, if any.
+	OpDelete
+)
+
+func (k OpKind) String() string {
+	switch k {
+	case OpSet:
+		return "set"
+	case OpDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("OpKind(%d)", k)
+}
+
+func (k OpKind) MarshalText() ([]byte, error) {
+	if k != OpSet && k != OpDelete {
+		return nil, fmt.Errorf("unknown operation kind %d", k)
+	}
+	return []byte(k.String()), nil
+}
+
+func (k *OpKind) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "set":
+		*k = OpSet
+	case "delete":
+		*k = OpDelete
+	default:
+		return fmt.Errorf("unknown operation kind %q", text)
+	}
+	return nil
+}
+
+func (t *tree) Diff(old Map) (added, removed, changed Map) {
+	added, removed, changed = t.empty(), t.empty(), t.empty()
+	if old == nil {
+		old = NewMap()
+	}
+
+	t.ForEach(func(key string, val Any) {
+		prev, ok := old.Lookup(key)
+		switch {
+		case !ok:
+			added = added.Set(key, val)
+		case !valuesEqual(prev, val):
+			changed = changed.Set(key, val)
+		}
+	})
+	old.ForEach(func(key string, val Any) {
+		if _, ok := t.Lookup(key); !ok {
+			removed = removed.Set(key, val)
+		}
+	})
+	return added, removed, changed
+}
+
+func (t *tree) ApplyPatch(added, removed, changed Map) Map {
+	var m Map = t
+	if removed != nil {
+		removed.ForEach(func(key string, _ Any) { m = m.Delete(key) })
+	}
+	for _, patch := range []Map{added, changed} {
+		if patch != nil {
+			patch.ForEach(func(key string, val Any) { m = m.Set(key, val) })
+		}
+	}
+	return m
+}
+
+func (t *tree) PatchFrom(old Map) []Op {
+	added, removed, changed := t.Diff(old)
+	ops := make([]Op, 0, added.Size()+removed.Size()+changed.Size())
+	for _, set := range []Map{added, changed} {
+		set.ForEach(func(key string, val Any) { ops = append(ops, Op{OpSet, key, val}) })
+	}
+	removed.ForEach(func(key string, _ Any) { ops = append(ops, Op{Kind: OpDelete, Key: key}) })
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Key < ops[j].Key })
+	return ops
+}
+
+func (t *tree) ApplyOps(ops []Op) Map {
+	tr := t.AsTransient()
+	for _, op := range ops {
+		switch op.Kind {
+		case OpSet:
+			tr.Set(op.Key, op.Val)
+		case OpDelete:
+			tr.Delete(op.Key)
+		default:
+			panic(fmt.Sprintf("ApplyOps given an operation of unknown kind %d", op.Kind))
+		}
+	}
+	return tr.Persistent()
+}
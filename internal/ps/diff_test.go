@@ -0,0 +1,200 @@
+package ps
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func randomMap(r *rand.Rand, keys int) Map {
+	m := NewMap()
+	for i := 0; i < keys; i++ {
+		if r.Intn(2) == 0 {
+			m = m.Set(strconv.Itoa(i), r.Intn(3))
+		}
+	}
+	return m
+}
+
+func TestDiff(t *testing.T) {
+	old := NewMap().
+		Set("kept", 1).
+		Set("slice", []int{1, 2}).
+		Set("changed", "before").
+		Set("removed", true)
+	next := NewMap().
+		Set("kept", 1).
+		Set("slice", []int{1, 2}).
+		Set("changed", "after").
+		Set("added", 2.5)
+
+	added, removed, changed := next.Diff(old)
+	if !added.Equal(NewMap().Set("added", 2.5)) {
+		t.Errorf("wrong added entries: %s", added)
+	}
+	if !removed.Equal(NewMap().Set("removed", true)) {
+		t.Errorf("wrong removed entries: %s", removed)
+	}
+	if !changed.Equal(NewMap().Set("changed", "after")) {
+		t.Errorf("wrong changed entries: %s", changed)
+	}
+
+	added, removed, changed = next.Diff(next)
+	if !added.IsNil() || !removed.IsNil() || !changed.IsNil() {
+		t.Errorf("a map shouldn't differ from itself")
+	}
+
+	added, removed, changed = NewMap().Diff(old)
+	if !added.IsNil() || !removed.Equal(old) || !changed.IsNil() {
+		t.Errorf("everything should be removed going to the empty map")
+	}
+}
+
+// reading is an Equaler whose equality ignores when it was taken
+type reading struct {
+	Value int
+	At    int64
+}
+
+func (r reading) Equals(other Any) bool {
+	o, ok := other.(reading)
+	return ok && o.Value == r.Value
+}
+
+func TestDiffEqualer(t *testing.T) {
+	old := NewMap().Set("a", reading{1, 100}).Set("b", reading{2, 100})
+	next := NewMap().Set("a", reading{1, 200}).Set("b", reading{3, 200})
+
+	added, removed, changed := next.Diff(old)
+	if !added.IsNil() || !removed.IsNil() {
+		t.Errorf("no keys were added or removed: %s, %s", added, removed)
+	}
+	if changed.Size() != 1 || !changed.Contains("b") {
+		t.Errorf("only b changed, got %s", changed)
+	}
+	same := next.Set("b", reading{2, 300})
+	if !same.Equal(old) {
+		t.Errorf("maps whose values are Equals should be Equal")
+	}
+	if same.Fingerprint() != old.Fingerprint() {
+		t.Errorf("Equal maps should have the same fingerprint")
+	}
+
+	// Set keeps the new value even though it Equals the old one
+	if v, _ := old.Set("a", reading{1, 300}).Lookup("a"); v.(reading).At != 300 {
+		t.Errorf("Set kept the old value: %v", v)
+	}
+}
+
+func TestApplyPatchRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		old := randomMap(r, 50)
+		next := randomMap(r, 50)
+
+		patched := old.ApplyPatch(next.Diff(old))
+		if !patched.Equal(next) {
+			t.Fatalf("round trip failed:\nold: %s\nnext: %s\ngot: %s", old, next, patched)
+		}
+	}
+}
+
+func TestApplyPatchNil(t *testing.T) {
+	m := NewMap().Set("a", 1)
+	if !m.ApplyPatch(nil, nil, nil).Equal(m) {
+		t.Errorf("applying an empty patch changed the map")
+	}
+}
+
+func TestPatchFrom(t *testing.T) {
+	old := NewMap().
+		Set("kept", 1).
+		Set("slice", []int{1, 2}).
+		Set("changed", "before").
+		Set("removed", true)
+	next := NewMap().
+		Set("kept", 1).
+		Set("slice", []int{1, 2}).
+		Set("changed", "after").
+		Set("added", 2.5)
+
+	ops := next.PatchFrom(old)
+	expected := []Op{{OpSet, "added", 2.5}, {OpSet, "changed", "after"}, {Kind: OpDelete, Key: "removed"}}
+	if len(ops) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ops)
+	}
+	for i := range ops {
+		if ops[i] != expected[i] {
+			t.Errorf("op %d: expected %v, got %v", i, expected[i], ops[i])
+		}
+	}
+
+	if ops := next.PatchFrom(next); len(ops) != 0 {
+		t.Errorf("a map shouldn't differ from itself: %v", ops)
+	}
+	if ops := next.PatchFrom(nil); len(ops) != next.Size() {
+		t.Errorf("expected every key set from nil, got %v", ops)
+	}
+	if !NewMap().ApplyOps(nil).IsNil() {
+		t.Errorf("applying no operations changed the map")
+	}
+}
+
+func TestPatchEncoding(t *testing.T) {
+	old := NewMap().Set("kept", "k").Set("changed", "before").Set("removed", true).Set("nil", 1.0)
+	next := NewMap().Set("kept", "k").Set("changed", 2.5).Set("added", false).Set("nil", nil)
+	ops := next.PatchFrom(old)
+
+	data, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `[{"Kind":"set","Key":"added","Val":false},{"Kind":"set","Key":"changed","Val":2.5},` +
+		`{"Kind":"set","Key":"nil"},{"Kind":"delete","Key":"removed"}]`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+	var fromJSON []Op
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !old.ApplyOps(fromJSON).Equal(next) {
+		t.Errorf("the patch decoded from JSON doesn't give the new map: %v", fromJSON)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ops); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var fromGob []Op
+	if err := gob.NewDecoder(&buf).Decode(&fromGob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !old.ApplyOps(fromGob).Equal(next) {
+		t.Errorf("the patch decoded from gob doesn't give the new map: %v", fromGob)
+	}
+
+	if err := json.Unmarshal([]byte(`[{"Kind":"rename","Key":"a"}]`), &fromJSON); err == nil {
+		t.Errorf("expected an error decoding an unknown kind")
+	}
+}
+
+func TestApplyOpsRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		old := randomMap(r, 50)
+		next := randomMap(r, 50)
+
+		ops := next.PatchFrom(old)
+		if patched := old.ApplyOps(ops); !patched.Equal(next) {
+			t.Fatalf("round trip failed:\nold: %s\nnext: %s\ngot: %s", old, next, patched)
+		}
+		added, removed, changed := next.Diff(old)
+		if len(ops) != added.Size()+removed.Size()+changed.Size() {
+			t.Errorf("patch of %d ops isn't minimal", len(ops))
+		}
+	}
+}
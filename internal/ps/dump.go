@@ -0,0 +1,48 @@
+package ps
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (t *tree) Dump() string {
+	if t.IsNil() {
+		return "(empty)\n"
+	}
+	var b strings.Builder
+	t.dump(&b, "root", "")
+	return b.String()
+}
+
+func (t *tree) dump(b *strings.Builder, slot, indent string) {
+	slots := make([]byte, childCount)
+	for i, c := range t.children {
+		slots[i] = '.'
+		if c != nilMap {
+			slots[i] = 'x'
+		}
+	}
+
+	fmt.Fprintf(b, "%s%s hash=%016x key=%s", indent, slot, t.hash, dumpKey(t.key, t.value))
+	if len(t.overflow) > 0 {
+		keys := make([]string, len(t.overflow))
+		for i, e := range t.overflow {
+			keys[i] = dumpKey(e.Key, e.Val)
+		}
+		fmt.Fprintf(b, " overflow=[%s]", strings.Join(keys, " "))
+	}
+	fmt.Fprintf(b, " slots=%s\n", slots)
+
+	for i, c := range t.children {
+		if c != nilMap {
+			c.dump(b, fmt.Sprintf("[%d]", i), indent+"  ")
+		}
+	}
+}
+
+func dumpKey(key string, val Any) string {
+	if isTombstone(val) {
+		return fmt.Sprintf("%q~", key)
+	}
+	return fmt.Sprintf("%q", key)
+}
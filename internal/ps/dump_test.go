@@ -0,0 +1,26 @@
+package ps
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	hashes := map[string]uint64{"a": 0, "b": 1, "c": 1 + 2<<shiftSize, "d": 0}
+	m := setHashed(nilMap, hashes, "a", "b", "c", "d")
+
+	expected := `root hash=0000000000000000 key="a" overflow=["d"] slots=.x......
+  [1] hash=0000000000000001 key="b" slots=..x.....
+    [2] hash=0000000000000011 key="c" slots=........
+`
+	if dump := m.Dump(); dump != expected {
+		t.Errorf("wrong dump:\n%s\nexpected:\n%s", dump, expected)
+	}
+
+	if dump := NewMap().Set("c", 1).TombstoneDelete("c").Dump(); !strings.Contains(dump, `key="c"~`) {
+		t.Errorf("tombstone wasn't marked:\n%s", dump)
+	}
+	if dump := NewMap().Dump(); dump != "(empty)\n" {
+		t.Errorf("wrong dump of the empty map: %q", dump)
+	}
+}
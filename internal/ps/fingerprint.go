@@ -0,0 +1,112 @@
+package ps
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+func (t *tree) Fingerprint() uint64 {
+	// summing the entries' hashes makes the order they're visited in
+	// irrelevant; mixing each one first keeps similar entries from
+	// cancelling out
+	var sum uint64
+	t.ForEach(func(key string, val Any) {
+		sum += mix64(hashKey(key) ^ mix64(valueHash(val)))
+	})
+	return sum
+}
+
+// valueHash returns a hash of v which is the same for values
+// valuesEqual considers equal, as described for Fingerprint
+func valueHash(v Any) uint64 {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case Map:
+		return v.Fingerprint()
+	case Equaler:
+		s = fmt.Sprintf("e %T", v)
+	case string:
+		s = "s" + v
+	case []byte:
+		s = "b" + string(v)
+	default:
+		s = string(appendCanonical(nil, reflect.ValueOf(v)))
+	}
+	return hashKey(s)
+}
+
+// appendCanonical appends a form of v to buf which is the same for values
+// reflect.DeepEqual considers equal, except that pointers are written as
+// their addresses. Floats are written with -0 as 0, which %#v doesn't do,
+// and a Go map's entries are combined without depending on their order.
+func appendCanonical(buf []byte, v reflect.Value) []byte {
+	if !v.IsValid() {
+		return append(buf, "nil"...)
+	}
+	if v.CanInterface() {
+		if m, ok := v.Interface().(Map); ok && m != nil {
+			return strconv.AppendUint(append(buf, "map "...), m.Fingerprint(), 16)
+		}
+	}
+
+	buf = append(append(buf, v.Type().String()...), ' ')
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.AppendBool(buf, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(buf, v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(buf, v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return appendFloat(buf, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return appendFloat(append(appendFloat(buf, real(c)), ','), imag(c))
+	case reflect.String:
+		return strconv.AppendQuote(buf, v.String())
+	case reflect.Interface:
+		return appendCanonical(buf, v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return append(buf, "nil"...)
+		}
+		buf = append(strconv.AppendInt(buf, int64(v.Len()), 10), '[')
+		for i := 0; i < v.Len(); i++ {
+			buf = append(appendCanonical(buf, v.Index(i)), ',')
+		}
+		return append(buf, ']')
+	case reflect.Struct:
+		buf = append(buf, '{')
+		for i := 0; i < v.NumField(); i++ {
+			buf = append(appendCanonical(buf, v.Field(i)), ',')
+		}
+		return append(buf, '}')
+	case reflect.Map:
+		if v.IsNil() {
+			return append(buf, "nil"...)
+		}
+		// like Fingerprint, summing makes the order irrelevant
+		var sum uint64
+		var entry []byte
+		for it := v.MapRange(); it.Next(); {
+			entry = appendCanonical(append(appendCanonical(entry[:0], it.Key()), ':'), it.Value())
+			sum += mix64(hashKey(string(entry)))
+		}
+		buf = append(strconv.AppendInt(buf, int64(v.Len()), 10), '{')
+		return append(strconv.AppendUint(buf, sum, 16), '}')
+	}
+	// pointers, channels and functions
+	return strconv.AppendUint(buf, uint64(v.Pointer()), 16)
+}
+
+// appendFloat appends f to buf, writing -0 as 0 since they're equal
+func appendFloat(buf []byte, f float64) []byte {
+	if f == 0 {
+		f = 0
+	}
+	return strconv.AppendUint(buf, math.Float64bits(f), 16)
+}
@@ -0,0 +1,78 @@
+package ps
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	forward, backward := NewMap(), NewMap()
+	for i := 0; i < 500; i++ {
+		forward = forward.Set(strconv.Itoa(i), i)
+		backward = backward.Set(strconv.Itoa(499-i), 499-i)
+	}
+	if forward.Fingerprint() != backward.Fingerprint() {
+		t.Errorf("maps built in different orders should share a fingerprint")
+	}
+	if forward.Fingerprint() == forward.Set("0", "changed").Fingerprint() {
+		t.Errorf("changing a value should change the fingerprint")
+	}
+	if forward.Fingerprint() == forward.Delete("0").Fingerprint() {
+		t.Errorf("deleting a key should change the fingerprint")
+	}
+	if forward.TombstoneDelete("0").Fingerprint() != forward.Delete("0").Fingerprint() {
+		t.Errorf("tombstoned keys shouldn't count")
+	}
+
+	// swapping values between keys keeps the same multiset of both
+	a := NewMap().Set("x", 1).Set("y", 2)
+	b := NewMap().Set("x", 2).Set("y", 1)
+	if a.Fingerprint() == b.Fingerprint() {
+		t.Errorf("values should be tied to their keys")
+	}
+	if NewMap().Set("k", 1).Fingerprint() == NewMap().Set("k", "1").Fingerprint() {
+		t.Errorf("values of different types should differ")
+	}
+
+	nested := NewMap().Set("db", NewMap().Set("host", "h").Set("port", 1))
+	rebuilt := NewMap().Set("db", NewMap().Set("port", 1).Set("host", "h"))
+	if nested.Fingerprint() != rebuilt.Fingerprint() {
+		t.Errorf("equal nested maps should share a fingerprint")
+	}
+	if NewMap().Set("f", 0.0).Fingerprint() != NewMap().Set("f", math.Copysign(0, -1)).Fingerprint() {
+		t.Errorf("0 and -0 are equal, so should share a fingerprint")
+	}
+	negZero := math.Copysign(0, -1)
+	type point struct{ X, Y float64 }
+	for _, pair := range [][2]Any{
+		{float32(0), float32(negZero)},
+		{[]Any{0.0}, []Any{negZero}},
+		{point{0, 1}, point{negZero, 1}},
+		{[2]complex128{complex(0, 0)}, [2]complex128{complex(negZero, negZero)}},
+		{map[string]Any{"a": 0.0, "b": []int{1}}, map[string]Any{"b": []int{1}, "a": negZero}},
+		{[]Any{NewMap().Set("a", 1)}, []Any{NewMap().Set("a", 1)}},
+	} {
+		a, b := NewMap().Set("v", pair[0]), NewMap().Set("v", pair[1])
+		if !a.Equal(b) {
+			t.Fatalf("%#v and %#v should be equal", pair[0], pair[1])
+		}
+		if a.Fingerprint() != b.Fingerprint() {
+			t.Errorf("equal values %#v and %#v should share a fingerprint", pair[0], pair[1])
+		}
+	}
+	for _, pair := range [][2]Any{
+		{[]int(nil), []int{}},
+		{point{1, 2}, point{2, 1}},
+		{map[string]int{"a": 1}, map[string]int{"a": 2}},
+		{[]Any{int32(1)}, []Any{int64(1)}},
+	} {
+		if NewMap().Set("v", pair[0]).Fingerprint() == NewMap().Set("v", pair[1]).Fingerprint() {
+			t.Errorf("different values %#v and %#v share a fingerprint", pair[0], pair[1])
+		}
+	}
+
+	if NewMap().Fingerprint() != NewMapWithBranching(1).Fingerprint() {
+		t.Errorf("empty maps should share a fingerprint")
+	}
+}
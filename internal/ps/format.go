@@ -0,0 +1,61 @@
+package ps
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FormatOptions controls how Map.Format describes a map. The zero value
+// gives the default format used by String: keys in sorted order, written
+// as {key: value, key: value} with values formatted by %v.
+//
+// An empty Separator or Delimiter stands for the default, so that the zero
+// value works, which means neither can be set to the empty string: entries
+// are always separated, and keys always delimited from their values, by at
+// least one character.
+type FormatOptions struct {
+	// Unsorted writes the entries in the order ForEach visits them rather
+	// than sorting them by key, which is faster for large maps.
+	Unsorted bool
+
+	// Separator goes between entries. An empty Separator means ", ".
+	Separator string
+
+	// Delimiter goes between each key and its value. An empty Delimiter
+	// means ": ".
+	Delimiter string
+
+	// TrailingNewline adds a newline after the closing brace.
+	TrailingNewline bool
+}
+
+func (t *tree) Format(opts FormatOptions) string {
+	sep, delim := opts.Separator, opts.Delimiter
+	if sep == "" {
+		sep = ", "
+	}
+	if delim == "" {
+		delim = ": "
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	first := true
+	write := func(key string, val Any) {
+		if !first {
+			b.WriteString(sep)
+		}
+		first = false
+		fmt.Fprintf(&b, "%s%s%v", key, delim, val)
+	}
+	if opts.Unsorted {
+		t.ForEach(write)
+	} else {
+		t.ForEachSorted(write)
+	}
+	b.WriteByte('}')
+	if opts.TrailingNewline {
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
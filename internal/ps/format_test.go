@@ -0,0 +1,42 @@
+package ps
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	m := NewMap().Set("b", 2).Set("a", "one").Set("c", []int{3})
+
+	tests := []struct {
+		opts     FormatOptions
+		expected string
+	}{
+		{FormatOptions{}, "{a: one, b: 2, c: [3]}"},
+		{FormatOptions{Separator: "; "}, "{a: one; b: 2; c: [3]}"},
+		{FormatOptions{Delimiter: "="}, "{a=one, b=2, c=[3]}"},
+		{FormatOptions{Separator: ",", Delimiter: ":", TrailingNewline: true}, "{a:one,b:2,c:[3]}\n"},
+	}
+	for _, test := range tests {
+		if got := m.Format(test.opts); got != test.expected {
+			t.Errorf("Format(%+v) = %q, expected %q", test.opts, got, test.expected)
+		}
+	}
+
+	if got := m.String(); got != m.Format(FormatOptions{}) {
+		t.Errorf("String() should use the default format: %q", got)
+	}
+	if got := NewMap().String(); got != "{}" {
+		t.Errorf("wrong format for the empty map: %q", got)
+	}
+
+	// unsorted output has the same entries in ForEach order
+	unsorted := numbers(20).Format(FormatOptions{Unsorted: true, Separator: " "})
+	expected := "{"
+	numbers(20).ForEach(func(k string, v Any) {
+		if len(expected) > 1 {
+			expected += " "
+		}
+		expected += k + ": " + k
+	})
+	if unsorted != expected+"}" {
+		t.Errorf("wrong unsorted format: %q", unsorted)
+	}
+}
@@ -0,0 +1,115 @@
+package ps
+
+import "sort"
+
+func (t *tree) Filter(pred func(key string, val Any) bool) Map {
+	var m Map = t
+	t.ForEach(func(key string, val Any) {
+		if !pred(key, val) {
+			m = m.Delete(key)
+		}
+	})
+	if m.Size() == 0 {
+		return t.empty()
+	}
+	return m
+}
+
+func (t *tree) Partition(pred func(key string, val Any) bool) (matched, rest Map) {
+	matched, rest = t, t
+	t.ForEach(func(key string, val Any) {
+		if pred(key, val) {
+			rest = rest.Delete(key)
+		} else {
+			matched = matched.Delete(key)
+		}
+	})
+	if matched.Size() == 0 {
+		matched = t.empty()
+	}
+	if rest.Size() == 0 {
+		rest = t.empty()
+	}
+	return matched, rest
+}
+
+func (t *tree) GroupBy(keyFn func(key string, val Any) string) Map {
+	groups := map[string][]Any{}
+	t.ForEachSorted(func(key string, val Any) {
+		category := keyFn(key, val)
+		groups[category] = append(groups[category], val)
+	})
+
+	tr := NewMap().AsTransient()
+	for category, vals := range groups {
+		tr.Set(category, vals)
+	}
+	return tr.Persistent()
+}
+
+func (t *tree) Project(mapping map[string]string, keepUnmapped bool) Map {
+	tr := t.empty().AsTransient()
+	var renamed []Entry
+	forEachStored(t, func(key string, _, stored Any) {
+		if _, ok := mapping[key]; ok {
+			renamed = append(renamed, Entry{key, stored})
+		} else if keepUnmapped {
+			tr.Set(key, stored)
+		}
+	})
+
+	sort.Slice(renamed, func(i, j int) bool { return renamed[i].Key < renamed[j].Key })
+	for _, e := range renamed {
+		tr.Set(mapping[e.Key], e.Val)
+	}
+	return tr.Persistent()
+}
+
+func (t *tree) CountBy(pred func(key string, val Any) bool) int {
+	count := 0
+	t.ForEach(func(key string, val Any) {
+		if pred(key, val) {
+			count++
+		}
+	})
+	return count
+}
+
+func (t *tree) FindFirst(pred func(key string, val Any) bool) (key string, val Any, found bool) {
+	t.all(func(k string, v Any) bool {
+		if pred(k, v) {
+			key, val, found = k, v, true
+		}
+		return !found
+	})
+	return key, val, found
+}
+
+func (t *tree) MapValues(f func(key string, val Any) Any) Map {
+	if t.IsNil() {
+		return t
+	}
+	return t.mapValues(f)
+}
+
+func (t *tree) mapValues(f func(key string, val Any) Any) *tree {
+	m := t.mapNodeValues(f)
+	for i, c := range t.children {
+		if c != nilMap {
+			m.children[i] = c.mapValues(f)
+		}
+	}
+	return m
+}
+
+func (t *tree) Fold(initial Any, f func(acc Any, key string, val Any) Any) Any {
+	acc := initial
+	t.ForEach(func(k string, v Any) { acc = f(acc, k, v) })
+	return acc
+}
+
+func (t *tree) FoldSorted(initial Any, f func(acc Any, key string, val Any) Any) Any {
+	acc := initial
+	t.ForEachSorted(func(k string, v Any) { acc = f(acc, k, v) })
+	return acc
+}
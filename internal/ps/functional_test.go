@@ -0,0 +1,242 @@
+package ps
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func numbers(n int) Map {
+	m := NewMap()
+	for i := 0; i < n; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	return m
+}
+
+func TestFilter(t *testing.T) {
+	m := numbers(100)
+
+	even := m.Filter(func(k string, v Any) bool { return v.(int)%2 == 0 })
+	if even.Size() != 50 {
+		t.Errorf("expected 50 even numbers, got %d", even.Size())
+	}
+	even.ForEach(func(k string, v Any) {
+		if v.(int)%2 != 0 {
+			t.Errorf("odd value %v passed the filter", v)
+		}
+	})
+	if m.Size() != 100 {
+		t.Errorf("Filter() modified the receiving map")
+	}
+
+	if all := m.Filter(func(string, Any) bool { return true }); all != m {
+		t.Errorf("accepting every entry should return the receiver")
+	}
+	if none := m.Filter(func(string, Any) bool { return false }); !none.IsNil() {
+		t.Errorf("rejecting every entry should give an empty map")
+	}
+
+	prefixed := NewMap().Set("db.host", 1).Set("db.port", 2).Set("cache.ttl", 3)
+	db := prefixed.Filter(func(k string, _ Any) bool { return strings.HasPrefix(k, "db.") })
+	if !db.Equal(NewMap().Set("db.host", 1).Set("db.port", 2)) {
+		t.Errorf("wrong prefix filter: %s", db)
+	}
+}
+
+func TestMapValues(t *testing.T) {
+	m := numbers(100)
+
+	calls := map[string]int{}
+	doubled := m.MapValues(func(k string, v Any) Any {
+		calls[k]++
+		return v.(int) * 2
+	})
+
+	for i := 0; i < 100; i++ {
+		k := strconv.Itoa(i)
+		if v, _ := doubled.Lookup(k); v != i*2 {
+			t.Errorf("wrong value for %s: %v", k, v)
+		}
+		if v, _ := m.Lookup(k); v != i {
+			t.Errorf("MapValues() modified the receiving map")
+		}
+		if calls[k] != 1 {
+			t.Errorf("f called %d times for %s", calls[k], k)
+		}
+	}
+	if doubled.Size() != 100 {
+		t.Errorf("wrong size: %d", doubled.Size())
+	}
+}
+
+func TestFold(t *testing.T) {
+	sum := numbers(100).Fold(0, func(acc Any, _ string, v Any) Any {
+		return acc.(int) + v.(int)
+	})
+	if sum != 4950 {
+		t.Errorf("wrong sum: %v", sum)
+	}
+
+	if got := NewMap().Fold("initial", func(Any, string, Any) Any { return "called" }); got != "initial" {
+		t.Errorf("folding an empty map should return the initial value, got %v", got)
+	}
+}
+
+func TestFoldSorted(t *testing.T) {
+	m := NewMap().Set("c", 3).Set("a", 1).Set("d", 4).Set("b", 2)
+	keys := m.FoldSorted("", func(acc Any, k string, _ Any) Any {
+		return acc.(string) + k
+	})
+	if keys != "abcd" {
+		t.Errorf("wrong key order: %v", keys)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	m := NewMap().
+		Set("internal.token", "secret").
+		Set("internal.debug", true).
+		Set("public.name", "ps").
+		Set("version", 2)
+
+	calls := 0
+	internal, public := m.Partition(func(k string, _ Any) bool {
+		calls++
+		return strings.HasPrefix(k, "internal.")
+	})
+	if calls != m.Size() {
+		t.Errorf("pred called %d times for %d entries", calls, m.Size())
+	}
+	if internal.Size()+public.Size() != m.Size() {
+		t.Errorf("partitions don't cover the map: %d + %d", internal.Size(), public.Size())
+	}
+	for _, k := range m.Keys() {
+		if internal.Contains(k) == public.Contains(k) {
+			t.Errorf("%s should be in exactly one partition", k)
+		}
+	}
+	if !internal.Contains("internal.token") || !public.Contains("version") {
+		t.Errorf("keys in the wrong partition: %s, %s", internal, public)
+	}
+	if m.Size() != 4 {
+		t.Errorf("Partition() modified the receiving map")
+	}
+
+	all, none := m.Partition(func(string, Any) bool { return true })
+	if all != m || !none.IsNil() {
+		t.Errorf("when everything matches, expected the map and an empty map")
+	}
+}
+
+func TestCountBy(t *testing.T) {
+	m := numbers(100).Set("string", "not a number")
+	even := m.CountBy(func(_ string, v Any) bool {
+		i, ok := v.(int)
+		return ok && i%2 == 0
+	})
+	if even != 50 {
+		t.Errorf("wrong count of even values: %d", even)
+	}
+	if n := NewMap().CountBy(func(string, Any) bool { return true }); n != 0 {
+		t.Errorf("empty map should count nothing: %d", n)
+	}
+}
+
+func TestProject(t *testing.T) {
+	m := NewMap().Set("userName", "ada").Set("userAge", 36).Set("internal", true)
+	mapping := map[string]string{"userName": "name", "userAge": "age", "missing": "x"}
+
+	projected := m.Project(mapping, false)
+	expected := NewMap().Set("name", "ada").Set("age", 36)
+	if !projected.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, projected)
+	}
+
+	kept := m.Project(mapping, true)
+	expected = expected.Set("internal", true)
+	if !kept.Equal(expected) {
+		t.Errorf("expected %s keeping unmapped keys, got %s", expected, kept)
+	}
+	if m.Size() != 3 {
+		t.Errorf("Project() modified the receiving map")
+	}
+
+	// collisions: renamed beats kept, then the last source key wins
+	m = NewMap().Set("a", 1).Set("b", 2).Set("c", 3).Set("x", 4)
+	collide := map[string]string{"a": "x", "c": "x", "b": "b2"}
+	if got := m.Project(collide, true); !got.Equal(NewMap().Set("x", 3).Set("b2", 2)) {
+		t.Errorf("wrong result of colliding names: %s", got)
+	}
+	for i := 0; i < 10; i++ {
+		if v, _ := m.Project(collide, false).Lookup("x"); v != 3 {
+			t.Fatalf("expected c's value to win, got %v", v)
+		}
+	}
+
+	if !NewMap().Project(mapping, true).IsNil() {
+		t.Errorf("projecting an empty map should give an empty map")
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	m := NewMap().Set("a", 1).Set("b", 2).Set("c", 3).Set("d", 4).Set("e", 5)
+
+	calls := 0
+	groups := m.GroupBy(func(_ string, v Any) string {
+		calls++
+		if v.(int)%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	})
+	if calls != 5 || groups.Size() != 2 {
+		t.Errorf("expected 2 groups from 5 calls, got %d from %d", groups.Size(), calls)
+	}
+	if even, _ := groups.Lookup("even"); !reflect.DeepEqual(even, []Any{2, 4}) {
+		t.Errorf("wrong even group: %v", even)
+	}
+	if odd, _ := groups.Lookup("odd"); !reflect.DeepEqual(odd, []Any{1, 3, 5}) {
+		t.Errorf("wrong odd group: %v", odd)
+	}
+
+	config := NewMap().Set("db.host", "h").Set("db.port", 1).Set("log.level", "debug")
+	byNamespace := config.GroupBy(func(k string, _ Any) string {
+		return strings.SplitN(k, ".", 2)[0]
+	})
+	if db, _ := byNamespace.Lookup("db"); !reflect.DeepEqual(db, []Any{"h", 1}) {
+		t.Errorf("wrong db group: %v", db)
+	}
+
+	if !NewMap().GroupBy(func(string, Any) string { return "" }).IsNil() {
+		t.Errorf("grouping an empty map should give an empty map")
+	}
+}
+
+func TestFindFirst(t *testing.T) {
+	m := numbers(100)
+
+	calls := 0
+	k, v, found := m.FindFirst(func(_ string, v Any) bool {
+		calls++
+		return v == 42
+	})
+	if !found || k != "42" || v != 42 {
+		t.Errorf("wrong entry found: %s %v %v", k, v, found)
+	}
+	if calls == 100 && m.Keys()[99] != "42" {
+		t.Errorf("search didn't stop at the match")
+	}
+
+	calls = 0
+	if _, _, found := m.FindFirst(func(string, Any) bool { calls++; return false }); found {
+		t.Errorf("found an entry matching nothing")
+	}
+	if calls != 100 {
+		t.Errorf("a failed search should visit every entry, visited %d", calls)
+	}
+	if _, _, found := NewMap().FindFirst(func(string, Any) bool { return true }); found {
+		t.Errorf("found an entry in the empty map")
+	}
+}
@@ -0,0 +1,69 @@
+package ps
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+func init() {
+	// nested maps are sent as MapValues, see GobEncode
+	gob.Register(MapValue{})
+}
+
+// gobEntry is how an entry is sent by GobEncode. Gob only encodes exported
+// fields, so Entry can't be reused for this.
+type gobEntry struct {
+	Key string
+	Val interface{}
+}
+
+// GobEncode encodes the map's entries in sorted key order. Values are sent
+// as interface values, so like anything else stored in an interface their
+// concrete types must be registered with gob.Register, on both the
+// encoding and the decoding side; only gob's predeclared types, such as
+// string and int, and nested Maps work without registration.
+func (v MapValue) GobEncode() ([]byte, error) {
+	var entries []gobEntry
+	if v.Map != nil {
+		entries = make([]gobEntry, 0, v.Size())
+		v.ForEachSorted(func(key string, val Any) {
+			if nested, ok := val.(Map); ok {
+				val = MapValue{nested}
+			}
+			entries = append(entries, gobEntry{key, val})
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entries); err != nil {
+		return nil, fmt.Errorf("encoding map: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode replaces the held map with one decoded from data. If the held
+// map restricts its keys, the decoded keys must be accepted by it.
+func (v *MapValue) GobDecode(data []byte) error {
+	var entries []gobEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entries); err != nil {
+		return fmt.Errorf("decoding map: %w", err)
+	}
+
+	m := NewMap()
+	if t, ok := v.Map.(*tree); ok {
+		m = t.empty()
+	}
+	for _, e := range entries {
+		val := e.Val
+		if nested, ok := val.(MapValue); ok {
+			val = nested.Map
+		}
+		var err error
+		if m, err = m.SetChecked(e.Key, val); err != nil {
+			return err
+		}
+	}
+	v.Map = m
+	return nil
+}
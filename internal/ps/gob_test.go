@@ -0,0 +1,56 @@
+package ps
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+type gobPoint struct{ X, Y int }
+
+func init() {
+	gob.Register(gobPoint{})
+}
+
+func gobRoundTrip(t *testing.T, m Map) Map {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(MapValue{m}); err != nil {
+		t.Fatal(err)
+	}
+	var v MapValue
+	if err := gob.NewDecoder(&buf).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v.Map
+}
+
+func TestGob(t *testing.T) {
+	m := NewMap().Set("name", "ps").Set("count", 42).Set("point", gobPoint{1, 2})
+	if got := gobRoundTrip(t, m); !got.Equal(m) {
+		t.Errorf("round trip changed the map: %s", got)
+	}
+
+	nested := NewMap().Set("inner", NewMap().Set("a", 1))
+	got := gobRoundTrip(t, nested)
+	if inner, _ := got.Lookup("inner"); inner == nil {
+		t.Errorf("nested map was lost")
+	} else if _, ok := inner.(Map); !ok {
+		t.Errorf("nested map decoded as %T", inner)
+	}
+	if !got.Equal(nested) {
+		t.Errorf("round trip changed the nested map: %s", got)
+	}
+
+	if got := gobRoundTrip(t, NewMap()); !got.IsNil() {
+		t.Errorf("empty map should decode as empty: %s", got)
+	}
+}
+
+func TestGobUnregisteredType(t *testing.T) {
+	type unregistered struct{ A int }
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(MapValue{NewMap().Set("a", unregistered{1})})
+	if err == nil {
+		t.Errorf("expected an error for an unregistered value type")
+	}
+}
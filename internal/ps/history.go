@@ -0,0 +1,87 @@
+package ps
+
+// History retains the last few versions of a map, for undo and redo.
+// Versions are immutable, so keeping one around only costs a pointer.
+//
+// Unlike Map, a History is mutable and isn't safe for concurrent use.
+type History struct {
+	versions []Map // ring buffer holding up to cap(versions) versions
+	start    int   // index of the oldest retained version
+	length   int   // number of versions up to and including the current one
+	undone   int   // number of undone versions after the current one
+}
+
+// NewHistory returns a History which retains up to n versions. It panics if
+// n is less than 1.
+func NewHistory(n int) *History {
+	if n < 1 {
+		panic("History must retain at least one version")
+	}
+	return &History{versions: make([]Map, n)}
+}
+
+// Commit records m as the current version, forgetting any undone versions
+// and, if the History is full, the oldest version.
+func (h *History) Commit(m Map) {
+	for ; h.undone > 0; h.undone-- {
+		h.versions[h.index(-h.undone)] = nil
+	}
+	if h.length < len(h.versions) {
+		h.length++
+	} else {
+		h.start = (h.start + 1) % len(h.versions)
+	}
+	h.versions[h.index(0)] = m
+}
+
+// Len returns the number of retained versions up to and including the
+// current one, so those which can be reached with Undo.
+func (h *History) Len() int {
+	return h.length
+}
+
+// Current returns the current version, or nil if nothing was committed.
+func (h *History) Current() Map {
+	return h.At(0)
+}
+
+// At returns the version i steps before the current one, so that At(0) is
+// the current version. It returns nil if fewer than i+1 versions are
+// retained.
+func (h *History) At(i int) Map {
+	if i < 0 || i >= h.length {
+		return nil
+	}
+	return h.versions[h.index(i)]
+}
+
+// Undo makes the previous version current and returns it. The undone
+// version is kept for Redo until the next Commit. If there is no previous
+// version, the current one (or nil, if nothing was committed) is returned
+// and the second return value is false.
+func (h *History) Undo() (Map, bool) {
+	if h.length <= 1 {
+		return h.Current(), false
+	}
+	h.length--
+	h.undone++
+	return h.Current(), true
+}
+
+// Redo makes the most recently undone version current again and returns
+// it. If nothing was undone since the last Commit, the current version is
+// returned and the second return value is false.
+func (h *History) Redo() (Map, bool) {
+	if h.undone == 0 {
+		return h.Current(), false
+	}
+	h.undone--
+	h.length++
+	return h.Current(), true
+}
+
+// index returns the position in the ring of the version i steps before the
+// current one; negative steps count undone versions after it
+func (h *History) index(i int) int {
+	return (h.start + h.length - 1 - i) % len(h.versions)
+}
@@ -0,0 +1,120 @@
+package ps
+
+import "testing"
+
+func TestHistoryAt(t *testing.T) {
+	h := NewHistory(3)
+	if h.At(0) != nil || h.Current() != nil {
+		t.Errorf("empty history has a current version")
+	}
+
+	m := NewMap()
+	for i := 0; i < 5; i++ {
+		m = m.Set("version", i)
+		h.Commit(m)
+	}
+
+	if h.Len() != 3 {
+		t.Fatalf("history should retain 3 versions, has %d", h.Len())
+	}
+	for i := 0; i < 3; i++ {
+		if v, _ := h.At(i).Lookup("version"); v != 4-i {
+			t.Errorf("At(%d) has version %v", i, v)
+		}
+	}
+	if h.At(3) != nil {
+		t.Errorf("history retained too many versions")
+	}
+	if h.Current() != m {
+		t.Errorf("Current() isn't the last committed version")
+	}
+}
+
+func TestHistoryUndo(t *testing.T) {
+	h := NewHistory(5)
+	first := NewMap().Set("a", 1)
+	second := first.Set("b", 2)
+	third := second.Delete("a")
+	h.Commit(first)
+	h.Commit(second)
+	h.Commit(third)
+
+	if m, ok := h.Undo(); !ok || m != second {
+		t.Errorf("Undo() didn't return the previous version")
+	}
+	if m, ok := h.Undo(); !ok || m != first {
+		t.Errorf("Undo() didn't return the first version")
+	}
+	if m, ok := h.Undo(); ok || m != first || h.Len() != 1 {
+		t.Errorf("Undo() stepped back past the first version")
+	}
+
+	// versions are unaffected by later changes
+	if v, _ := second.Lookup("a"); v != 1 || second.Size() != 2 {
+		t.Errorf("second version lost its contents")
+	}
+
+	if m, ok := NewHistory(1).Undo(); ok || m != nil {
+		t.Errorf("undoing an empty history should fail")
+	}
+}
+
+func TestHistoryRedo(t *testing.T) {
+	h := NewHistory(4)
+	if _, ok := h.Redo(); ok {
+		t.Errorf("redoing an empty history should fail")
+	}
+
+	v := make([]Map, 4)
+	for i := range v {
+		v[i] = NewMap().Set("version", i)
+	}
+	h.Commit(v[0])
+	h.Commit(v[1])
+	h.Commit(v[2])
+	if _, ok := h.Redo(); ok {
+		t.Errorf("nothing was undone, so Redo() should fail")
+	}
+
+	h.Undo()
+	h.Undo()
+	if m, ok := h.Redo(); !ok || m != v[1] {
+		t.Errorf("Redo() didn't return the undone version")
+	}
+	if m, ok := h.Redo(); !ok || m != v[2] || h.Current() != v[2] {
+		t.Errorf("Redo() didn't return the last version")
+	}
+	if m, ok := h.Redo(); ok || m != v[2] {
+		t.Errorf("Redo() went past the last version")
+	}
+
+	// committing after an undo abandons the redo branch
+	h.Undo()
+	h.Commit(v[3])
+	if _, ok := h.Redo(); ok {
+		t.Errorf("Commit() should forget undone versions")
+	}
+	if h.Len() != 3 || h.At(1) != v[1] || h.At(2) != v[0] {
+		t.Errorf("wrong versions after committing: %d", h.Len())
+	}
+	if m, ok := h.Undo(); !ok || m != v[1] {
+		t.Errorf("Undo() after the new commit should return the version it replaced")
+	}
+}
+
+func TestHistoryRedoFull(t *testing.T) {
+	// a full ring keeps undone versions until they're overwritten
+	h := NewHistory(2)
+	a, b, c := NewMap().Set("a", 1), NewMap().Set("b", 2), NewMap().Set("c", 3)
+	h.Commit(a)
+	h.Commit(b)
+	h.Undo()
+	h.Commit(c)
+	if h.Len() != 2 || h.At(0) != c || h.At(1) != a {
+		t.Errorf("wrong versions after committing over an undone one")
+	}
+	h.Undo()
+	if m, ok := h.Redo(); !ok || m != c {
+		t.Errorf("Redo() in a full ring didn't return the undone version")
+	}
+}
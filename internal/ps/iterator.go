@@ -0,0 +1,72 @@
+package ps
+
+// Iterator pulls the entries of a map one at a time. Since maps are
+// immutable, it always reflects the map it was created from, and it may be
+// abandoned at any point.
+//
+// Unlike Map, an Iterator is mutable and isn't safe for concurrent use.
+type Iterator struct {
+	stack []*tree // nodes still to visit
+	node  *tree   // node whose entries are being returned
+	pos   int     // next entry of node: 0 for its own, i+1 for overflow[i]
+}
+
+func (t *tree) Iterator() *Iterator {
+	it := &Iterator{}
+	if !t.IsNil() {
+		it.stack = []*tree{t}
+	}
+	return it
+}
+
+// Next returns the next key value pair. Once every entry has been
+// returned, ok is false.
+func (it *Iterator) Next() (key string, val Any, ok bool) {
+	for {
+		for it.node != nil && it.pos <= len(it.node.overflow) {
+			if it.pos == 0 {
+				key, val = it.node.key, it.node.value
+			} else {
+				e := it.node.overflow[it.pos-1]
+				key, val = e.Key, e.Val
+			}
+			it.pos++
+			if val, ok := resolve(val); ok {
+				return key, val, true
+			}
+		}
+
+		if len(it.stack) == 0 {
+			it.node = nil
+			return "", nil, false
+		}
+
+		// pop the next node, pushing its children so that the first is
+		// visited next
+		n := it.stack[len(it.stack)-1]
+		it.stack = it.stack[:len(it.stack)-1]
+		for i := len(n.children) - 1; i >= 0; i-- {
+			if n.children[i] != nilMap {
+				it.stack = append(it.stack, n.children[i])
+			}
+		}
+		it.node, it.pos = n, 0
+	}
+}
+
+func (t *tree) All() func(yield func(key string, val Any) bool) {
+	return func(yield func(key string, val Any) bool) {
+		t.all(yield)
+	}
+}
+
+func (t *tree) SortedAll() func(yield func(key string, val Any) bool) {
+	return func(yield func(key string, val Any) bool) {
+		for _, key := range t.SortedKeys() {
+			val, _ := t.Lookup(key)
+			if !yield(key, val) {
+				return
+			}
+		}
+	}
+}
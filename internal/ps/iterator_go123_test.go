@@ -0,0 +1,38 @@
+//go:build go1.23
+
+package ps
+
+import (
+	"iter"
+	"testing"
+)
+
+func TestAllRange(t *testing.T) {
+	m := numbers(100)
+
+	var seq iter.Seq2[string, Any] = m.All()
+	visited := 0
+	for k, v := range seq {
+		if got, _ := m.Lookup(k); got != v {
+			t.Errorf("wrong value for %s: %v", k, v)
+		}
+		visited++
+		if visited == 10 {
+			break
+		}
+	}
+	if visited != 10 {
+		t.Errorf("range didn't stop at break: %d", visited)
+	}
+
+	var keys []string
+	for k := range m.SortedAll() {
+		keys = append(keys, k)
+		if k == "10" {
+			break
+		}
+	}
+	if len(keys) != 3 || keys[0] != "0" || keys[1] != "1" || keys[2] != "10" {
+		t.Errorf("wrong sorted range: %v", keys)
+	}
+}
@@ -0,0 +1,98 @@
+package ps
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestIteratorDrain(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 500; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	m = m.TombstoneDelete("7")
+
+	var forEach []string
+	m.ForEach(func(k string, v Any) { forEach = append(forEach, k) })
+
+	it := m.Iterator()
+	visited := 0
+	for {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		if k != forEach[visited] {
+			t.Fatalf("entry %d: expected %s, got %s", visited, forEach[visited], k)
+		}
+		if expected, _ := m.Lookup(k); v != expected {
+			t.Errorf("wrong value for %s: %v", k, v)
+		}
+		visited++
+	}
+	if visited != m.Size() {
+		t.Errorf("visited %d entries, expected %d", visited, m.Size())
+	}
+	if _, _, ok := it.Next(); ok {
+		t.Errorf("drained iterator returned another entry")
+	}
+
+	if _, _, ok := NewMap().Iterator().Next(); ok {
+		t.Errorf("empty map returned an entry")
+	}
+}
+
+func TestIteratorEarlyStop(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 100; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+
+	it := m.Iterator()
+	seen := map[string]bool{}
+	for len(seen) < 10 {
+		k, _, ok := it.Next()
+		if !ok {
+			t.Fatalf("iterator ended early")
+		}
+		seen[k] = true
+	}
+
+	// iterating the snapshot is unaffected by later versions
+	m2 := m.Set("new", true)
+	m2.Iterator().Next()
+	if k, _, ok := it.Next(); !ok || seen[k] || k == "new" {
+		t.Errorf("resumed iterator returned %q", k)
+	}
+}
+
+func TestAll(t *testing.T) {
+	m := NewMap().Set("c", 3).Set("a", 1).Set("b", 2)
+
+	var keys []string
+	m.All()(func(k string, v Any) bool {
+		keys = append(keys, k)
+		return true
+	})
+	if len(keys) != 3 {
+		t.Errorf("wrong number of pairs: %v", keys)
+	}
+
+	calls := 0
+	m.All()(func(string, Any) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("sequence continued after yield returned false: %d calls", calls)
+	}
+
+	keys = nil
+	m.SortedAll()(func(k string, _ Any) bool {
+		keys = append(keys, k)
+		return k != "b"
+	})
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("wrong sorted sequence: %v", keys)
+	}
+}
@@ -0,0 +1,139 @@
+package ps
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+func (t *tree) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	var err error
+	for i, key := range t.SortedKeys() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		var encoded []byte
+		if encoded, err = json.Marshal(key); err != nil {
+			return nil, err
+		}
+		buf.Write(encoded)
+		buf.WriteByte(':')
+		val, _ := t.Lookup(key)
+		if encoded, err = json.Marshal(val); err != nil {
+			return nil, fmt.Errorf("encoding value of %q: %w", key, err)
+		}
+		buf.Write(encoded)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MapValue holds a Map so that it can be decoded in place. Map is an
+// interface, so decoders such as encoding/json and encoding/gob have
+// nothing to allocate when decoding into a plain Map variable; decode into
+// a MapValue instead and use its Map.
+//
+// The zero MapValue encodes as an empty map.
+type MapValue struct {
+	Map
+}
+
+// MarshalJSON encodes the map as a JSON object with its keys in sorted
+// order.
+func (v MapValue) MarshalJSON() ([]byte, error) {
+	if v.Map == nil {
+		return []byte("{}"), nil
+	}
+	return v.Map.MarshalJSON()
+}
+
+// UnmarshalJSON replaces the held map with one built from a JSON object.
+// Values are decoded as by json.Unmarshal into an interface{}, so nested
+// objects become map[string]interface{} rather than Maps. If the held map
+// restricts its keys, the decoded keys must be accepted by it.
+func (v *MapValue) UnmarshalJSON(data []byte) error {
+	var entries map[string]interface{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	if entries == nil {
+		return fmt.Errorf("cannot decode %s into a map", data)
+	}
+
+	m := NewMap()
+	if t, ok := v.Map.(*tree); ok {
+		m = t.empty()
+	}
+	for key, val := range entries {
+		var err error
+		if m, err = m.SetChecked(key, val); err != nil {
+			return err
+		}
+	}
+	v.Map = m
+	return nil
+}
+
+// DecodeJSONStream reads a JSON object from r and returns a map of its
+// members. Unlike MapValue's UnmarshalJSON, which needs the whole object in
+// memory first, it reads the object one member at a time, so only the map
+// being built and the member being read are held. Values are decoded as by
+// json.Unmarshal into an interface{}, so nested objects, however deep,
+// become map[string]interface{} rather than Maps. A key which appears more
+// than once keeps its last value, and anything other than whitespace after
+// the object is an error.
+func DecodeJSONStream(r io.Reader) (Map, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("reading JSON object: %w", err)
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("DecodeJSONStream needs a JSON object, got %s", describeJSONToken(tok))
+	}
+
+	tr := NewMap().AsTransient()
+	for i := 1; dec.More(); i++ {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("reading key of entry %d: %w", i, err)
+		}
+		key := tok.(string) // object keys are always strings
+		var val interface{}
+		if err := dec.Decode(&val); err != nil {
+			return nil, fmt.Errorf("decoding value of %q: %w", key, err)
+		}
+		tr.Set(key, val)
+	}
+	if _, err := dec.Token(); err != nil { // the closing brace
+		return nil, fmt.Errorf("reading end of JSON object: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("unexpected data after JSON object")
+	}
+	return tr.Persistent(), nil
+}
+
+// describeJSONToken names the value a JSON token starts, for errors
+func describeJSONToken(tok json.Token) string {
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			return "an array"
+		}
+		return fmt.Sprintf("%q", tok.String())
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return fmt.Sprintf("%v", tok)
+}
@@ -0,0 +1,149 @@
+package ps
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	m := NewMap().Set("b", 2).Set("a", "one").Set("c", []int{3}).Set("d", NewMap().Set("x", true))
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"a":"one","b":2,"c":[3],"d":{"x":true}}`; string(data) != expected {
+		t.Errorf("wrong encoding: %s", data)
+	}
+
+	if data, _ := json.Marshal(NewMap()); string(data) != "{}" {
+		t.Errorf("empty map should encode as {}, got %s", data)
+	}
+	if data, _ := json.Marshal(MapValue{}); string(data) != "{}" {
+		t.Errorf("zero MapValue should encode as {}, got %s", data)
+	}
+
+	if _, err := json.Marshal(NewMap().Set("f", func() {})); err == nil {
+		t.Errorf("expected an error for an unencodable value")
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	m := NewMap().Set("name", "ps").Set("size", 2.5).Set("tags", []interface{}{"a", "b"})
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v MapValue
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if !v.Equal(m) {
+		t.Errorf("round trip changed the map: %s", v)
+	}
+
+	if err := json.Unmarshal([]byte("{}"), &v); err != nil || !v.IsNil() {
+		t.Errorf("decoding {} should give an empty map, got %v (%v)", v.Map, err)
+	}
+	for _, bad := range []string{`[1]`, `null`, `{"a":`} {
+		if err := json.Unmarshal([]byte(bad), &v); err == nil {
+			t.Errorf("expected an error decoding %s", bad)
+		}
+	}
+}
+
+func TestUnmarshalJSONKeyPattern(t *testing.T) {
+	v := MapValue{NewMapKeyPattern(regexp.MustCompile(`^[a-z]+$`))}
+	if err := json.Unmarshal([]byte(`{"ok":1,"Not OK":2}`), &v); err == nil {
+		t.Errorf("expected rejected key to fail decoding")
+	}
+	if err := json.Unmarshal([]byte(`{"ok":1}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.SetChecked("Not OK", 2); err == nil {
+		t.Errorf("decoded map lost its key pattern")
+	}
+}
+
+func TestDecodeJSONStream(t *testing.T) {
+	// write a multi-megabyte object through a pipe, so it's never held whole
+	const entries = 50000
+	pr, pw := io.Pipe()
+	go func() {
+		w := io.Writer(pw)
+		io.WriteString(w, "{")
+		for i := 0; i < entries; i++ {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, `"key%d":{"id":%d,"tags":["a","b"],"deep":{"x":[{"y":%q}]}}`, i, i, strings.Repeat("v", 20))
+		}
+		io.WriteString(w, `,"key7":"last wins"}`)
+		pw.Close()
+	}()
+	cr := &countingReader{r: pr}
+
+	m, err := DecodeJSONStream(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.n < 4<<20 || m.Size() != entries {
+		t.Fatalf("decoded %d keys from %d bytes", m.Size(), cr.n)
+	}
+	for _, i := range []int{0, 1, 12345, entries - 1} {
+		v, ok := m.Lookup(fmt.Sprintf("key%d", i))
+		obj, isObj := v.(map[string]interface{})
+		if !ok || !isObj || obj["id"] != float64(i) {
+			t.Errorf("wrong value for key%d: %v", i, v)
+			continue
+		}
+		deep := obj["deep"].(map[string]interface{})["x"].([]interface{})[0]
+		if deep.(map[string]interface{})["y"] != strings.Repeat("v", 20) {
+			t.Errorf("wrong nested value for key%d: %v", i, deep)
+		}
+	}
+	if v, _ := m.Lookup("key7"); v != "last wins" {
+		t.Errorf("a repeated key should keep its last value, got %v", v)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestDecodeJSONStreamErrors(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		{`[1, 2]`, "got an array"},
+		{`"text"`, "got a string"},
+		{`42`, "got a number"},
+		{`null`, "got null"},
+		{``, "EOF"},
+		{`{"a": 1,`, "reading key of entry 2"},
+		{`{"a": }`, `"a"`},
+		{`{"a": 1} {"b": 2}`, "after JSON object"},
+	}
+	for _, test := range tests {
+		_, err := DecodeJSONStream(strings.NewReader(test.input))
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("decoding %s: expected an error mentioning %s, got %v", test.input, test.expected, err)
+		}
+	}
+
+	m, err := DecodeJSONStream(strings.NewReader(" {} \n"))
+	if err != nil || !m.IsNil() {
+		t.Errorf("decoding {} should give an empty map, got %v (%v)", m, err)
+	}
+}
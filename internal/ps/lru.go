@@ -0,0 +1,140 @@
+package ps
+
+import "fmt"
+
+// LRUCache is a persistent cache which holds up to a fixed number of keys,
+// evicting the least recently used key to make room for a new one. Put and
+// Use return new caches, so every earlier snapshot stays valid, evicted
+// keys included, which makes it easy to look back at what a cache held.
+//
+// The keys are held in a Map and their order of use in a SortedMap keyed by
+// a counter, so Get is O(log N) and Put and Use, which update both, are
+// O(log N) too, with a larger constant than a mutable LRU cache's O(1).
+//
+// Like Map, an LRUCache is immutable and safe to copy. The zero value has
+// no capacity and holds nothing; NewLRUCache makes a cache which can hold
+// keys.
+type LRUCache struct {
+	entries  Map       // key to *lruEntry
+	order    SortedMap // lruTick of each use to its key, oldest first
+	capacity int
+	tick     uint64 // counts uses, to order them
+}
+
+type lruEntry struct {
+	value Any
+	tick  uint64 // of the key's last use
+}
+
+// NewLRUCache returns a new, empty cache which holds up to capacity keys.
+// It panics if capacity is less than 1.
+func NewLRUCache(capacity int) LRUCache {
+	if capacity < 1 {
+		panic(fmt.Sprintf("LRU cache capacity must be at least 1, got %d", capacity))
+	}
+	return LRUCache{entries: NewMap(), capacity: capacity}
+}
+
+// Size returns the number of keys in the cache.
+// This takes O(1) time.
+func (c LRUCache) Size() int {
+	return c.order.Size()
+}
+
+// Capacity returns the number of keys the cache holds before evicting one.
+func (c LRUCache) Capacity() int {
+	return c.capacity
+}
+
+// Get returns the value associated with key, if any, without counting it
+// as a use; see Use.
+// This operation is O(log N) in the number of keys.
+func (c LRUCache) Get(key string) (Any, bool) {
+	e, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Use is like Get, but marks key as the most recently used, and returns
+// the cache with key's new place in the order. If key isn't present, the
+// cache is returned unchanged.
+// This operation is O(log N) in the number of keys.
+func (c LRUCache) Use(key string) (Any, LRUCache, bool) {
+	e, ok := c.lookup(key)
+	if !ok {
+		return nil, c, false
+	}
+	return e.value, c.store(key, e.value, e), true
+}
+
+// Put returns a new cache with key and value associated and key marked as
+// the most recently used. If key is new and the cache is full, the least
+// recently used key is evicted.
+// This operation is O(log N) in the number of keys.
+func (c LRUCache) Put(key string, value Any) LRUCache {
+	if c.capacity == 0 {
+		return c
+	}
+	old, ok := c.lookup(key)
+	if ok {
+		return c.store(key, value, old)
+	}
+	if c.Size() >= c.capacity {
+		_, oldest, _ := c.order.Min()
+		c = c.Delete(oldest.(string))
+	}
+	return c.store(key, value, nil)
+}
+
+// Delete returns a new cache without key. If key isn't present, the cache
+// is returned unchanged.
+// This operation is O(log N) in the number of keys.
+func (c LRUCache) Delete(key string) LRUCache {
+	e, ok := c.lookup(key)
+	if !ok {
+		return c
+	}
+	c.entries = c.entries.Delete(key)
+	c.order = c.order.Delete(lruTick(e.tick))
+	return c
+}
+
+// Keys returns the keys in the cache from the least to the most recently
+// used, so the first is the next to be evicted.
+// This operation is O(N) in the number of keys.
+func (c LRUCache) Keys() []string {
+	keys := make([]string, 0, c.Size())
+	c.order.ForEach(func(_ string, key Any) { keys = append(keys, key.(string)) })
+	return keys
+}
+
+func (c LRUCache) lookup(key string) (*lruEntry, bool) {
+	if c.entries == nil {
+		return nil, false
+	}
+	e, ok := c.entries.Lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return e.(*lruEntry), true
+}
+
+// store returns c with key associated with value as its most recent use,
+// replacing old, key's previous entry, if it isn't nil
+func (c LRUCache) store(key string, value Any, old *lruEntry) LRUCache {
+	if old != nil {
+		c.order = c.order.Delete(lruTick(old.tick))
+	}
+	c.tick++
+	c.entries = c.entries.Set(key, &lruEntry{value, c.tick})
+	c.order = c.order.Set(lruTick(c.tick), key)
+	return c
+}
+
+// lruTick returns the key under which a use is ordered. Fixed-width hex
+// sorts in numeric order.
+func lruTick(tick uint64) string {
+	return fmt.Sprintf("%016x", tick)
+}
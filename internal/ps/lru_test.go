@@ -0,0 +1,68 @@
+package ps
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(3).Put("a", 1).Put("b", 2).Put("c", 3)
+	full := c
+
+	c = c.Put("d", 4)
+	if _, ok := c.Get("a"); ok || c.Size() != 3 {
+		t.Errorf("expected a to be evicted, got %v", c.Keys())
+	}
+	if v, ok := full.Get("a"); !ok || v != 1 {
+		t.Errorf("the old snapshot lost a: %v, %v", v, ok)
+	}
+
+	// using b makes c the least recently used
+	v, c, ok := c.Use("b")
+	if !ok || v != 2 {
+		t.Errorf("Use(b) = %v, %v", v, ok)
+	}
+	c = c.Put("e", 5)
+	if got := strings.Join(c.Keys(), ","); got != "d,b,e" {
+		t.Errorf("expected d,b,e from oldest to newest, got %s", got)
+	}
+
+	// Get doesn't count as a use, and replacing a value does
+	c.Get("d")
+	c = c.Put("b", 20).Put("f", 6)
+	if got := strings.Join(c.Keys(), ","); got != "e,b,f" {
+		t.Errorf("expected e,b,f, got %s", got)
+	}
+	if v, _ := c.Get("b"); v != 20 {
+		t.Errorf("b wasn't replaced: %v", v)
+	}
+
+	if _, same, ok := c.Use("missing"); ok || same.Size() != 3 {
+		t.Errorf("using a missing key changed the cache")
+	}
+	if d := c.Delete("b"); d.Size() != 2 || c.Size() != 3 {
+		t.Errorf("wrong sizes after Delete: %d, %d", d.Size(), c.Size())
+	}
+}
+
+func TestLRUCacheZero(t *testing.T) {
+	var c LRUCache
+	if c = c.Put("a", 1); c.Size() != 0 {
+		t.Errorf("the zero cache has no capacity")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("found a key in the zero cache")
+	}
+
+	one := NewLRUCache(1).Put("a", 1).Put("b", 2)
+	if got := strings.Join(one.Keys(), ","); got != "b" {
+		t.Errorf("expected only b, got %s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("a capacity of 0 didn't panic")
+		}
+	}()
+	NewLRUCache(0)
+}
@@ -10,44 +10,590 @@
 package ps
 
 import (
-	"fmt"
+	"container/heap"
+	"io"
+	"math"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 // Any is a shorthand for Go's verbose interface{} type.
 type Any interface{}
 
+// Entry is a key value pair stored in a Map.
+type Entry struct {
+	Key string
+	Val Any
+}
+
 // A Map associates unique keys (type string) with values (type Any).
 type Map interface {
 	// IsNil returns true if the Map is empty
 	IsNil() bool
 
+	// IsEmpty returns true if the map has no key value pairs, the same as
+	// Size() == 0. It differs from IsNil only for a map whose every key was
+	// removed with TombstoneDelete or has expired: that map still holds the
+	// entries, so it isn't nil, but it is empty.
+	IsEmpty() bool
+
+	// Clone returns the map itself. A map never changes once made, so
+	// sharing it is as good as a copy; Clone exists so that code which
+	// expects to copy values can say so, and so maps satisfy interfaces
+	// requiring it.
+	// This operation is O(1).
+	Clone() Map
+
 	// Set returns a new map in which key and value are associated.
 	// If the key didn't exist before, it's created; otherwise, the
-	// associated value is changed.
+	// associated value is changed. If the key is already associated with
+	// the same value, as defined by ==, the map itself is returned; values
+	// which can't be compared with == always give a new map, as do floats
+	// whose bits differ, such as -0 replacing 0, and structs or arrays
+	// which hold floats.
+	// Set panics if the map only accepts keys matching a pattern and key
+	// doesn't match it.
 	// This operation is O(log N) in the number of keys.
 	Set(key string, value Any) Map
 
+	// SetIfAbsent returns a new map with key and value associated if key
+	// isn't present, and true. If it is, the map itself is returned, along
+	// with false.
+	// This operation is O(log N) in the number of keys.
+	SetIfAbsent(key string, value Any) (Map, bool)
+
+	// Replace returns a new map with key associated with value if key is
+	// already present, and true. If it isn't, the map itself is returned,
+	// along with false.
+	// This operation is O(log N) in the number of keys.
+	Replace(key string, value Any) (Map, bool)
+
+	// MarshalJSON encodes the map as a JSON object with its keys in sorted
+	// order, each value having its usual JSON encoding. To decode one, see
+	// MapValue.
+	MarshalJSON() ([]byte, error)
+
+	// SetChecked is like Set but returns an error instead of panicking when
+	// key is rejected.
+	SetChecked(key string, value Any) (Map, error)
+
 	// Delete returns a new map with the association for key, if any, removed.
+	// If key isn't present, it returns the receiver itself, so callers can
+	// use == to tell whether anything changed.
 	// This operation is O(log N) in the number of keys.
 	Delete(key string) Map
 
+	// SetMany returns a new map with every key value pair in pairs set, as
+	// if by calling Set for each of them. If pairs is empty, the map is
+	// returned unchanged.
+	// This operation is O(M log N) for M pairs.
+	SetMany(pairs map[string]Any) Map
+
+	// DeleteMany returns a new map with the associations for keys removed.
+	// Keys which aren't present are ignored; if none are, the map is
+	// returned unchanged.
+	// This operation is O(M log N) for M keys.
+	DeleteMany(keys []string) Map
+
+	// Update returns a new map in which key is associated with the result
+	// of calling f with its current value, and whether it was present. If f
+	// returns Remove, the key is deleted instead.
+	// This operation is O(log N) in the number of keys.
+	Update(key string, f func(old Any, existed bool) Any) Map
+
+	// DeletePrefix returns a new map without the keys starting with prefix,
+	// along with the number of keys removed.
+	// This operation is O(N log N) in the number of keys.
+	DeletePrefix(prefix string) (Map, int)
+
+	// DeleteRange returns a new map without the keys from lo, inclusive, up
+	// to hi, exclusive, in lexicographic order, like SortedMap's Range. If
+	// lo isn't less than hi, or no key is in the range, the map is returned
+	// unchanged. Keys are placed by their hash, so every key has to be
+	// checked; SortedMap's DeleteRange is O(log N).
+	// This operation is O(N + M log N) for M keys in the range.
+	DeleteRange(lo, hi string) Map
+
 	// Lookup returns the value associated with a key, if any.  If the key
 	// exists, the second return value is true; otherwise, false.
 	// This operation is O(log N) in the number of keys.
 	Lookup(key string) (Any, bool)
 
-	// Size returns the number of key value pairs in the map.
-	// This takes O(1) time.
+	// LookupOrDefault returns the value associated with a key, or def if
+	// the key isn't present. A key stored with a nil value is present, so
+	// nil is returned for it rather than def.
+	// This operation is O(log N) in the number of keys.
+	LookupOrDefault(key string, def Any) Any
+
+	// Contains reports whether the key is present, even with a nil value.
+	// This operation is O(log N) in the number of keys.
+	Contains(key string) bool
+
+	// GetAll returns a new Go map holding the requested keys which are
+	// present, with their values. Missing keys are left out.
+	// This operation is O(M log N) for M keys.
+	GetAll(keys []string) map[string]Any
+
+	// Coalesce returns the value of the first of the given keys which is
+	// present with a non-nil value. If there is no such key, the second
+	// return value is false.
+	Coalesce(keys ...string) (Any, bool)
+
+	// Size returns the number of key value pairs in the map, not counting
+	// tombstoned or expired keys.
+	// This takes O(1) time, plus O(K) time to check the expiry of the K
+	// keys set by SetTTL which the map holds, if any.
 	Size() int
 
+	// Len is the same as Size, for symmetry with Go's len.
+	Len() int
+
 	// ForEach executes a callback on each key value pair in the map.
 	ForEach(f func(key string, val Any))
 
-	// Keys returns a slice with all keys in this map.
+	// ForEachUntil is like ForEach but stops as soon as f returns false,
+	// without visiting the rest of the tree. Entries are visited in the
+	// same order as ForEach.
+	// This operation is O(N) in the number of keys, or less if it stops.
+	ForEachUntil(f func(key string, val Any) bool)
+
+	// All returns a sequence of the map's key value pairs, in the order
+	// ForEach visits them, for use with range over functions:
+	//
+	//	for k, v := range m.All() {
+	//
+	// The sequence's type is the same as iter.Seq2[string, Any], which it
+	// can be assigned to, without tying the package to Go 1.23. Traversal
+	// stops as soon as the loop body breaks.
+	All() func(yield func(key string, val Any) bool)
+
+	// SortedAll is like All but yields the pairs in lexicographic key
+	// order.
+	// This operation is O(N log N) in the number of keys.
+	SortedAll() func(yield func(key string, val Any) bool)
+
+	// Iterator returns an Iterator over the map's entries, visited in the
+	// same order as ForEach.
+	Iterator() *Iterator
+
+	// Keys returns a slice with all keys in this map. The keys are worked
+	// out once per map and kept, so later calls only copy them; a map with
+	// entries that expire works them out every time.
 	// This operation is O(N) in the number of keys.
 	Keys() []string
 
+	// ToGoMap returns a new Go map holding the same pairs as this map. It
+	// doesn't share anything with this map, so it may be modified freely.
+	// This operation is O(N) in the number of keys.
+	ToGoMap() map[string]Any
+
+	// Values returns a slice with all values in this map, in the same order
+	// as Keys, so the value at index i belongs to the key at index i.
+	// This operation is O(N) in the number of keys.
+	Values() []Any
+
+	// Entries returns a slice with all key value pairs in this map, in the
+	// same order as Keys.
+	// This operation is O(N) in the number of keys.
+	Entries() []Entry
+
+	// SortedKeys returns a slice with all keys in this map, in lexicographic
+	// order.
+	// This operation is O(N log N) in the number of keys.
+	SortedKeys() []string
+
+	// MinKey returns the lexicographically smallest key. If the map is
+	// empty, the second return value is false. Keys are placed by their
+	// hash, so every key has to be examined; SortedMap's Min is O(log N).
+	// This operation is O(N) in the number of keys.
+	MinKey() (string, bool)
+
+	// MaxKey returns the lexicographically largest key, like MinKey.
+	// This operation is O(N) in the number of keys.
+	MaxKey() (string, bool)
+
+	// KeysWithPrefix returns the keys starting with prefix, in
+	// lexicographic order. Keys are placed by their hash rather than in
+	// order, so every key has to be checked.
+	// This operation is O(N + M log M) for M matching keys.
+	KeysWithPrefix(prefix string) []string
+
+	// KeysOfType returns the keys whose values have the same dynamic type
+	// as example, in lexicographic order: KeysOfType("") gives the keys of
+	// string values and KeysOfType(0) those of int values. Types must match
+	// exactly, so an int example doesn't match int64 values, nor a concrete
+	// example values of an interface it implements. A nil example matches
+	// the keys whose value is nil.
+	// This operation is O(N + M log M) for M matching keys.
+	KeysOfType(example Any) []string
+
+	// ForEachSorted executes a callback on each key value pair in the map,
+	// in lexicographic key order.
+	// This operation is O(N log N) in the number of keys.
+	ForEachSorted(f func(key string, val Any))
+
+	// ForEachKeyCollated executes a callback on each key value pair in the
+	// map, in the key order defined by less.
+	// This operation is O(N log N) in the number of keys.
+	ForEachKeyCollated(less func(a, b string) bool, f func(key string, val Any))
+
+	// PageKeys returns up to limit keys, in sorted order, from where cursor
+	// points, along with the cursor for the next page and whether there are
+	// more keys after this page. The zero PageCursor starts from the first
+	// key; see PageCursor.After for saving a cursor between requests.
+	// This operation is O(N log M) in the number of keys, for pages of up
+	// to M keys.
+	PageKeys(cursor PageCursor, limit int) (keys []string, next PageCursor, more bool)
+
+	// KeysPage returns the window of up to limit keys starting at offset in
+	// sorted order, so successive pages of a map which isn't changing can
+	// be fetched by advancing offset by limit. A limit of 0 returns every
+	// key from offset on, and an offset past the last key returns an empty
+	// slice. KeysPage panics if offset or limit is negative.
+	// This operation is O(N log N) in the number of keys.
+	KeysPage(offset, limit int) []string
+
+	// ForEachSortedBatch executes a callback on the map's entries in sorted
+	// key order, n entries at a time. The final batch holds the remaining
+	// entries and may be shorter. Each batch is a new slice which the
+	// callback may retain. It panics if n is less than 1.
+	// This operation is O(N log N) in the number of keys.
+	ForEachSortedBatch(n int, f func(batch []Entry))
+
+	// CountLeaves returns the number of nodes in the underlying tree which
+	// have no children.
+	// This operation is O(N) in the number of keys.
+	CountLeaves() int
+
+	// CountInternal returns the number of nodes in the underlying tree which
+	// have at least one child.
+	// This operation is O(N) in the number of keys.
+	CountInternal() int
+
+	// MemStats estimates the memory used by the map's tree. Memory shared
+	// with other maps is included, since a map can't tell which of its
+	// nodes are shared.
+	// This operation is O(N) in the number of keys.
+	MemStats() MapStats
+
+	// Depth returns the number of nodes on the longest path from the root
+	// to a leaf, or 0 for an empty map. Keys are placed by their hash, so
+	// a hash with a poor distribution for the keys in use shows up as a
+	// depth well beyond log8 N.
+	// This operation is O(N) in the number of keys.
+	Depth() int
+
+	// BalanceStats returns the minimum, maximum and average (rounded down)
+	// depth of the tree's leaves, counted as for Depth.
+	// This operation is O(N) in the number of keys.
+	BalanceStats() (min, max, avg int)
+
+	// CollisionStats returns the number of nodes holding more than one key
+	// because their keys' hashes collide, and the most keys held by any one
+	// of them. A map without collisions reports zeros.
+	// This operation is O(N) in the number of keys.
+	CollisionStats() (nodesWithMultipleKeys, maxKeysPerNode int)
+
+	// Equal returns true if other has the same keys as this map and every
+	// key is associated with an equal value. Nested maps are compared with
+	// Equal, values implementing Equaler with their Equals method and other
+	// values with reflect.DeepEqual.
+	// Maps of different sizes or with different key sets are rejected in
+	// O(1) time; otherwise this operation is O(N) in the number of keys.
+	Equal(other Map) bool
+
+	// EqualFunc is like Equal but compares values with eq, which is only
+	// called for keys present in both maps.
+	EqualFunc(other Map, eq func(a, b Any) bool) bool
+
+	// Fingerprint returns a hash of the map's contents which doesn't depend
+	// on the order keys were set in, for use as a cache key or to tell
+	// maps apart cheaply. Equal maps have the same fingerprint, as long as
+	// their values don't hold pointers: values are hashed by their contents,
+	// with -0 the same as 0 wherever it appears, but a pointer by its
+	// address rather than what it points to. An Equaler is hashed by its
+	// type alone, since only its Equals method knows what it ignores.
+	// Different maps almost always have different fingerprints, but equal
+	// fingerprints don't prove the maps are equal; use Equal for that.
+	// This operation is O(N) in the number of keys.
+	Fingerprint() uint64
+
+	// Filter returns a new map with only the entries for which pred returns
+	// true. The rejected keys are deleted from this map, so the result
+	// shares the structure of the entries kept; if every entry is kept this
+	// map is returned.
+	Filter(pred func(key string, val Any) bool) Map
+
+	// Partition splits the map in two: the entries for which pred returns
+	// true and those for which it returns false. pred is called once per
+	// entry. Like Filter, the results are made by deleting keys from this
+	// map, so they share its structure.
+	// This operation is O(N log N) in the number of keys.
+	Partition(pred func(key string, val Any) bool) (matched, rest Map)
+
+	// CountBy returns the number of entries for which pred returns true.
+	// This operation is O(N) in the number of keys.
+	CountBy(pred func(key string, val Any) bool) int
+
+	// GroupBy returns a new map from each category keyFn gives to a []Any of
+	// the values of the entries in that category, in the order of their
+	// keys. keyFn is called once per entry, so every value is in exactly
+	// one group. The result is a plain map: the categories aren't checked
+	// against a key pattern this map may have.
+	// This operation is O(N log N) in the number of keys.
+	GroupBy(keyFn func(key string, val Any) string) Map
+
+	// Project returns a new map in which each key that mapping has is
+	// renamed to the key it maps to, keeping its value. Keys mapping lacks
+	// are dropped, unless keepUnmapped is true, in which case they're kept
+	// as they are. When several keys end up with the same name, a renamed
+	// key wins over a kept one, and among renamed keys the one which comes
+	// last in lexicographic order wins, so the result doesn't depend on the
+	// order of iteration. The result has this map's options, so Project
+	// panics like Set if a new name doesn't match the map's key pattern.
+	// This operation is O(N log N) in the number of keys.
+	Project(mapping map[string]string, keepUnmapped bool) Map
+
+	// FindFirst returns an entry for which pred returns true, stopping as
+	// soon as it finds one. Entries are visited in the order of their
+	// hashes, so if several match, which is "first" is unspecified; use
+	// ForEachSorted to find the match with the smallest key.
+	// This operation is O(N) in the number of keys.
+	FindFirst(pred func(key string, val Any) bool) (key string, val Any, found bool)
+
+	// Fold threads an accumulator through every entry in the map, starting
+	// from initial, and returns the final value. The order in which entries
+	// are visited is unspecified; use FoldSorted when f depends on it.
+	// This operation is O(N) in the number of keys.
+	Fold(initial Any, f func(acc Any, key string, val Any) Any) Any
+
+	// FoldSorted is like Fold but visits entries in lexicographic key order.
+	// This operation is O(N log N) in the number of keys.
+	FoldSorted(initial Any, f func(acc Any, key string, val Any) Any) Any
+
+	// AsTransient returns a Transient holding this map, for building a
+	// modified copy with many Sets and Deletes without allocating a new
+	// path for each of them. The map itself isn't affected.
+	AsTransient() *Transient
+
+	// Cursor returns a Cursor holding this map, for making many changes
+	// to the same or nearby keys without walking down from the root for
+	// each of them. The map itself isn't affected.
+	Cursor() *Cursor
+
+	// MapValues returns a new map with the same keys in which every value
+	// has been replaced by the result of calling f on it. f is called
+	// exactly once per key.
+	// This operation is O(N) in the number of keys.
+	MapValues(f func(key string, val Any) Any) Map
+
+	// MapConcurrent is like MapValues but spreads the calls to f across the
+	// given number of goroutines, so f must be safe for concurrent use. The
+	// result doesn't depend on the number of workers.
+	MapConcurrent(f func(key string, val Any) Any, workers int) Map
+
+	// ForEachParallel is like ForEach but spreads the calls to f across the
+	// given number of goroutines, so f must be safe for concurrent use. It
+	// returns once every call has. It's only worthwhile when f does enough
+	// work to outweigh starting the goroutines.
+	ForEachParallel(workers int, f func(key string, val Any))
+
+	// TombstoneDelete returns a new map in which key is marked as deleted
+	// instead of being removed. The key is hidden from Lookup, Keys, ForEach
+	// and Size but still visited by RawForEach until the map is compacted.
+	// If the key isn't present, the map is returned unchanged.
+	// This operation is O(log N) in the number of keys.
+	TombstoneDelete(key string) Map
+
+	// Compact returns a new map with all tombstoned and expired keys
+	// removed, rebuilt with BuildBalanced. Deleting many keys can leave the
+	// tree deeper than it needs to be, and rebuilding brings it back to the
+	// depth of a freshly built map, so long-lived maps which change a lot
+	// may be compacted now and then. If there's nothing to remove, the map
+	// is returned unchanged; TrimToSize rebuilds it regardless, such as
+	// after many keys were removed with Delete.
+	// This operation is O(N log N) in the number of keys, or O(K) for K
+	// keys set by SetTTL if there's nothing to remove.
+	Compact() Map
+
+	// TrimToSize returns a copy of the map, without tombstoned and expired
+	// keys, which shares no memory with it or any other version: every node
+	// is built afresh, collision buckets hold no spare room, and a Bloom
+	// filter, which versions share and which only grows, is rebuilt for
+	// this map's keys alone. Once every other version of a map which has
+	// shrunk a lot has been dropped, that memory can be collected; while
+	// any is still referenced, trimming only adds a copy.
+	// This operation is O(N log N) in the number of keys.
+	TrimToSize() Map
+
+	// CompactAt is like Compact but removes the keys which are expired at
+	// the given time rather than now.
+	CompactAt(now time.Time) Map
+
+	// SetTTL is like Set but the key expires at the given time, after which
+	// it's treated as absent by Lookup, ForEach, Size, Equal and the
+	// operations built on them. Expired keys are only removed by compaction.
+	SetTTL(key string, value Any, expiresAt time.Time) Map
+
+	// LookupAt is like Lookup but checks expiry against the given time
+	// rather than now.
+	LookupAt(key string, now time.Time) (Any, bool)
+
+	// RawForEach executes a callback on each key value pair in the map,
+	// including tombstoned and expired keys for which dead is true and val
+	// is nil.
+	RawForEach(f func(key string, val Any, dead bool))
+
+	// Diff compares this map against an older version of it. It returns the
+	// entries only present in this map, the entries only present in old and
+	// the entries present in both but with values which aren't equal as
+	// defined by Equal (carrying this map's value).
+	// This operation is O(N log N) in the number of keys.
+	Diff(old Map) (added, removed, changed Map)
+
+	// ApplyPatch returns a new map with the removed keys deleted and the
+	// added and changed entries set, so that for any two maps
+	// old.ApplyPatch(new.Diff(old)) is equal to new.
+	ApplyPatch(added, removed, changed Map) Map
+
+	// PatchFrom returns the operations which turn old into this map, in key
+	// order: an OpSet for each key added or whose value changed, as defined
+	// by Diff, and an OpDelete for each key removed. Keys whose values are
+	// equal aren't mentioned, so old.ApplyOps(m.PatchFrom(old)) is equal to
+	// m and the patch between versions which share most keys is short. A
+	// nil old is treated as empty.
+	// This operation is O(N log N) in the number of keys.
+	PatchFrom(old Map) []Op
+
+	// ApplyOps returns a new map with the operations applied in order. It
+	// panics if an operation's Kind is neither OpSet nor OpDelete.
+	// This operation is O(M log N) for M operations.
+	ApplyOps(ops []Op) Map
+
+	// Transact calls fn with a Tx on which it can stage changes to the map.
+	// If fn returns nil, the map with the staged changes is returned;
+	// otherwise the error is returned along with this map, unchanged.
+	Transact(fn func(tx *Tx) error) (Map, error)
+
+	// DecodeStruct assigns the map's entries to the exported fields of the
+	// struct pointed to by out. A field's key is its name, unless it has a
+	// `ps:"key"` or `json:"key"` tag; fields tagged "-" are skipped and
+	// fields tagged with a ",required" option (e.g. `ps:"port,required"`)
+	// must be present. Numeric values are converted to the field's numeric
+	// type when this doesn't lose information, and nested maps are decoded
+	// into struct fields, or pointers to structs, the same way. Fields
+	// whose keys are missing keep their values; a value which can't be
+	// assigned gives an error naming its key and the field's type.
+	DecodeStruct(out interface{}) error
+
+	// Scan is DecodeStruct by the name database/sql and configuration
+	// libraries use: it loads the map's entries into the struct pointed to
+	// by dest.
+	Scan(dest interface{}) error
+
+	// Merge returns a new map with the entries of both maps, taking other's
+	// value for keys present in both. The smaller map's entries are set on
+	// the larger one, whose structure is reused.
+	// This operation is O(M log N), where M is the size of the smaller map
+	// and N that of the larger.
+	Merge(other Map) Map
+
+	// MergeWith returns a new map with the entries of both maps. For keys
+	// present in both, resolve is called once with this map's value as a
+	// and other's as b, and its result is stored. Keys set by SetTTL keep
+	// their expiry; a resolved value takes other's expiry, as if other's
+	// value had been set, so it never expires unless other's key does.
+	// This operation is O(M log N) in the number of keys in other.
+	MergeWith(other Map, resolve func(key string, a, b Any) Any) Map
+
+	// Intersection returns a new map with the entries of this map whose keys
+	// are also present in other. When most keys are kept, the result shares
+	// structure with this map, and if all are kept this map is returned. A
+	// nil other yields an empty map.
+	// This operation is O(min(N, M) log N) in the sizes of the two maps.
+	Intersection(other Map) Map
+
+	// Difference returns a new map with the entries of this map whose keys
+	// aren't present in other. If other is nil or shares no keys with this
+	// map, this map is returned.
+	// This operation is O(min(N, M) log N) in the sizes of the two maps.
+	Difference(other Map) Map
+
+	// DeepMerge returns a new map with the entries of other added to this
+	// map. When both maps hold a nested Map under the same key, the nested
+	// maps are merged recursively; any other collision, including a map
+	// colliding with a non-map value, is resolved in favor of other.
+	// This operation is O(M log N) in the number of keys in other.
+	DeepMerge(other Map) Map
+
+	// Flatten returns a single-level map in which the entries of nested maps
+	// are stored under their path of keys joined by sep, e.g. "db.host".
+	// Empty nested maps are kept as values so that they survive Unflatten.
+	// If two paths flatten to the same key (as with a key "a.b" next to a
+	// nested map "a" holding "b"), which value is kept is unspecified.
+	// This operation is O(N log N) in the total number of keys.
+	Flatten(sep string) Map
+
+	// Unflatten reverses Flatten, splitting every key on sep and storing the
+	// value in nested maps; keys which contained sep before flattening are
+	// split too. When a key is both a value and the prefix of other keys
+	// (e.g. "a" and "a.b"), the nested map wins and the value is dropped.
+	// This operation is O(N log N) in the number of keys.
+	Unflatten(sep string) Map
+
+	// UnflattenStrict is like Unflatten but returns an error instead of
+	// dropping a value when its key is also the prefix of other keys.
+	UnflattenStrict(sep string) (Map, error)
+
+	// Walk calls visit on each entry of the map and, depth first, of the
+	// maps nested in it, in key order at each level. path holds the keys
+	// leading to the entry, from this map's key down to the entry's own, so
+	// the value of db.host is visited with path ["db", "host"]. When val is
+	// a Map, visit is called on it before its entries, which are only
+	// visited if visit returns true; for other values the result is
+	// ignored. The path slice is reused between calls, so visit must copy
+	// it to keep it.
+	// This operation is O(N log N) in the number of keys visited.
+	Walk(visit func(path []string, val Any) (descend bool))
+
+	// WriteFrames writes every entry to w as a key frame followed by a
+	// value frame, each prefixed with its length as a uvarint. Values are
+	// encoded with encodeVal. See ReadFrames.
+	WriteFrames(w io.Writer, encodeVal func(Any) ([]byte, error)) error
+
+	// WriteTo writes the map to w in a compact binary format, one entry at
+	// a time, and returns the number of bytes written. Strings, booleans,
+	// ints, float64s, byte slices and nested Maps are encoded directly;
+	// other values are gob encoded, so their types must be registered with
+	// gob.Register. See ReadMap.
+	WriteTo(w io.Writer) (int64, error)
+
+	// MarshalBinary encodes the map in a self-describing binary format: a
+	// magic number, a format version, the number of entries and then each
+	// entry's key and value, with values encoded as by WriteTo. To decode
+	// one, see MapValue.
+	MarshalBinary() ([]byte, error)
+
+	// Dump returns a description of the underlying tree for debugging, one
+	// node per line. Each node shows its child slot, hash and key, the keys
+	// in its collision bucket and which of its slots hold children ('x')
+	// rather than the shared empty node ('.'). Its children follow,
+	// indented by two more spaces. Tombstoned keys are marked with a
+	// trailing '~'.
+	Dump() string
+
+	// Format returns a description of the map's contents as controlled by
+	// opts; see FormatOptions.
+	// This operation is O(N log N) in the number of keys.
+	Format(opts FormatOptions) string
+
+	// String returns the map's contents formatted with the default
+	// FormatOptions, such as {a: 1, b: 2}.
 	String() string
 }
 
@@ -58,6 +604,14 @@ const shiftSize = 3
 type tree struct {
 	count    int
 	hash     uint64 // hash of the key (used for tree balancing)
+	digest   uint64 // XOR of all key hashes in this subtree
+	dead     int    // number of tombstoned keys in this subtree
+	timed    int    // number of keys set by SetTTL in this subtree
+	opts     *options
+	owner    *Transient     // the transient which may modify this node in place
+	filter   *bloomFilter   // keys below this node, if NewMapWithBloom asked
+	keys     unsafe.Pointer // *[]string cached by Keys, set atomically
+	overflow []Entry        // other keys with the same hash as key
 	key      string
 	value    Any
 	children [childCount]*tree
@@ -82,14 +636,29 @@ func NewMap() Map {
 	return nilMap
 }
 
+// FromGoMap returns a new, persistent map holding the same pairs as m. A
+// nil m gives an empty map.
+// This operation is O(N log N) in the number of keys.
+func FromGoMap(m map[string]Any) Map {
+	return NewMap().SetMany(m)
+}
+
 func (t *tree) IsNil() bool {
-	return t == nilMap
+	return t.count == 0
 }
 
-// clone returns an exact duplicate of a tree node
+func (t *tree) Clone() Map {
+	return t
+}
+
+// clone returns an exact duplicate of a tree node, except for its Bloom
+// filter and cached keys, which may not match the keys the copy goes on to
+// be given
 func (t *tree) clone() *tree {
 	var m tree
 	m = *t
+	m.filter = nil
+	m.keys = nil
 	return &m
 }
 
@@ -99,6 +668,16 @@ const (
 	prime64  uint64 = 1099511628211
 )
 
+// HashKey returns the hash a map made by NewMap places key by, for callers
+// which need to agree with it, such as one spreading keys across several
+// maps by hash. It's 64-bit FNV-1a over the key's code points rather than
+// its bytes, so it matches the standard hash/fnv for ASCII keys only. Maps
+// made by NewMapWithHash use their own hash instead. The values HashKey
+// returns won't change between versions of this package.
+func HashKey(key string) uint64 {
+	return hashKey(key)
+}
+
 // hashKey returns a hash code for a given string
 func hashKey(key string) uint64 {
 	hash := offset64
@@ -113,8 +692,85 @@ func hashKey(key string) uint64 {
 // associated.  If the key didn't exist, it's created; otherwise, the
 // associated value is changed.
 func (t *tree) Set(key string, value Any) Map {
-	hash := hashKey(key)
-	return setLowLevel(t, hash, hash, key, value)
+	m, err := t.SetChecked(key, value)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (t *tree) SetIfAbsent(key string, value Any) (Map, bool) {
+	if t.Contains(key) {
+		return t, false
+	}
+	return t.Set(key, value), true
+}
+
+func (t *tree) Replace(key string, value Any) (Map, bool) {
+	if !t.Contains(key) {
+		return t, false
+	}
+	return t.Set(key, value), true
+}
+
+func (t *tree) SetChecked(key string, value Any) (Map, error) {
+	key = t.opts.normalizeKey(key)
+	if err := t.opts.checkKey(key); err != nil {
+		return nil, err
+	}
+	key = t.opts.internKey(key)
+	hash := t.opts.hashKey(key)
+	partialHash := t.opts.partialHash(hash)
+	old, ok := lookupLowLevel(t, partialHash, hash, key)
+	if ok && sameValue(old, value) {
+		return t, nil
+	}
+	return t.withBloom(setLowLevel(t, partialHash, hash, key, value), hash, !ok), nil
+}
+
+// sameValue reports whether a and b are equal as defined by ==. Values
+// which can't be compared with == are never the same. == doesn't tell -0
+// from 0, so floats are the same only if their bits are, and values which
+// may hold floats, such as structs with float fields, are never the same.
+func sameValue(a, b Any) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() || !va.Comparable() {
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return math.Float64bits(va.Float()) == math.Float64bits(vb.Float())
+	case reflect.Complex64, reflect.Complex128:
+		ca, cb := va.Complex(), vb.Complex()
+		return math.Float64bits(real(ca)) == math.Float64bits(real(cb)) &&
+			math.Float64bits(imag(ca)) == math.Float64bits(imag(cb))
+	case reflect.Struct, reflect.Array:
+		if mayHoldFloats(va.Type()) {
+			return false
+		}
+	}
+	return a == b
+}
+
+// mayHoldFloats reports whether a value of type t, which is a struct or an
+// array, may hold a float or complex number which == would compare
+func mayHoldFloats(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.Interface:
+		return true
+	case reflect.Array:
+		return mayHoldFloats(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if mayHoldFloats(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func setLowLevel(self *tree, partialHash, hash uint64, key string, value Any) *tree {
@@ -124,6 +780,7 @@ func setLowLevel(self *tree, partialHash, hash uint64, key string, value Any) *t
 		m.hash = hash
 		m.key = key
 		m.value = value
+		recalculateCount(m)
 		return m
 	}
 
@@ -135,29 +792,158 @@ func setLowLevel(self *tree, partialHash, hash uint64, key string, value Any) *t
 		return m
 	}
 
-	// replacing a key's previous value
 	m := self.clone()
-	m.value = value
+	if key == self.key { // replacing a key's previous value
+		m.value = value
+	} else { // a different key with the same hash
+		i := self.overflowIndex(key)
+		m.overflow = make([]Entry, len(self.overflow), len(self.overflow)+1)
+		copy(m.overflow, self.overflow)
+		if i < 0 {
+			m.overflow = append(m.overflow, Entry{key, value})
+		} else {
+			m.overflow[i].Val = value
+		}
+	}
+	recalculateCount(m)
 	return m
 }
 
-// modifies a map by recalculating its key count based on the counts
-// of its subtrees
+// overflowIndex returns the position of key in the node's collision bucket,
+// or -1 if it isn't there
+func (t *tree) overflowIndex(key string) int {
+	for i, e := range t.overflow {
+		if e.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// modifies a map by recalculating its key count, digest and number of
+// tombstones based on those of its subtrees
 func recalculateCount(m *tree) {
-	count := 0
+	count := len(m.overflow)
+	digest := m.hash
+	dead, timed := 0, 0
+	m.eachNodeEntry(func(_ string, val Any) {
+		switch val.(type) {
+		case tombstone:
+			dead++
+		case expiring:
+			timed++
+		}
+	})
+	for range m.overflow {
+		digest ^= m.hash
+	}
 	for _, t := range m.children {
-		count += t.Size()
+		count += t.count
+		digest ^= t.digest
+		dead += t.dead
+		timed += t.timed
 	}
 	m.count = count + 1 // add one to count ourselves
+	m.digest = digest
+	m.dead = dead
+	m.timed = timed
+}
+
+// Remove can be returned by the function passed to Map.Update to delete
+// the key rather than store a value under it.
+var Remove Any = removeValue{}
+
+type removeValue struct{}
+
+func (t *tree) Update(key string, f func(old Any, existed bool) Any) Map {
+	val := f(t.Lookup(key))
+	if val == Remove {
+		return t.Delete(key)
+	}
+	return t.Set(key, val)
 }
 
 func (t *tree) Delete(key string) Map {
-	hash := hashKey(key)
-	newMap, _ := deleteLowLevel(t, hash, hash)
-	return newMap
+	key = t.opts.normalizeKey(key)
+	hash := t.opts.hashKey(key)
+	if t.filter != nil && !t.filter.mayContain(hash) {
+		return t
+	}
+	newMap, found := deleteLowLevel(t, t.opts.partialHash(hash), hash, key)
+	if !found {
+		return t
+	}
+	if t.filter != nil {
+		// the root may be a node shared with other maps, so copy it before
+		// giving it the old filter, which still holds every remaining key
+		newMap = t.adopt(newMap).clone()
+		newMap.filter = t.filter
+		return newMap
+	}
+	return t.adopt(newMap)
 }
 
-func deleteLowLevel(self *tree, partialHash, hash uint64) (*tree, bool) {
+func (t *tree) KeysWithPrefix(prefix string) []string {
+	var keys []string
+	t.ForEach(func(key string, _ Any) {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func (t *tree) KeysOfType(example Any) []string {
+	want := reflect.TypeOf(example)
+	var keys []string
+	t.ForEach(func(key string, val Any) {
+		if reflect.TypeOf(val) == want {
+			keys = append(keys, key)
+		}
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func (t *tree) DeletePrefix(prefix string) (Map, int) {
+	var m Map = t.empty()
+	removed := 0
+	forEachStored(t, func(key string, _, stored Any) {
+		if strings.HasPrefix(key, prefix) {
+			removed++
+		} else {
+			m = m.Set(key, stored)
+		}
+	})
+
+	if removed == 0 {
+		return t, 0
+	}
+	return m, removed
+}
+
+func (t *tree) DeleteRange(lo, hi string) Map {
+	var doomed []string
+	if lo < hi {
+		t.ForEach(func(key string, _ Any) {
+			if lo <= key && key < hi {
+				doomed = append(doomed, key)
+			}
+		})
+	}
+	if len(doomed) == 0 {
+		return t
+	}
+
+	tr := t.AsTransient()
+	for _, key := range doomed {
+		tr.Delete(key)
+	}
+	return tr.Persistent()
+}
+
+func deleteLowLevel(self *tree, partialHash, hash uint64, key string) (*tree, bool) {
 	// empty trees are easy
 	if self.IsNil() {
 		return self, false
@@ -165,38 +951,58 @@ func deleteLowLevel(self *tree, partialHash, hash uint64) (*tree, bool) {
 
 	if hash != self.hash {
 		i := partialHash % childCount
-		child, found := deleteLowLevel(self.children[i], partialHash>>shiftSize, hash)
+		child, found := deleteLowLevel(self.children[i], partialHash>>shiftSize, hash, key)
 		if !found {
 			return self, false
 		}
 		newMap := self.clone()
 		newMap.children[i] = child
 		recalculateCount(newMap)
-		return newMap, true // ? this wasn't in the original code
+		return newMap, true
+	}
+
+	// the key may be in the collision bucket, or be replaced by a key
+	// from it
+	if len(self.overflow) > 0 {
+		i := self.overflowIndex(key)
+		if i < 0 && key != self.key {
+			return self, false
+		}
+
+		newMap := self.clone()
+		if i < 0 {
+			newMap.key, newMap.value = self.overflow[0].Key, self.overflow[0].Val
+			i = 0
+		}
+		newMap.overflow = make([]Entry, 0, len(self.overflow)-1)
+		newMap.overflow = append(newMap.overflow, self.overflow[:i]...)
+		newMap.overflow = append(newMap.overflow, self.overflow[i+1:]...)
+		if len(newMap.overflow) == 0 {
+			newMap.overflow = nil
+		}
+		recalculateCount(newMap)
+		return newMap, true
+	}
+	if key != self.key {
+		return self, false
 	}
 
 	// we must delete our own node
 	if self.isLeaf() { // we have no children
 		return nilMap, true
 	}
-	/*
-	   if self.subtreeCount() == 1 { // only one subtree
-	       for _, t := range self.children {
-	           if t != nilMap {
-	               return t, true
-	           }
-	       }
-	       panic("Tree with 1 subtree actually had no subtrees")
-	   }
-	*/
+	// A lone subtree can't simply take our place: its keys are placed by
+	// the hash bits for the level below ours, so lookups would no longer
+	// find them. Instead a leaf is moved up, since a leaf's key is found
+	// by comparing hashes at whatever level it's stored.
 
 	// find a node to replace us
 	i := -1
 	size := -1
 	for j, t := range self.children {
-		if t.Size() > size {
+		if t.count > size {
 			i = j
-			size = t.Size()
+			size = t.count
 		}
 	}
 
@@ -235,7 +1041,12 @@ func (t *tree) deleteLeftmost() (*tree, *tree) {
 
 // isLeaf returns true if this is a leaf node
 func (t *tree) isLeaf() bool {
-	return t.Size() == 1
+	for _, c := range t.children {
+		if c != nilMap {
+			return false
+		}
+	}
+	return true
 }
 
 // returns the number of child subtrees we have
@@ -249,27 +1060,103 @@ func (t *tree) subtreeCount() int {
 	return count
 }
 
+func (t *tree) Contains(key string) bool {
+	_, ok := t.Lookup(key)
+	return ok
+}
+
+func (t *tree) GetAll(keys []string) map[string]Any {
+	m := make(map[string]Any, len(keys))
+	for _, key := range keys {
+		if val, ok := t.Lookup(key); ok {
+			m[key] = val
+		}
+	}
+	return m
+}
+
+func (t *tree) LookupOrDefault(key string, def Any) Any {
+	if val, ok := t.Lookup(key); ok {
+		return val
+	}
+	return def
+}
+
 func (t *tree) Lookup(key string) (Any, bool) {
-	hash := hashKey(key)
-	return lookupLowLevel(t, hash, hash)
+	key = t.opts.normalizeKey(key)
+	hash := t.opts.hashKey(key)
+	if t.filter != nil && !t.filter.mayContain(hash) {
+		return nil, false
+	}
+	val, ok := lookupLowLevel(t, t.opts.partialHash(hash), hash, key)
+	if !ok {
+		return nil, false
+	}
+	return resolve(val)
 }
 
-func lookupLowLevel(self *tree, partialHash, hash uint64) (Any, bool) {
+func lookupLowLevel(self *tree, partialHash, hash uint64, key string) (Any, bool) {
 	if self.IsNil() { // an empty tree is easy
 		return nil, false
 	}
 
 	if hash != self.hash {
 		i := partialHash % childCount
-		return lookupLowLevel(self.children[i], partialHash>>shiftSize, hash)
+		return lookupLowLevel(self.children[i], partialHash>>shiftSize, hash, key)
 	}
 
-	// we found it
-	return self.value, true
+	// we found it, or a key with the same hash
+	if key == self.key {
+		return self.value, true
+	}
+	if i := self.overflowIndex(key); i >= 0 {
+		return self.overflow[i].Val, true
+	}
+	return nil, false
+}
+
+func (t *tree) Coalesce(keys ...string) (Any, bool) {
+	for _, key := range keys {
+		if val, ok := t.Lookup(key); ok && val != nil {
+			return val, true
+		}
+	}
+	return nil, false
 }
 
 func (t *tree) Size() int {
-	return t.count
+	if t.timed == 0 {
+		return t.count - t.dead
+	}
+	return t.count - t.dead - t.expiredAt(time.Now())
+}
+
+// expiredAt returns the number of keys in t which are expired at now,
+// visiting only the subtrees holding keys set by SetTTL
+func (t *tree) expiredAt(now time.Time) int {
+	if t.timed == 0 {
+		return 0
+	}
+	expired := 0
+	t.eachNodeEntry(func(_ string, val Any) {
+		if _, ok := val.(expiring); ok {
+			if _, live := resolveAt(val, now); !live {
+				expired++
+			}
+		}
+	})
+	for _, c := range t.children {
+		expired += c.expiredAt(now)
+	}
+	return expired
+}
+
+func (t *tree) Len() int {
+	return t.Size()
+}
+
+func (t *tree) IsEmpty() bool {
+	return t.Size() == 0
 }
 
 func (t *tree) ForEach(f func(key string, val Any)) {
@@ -278,7 +1165,11 @@ func (t *tree) ForEach(f func(key string, val Any)) {
 	}
 
 	// ourself
-	f(t.key, t.value)
+	t.eachNodeEntry(func(key string, val Any) {
+		if val, ok := resolve(val); ok {
+			f(key, val)
+		}
+	})
 
 	// children
 	for _, c := range t.children {
@@ -288,30 +1179,447 @@ func (t *tree) ForEach(f func(key string, val Any)) {
 	}
 }
 
+func (t *tree) ForEachUntil(f func(key string, val Any) bool) {
+	t.all(f)
+}
+
 func (t *tree) Keys() []string {
-	keys := make([]string, t.Size())
-	i := 0
+	if t.IsNil() {
+		return []string{}
+	}
+	if cached := (*[]string)(atomic.LoadPointer(&t.keys)); cached != nil {
+		return append(make([]string, 0, len(*cached)), *cached...)
+	}
+
+	keys := make([]string, 0, t.Size())
+	expires := false
+	t.eachEntry(func(k string, v Any) {
+		if _, ok := v.(expiring); ok {
+			expires = true // the keys depend on when they're asked for
+		}
+		if _, ok := resolve(v); ok {
+			keys = append(keys, k)
+		}
+	})
+	if expires {
+		return keys
+	}
+	// the map never changes, so neither do its keys; keep them, but hand
+	// out a copy in case the caller sorts or modifies them
+	atomic.StorePointer(&t.keys, unsafe.Pointer(&keys))
+	return append(make([]string, 0, len(keys)), keys...)
+}
+
+func (t *tree) ToGoMap() map[string]Any {
+	m := make(map[string]Any, t.Size())
 	t.ForEach(func(k string, v Any) {
-		keys[i] = k
-		i++
+		m[k] = v
 	})
-	return keys
+	return m
 }
 
-// make it easier to display maps for debugging
-func (t *tree) String() string {
+func (t *tree) Values() []Any {
+	values := make([]Any, 0, t.Size())
+	t.ForEach(func(_ string, v Any) {
+		values = append(values, v)
+	})
+	return values
+}
+
+func (t *tree) Entries() []Entry {
+	entries := make([]Entry, 0, t.Size())
+	t.ForEach(func(k string, v Any) {
+		entries = append(entries, Entry{k, v})
+	})
+	return entries
+}
+
+func (t *tree) SortedKeys() []string {
 	keys := t.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+func (t *tree) MinKey() (string, bool) {
+	return t.extremeKey(func(a, b string) bool { return a < b })
+}
 
-	var builder strings.Builder
-	builder.WriteString("{")
+func (t *tree) MaxKey() (string, bool) {
+	return t.extremeKey(func(a, b string) bool { return a > b })
+}
+
+// extremeKey returns the key k for which better(k, other) holds against
+// every other key
+func (t *tree) extremeKey(better func(a, b string) bool) (string, bool) {
+	var extreme string
+	found := false
+	t.ForEach(func(key string, _ Any) {
+		if !found || better(key, extreme) {
+			extreme, found = key, true
+		}
+	})
+	return extreme, found
+}
+
+func (t *tree) ForEachSorted(f func(key string, val Any)) {
+	for _, key := range t.SortedKeys() {
+		val, _ := t.Lookup(key)
+		f(key, val)
+	}
+}
+
+func (t *tree) ForEachKeyCollated(less func(a, b string) bool, f func(key string, val Any)) {
+	keys := t.Keys()
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
 	for _, key := range keys {
 		val, _ := t.Lookup(key)
-		_, err := fmt.Fprintf(&builder, "%s: %s, ", key, val)
-		if err != nil {
-			return ""
+		f(key, val)
+	}
+}
+
+// PageCursor is where a page of keys returned by Map.PageKeys starts. The
+// zero PageCursor starts from the first key. It's a struct rather than the
+// last key of the previous page since "" is a key like any other, so it
+// can't also stand for the start.
+type PageCursor struct {
+	after   string // the last key of the previous page
+	started bool   // whether after is set
+}
+
+// PageAfter returns a cursor for the page of keys strictly greater than
+// key, such as to resume from a key saved earlier.
+func PageAfter(key string) PageCursor {
+	return PageCursor{after: key, started: true}
+}
+
+// After returns the key the cursor's page follows, and false for the zero
+// PageCursor, which starts from the first key. A cursor can be saved, such
+// as in a page token, as the two results, and made again with PageAfter
+// if the second is true.
+func (c PageCursor) After() (string, bool) {
+	return c.after, c.started
+}
+
+func (t *tree) PageKeys(cursor PageCursor, limit int) ([]string, PageCursor, bool) {
+	// keep the limit smallest keys after the cursor in a heap whose top is
+	// the largest of them, rather than sorting every key
+	limit = max(limit, 0)
+	page := &maxKeyHeap{}
+	more := false
+	t.all(func(key string, _ Any) bool {
+		switch {
+		case cursor.started && key <= cursor.after:
+		case page.Len() < limit:
+			heap.Push(page, key)
+		case limit > 0 && key < (*page)[0]:
+			(*page)[0] = key
+			heap.Fix(page, 0)
+			more = true
+		default:
+			more = true
 		}
+		return true
+	})
+
+	keys := []string(*page)
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		cursor = PageAfter(keys[len(keys)-1])
+	}
+	return keys, cursor, more
+}
+
+// maxKeyHeap is a container/heap of keys whose top is the largest
+type maxKeyHeap []string
+
+func (h maxKeyHeap) Len() int            { return len(h) }
+func (h maxKeyHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h maxKeyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxKeyHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *maxKeyHeap) Pop() interface{} {
+	old := *h
+	key := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return key
+}
+
+func (t *tree) KeysPage(offset, limit int) []string {
+	if offset < 0 || limit < 0 {
+		panic("KeysPage needs a non-negative offset and limit")
+	}
+
+	keys := t.SortedKeys()
+	if offset >= len(keys) {
+		return []string{}
+	}
+	keys = keys[offset:]
+	if limit > 0 && limit < len(keys) {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+func (t *tree) ForEachSortedBatch(n int, f func(batch []Entry)) {
+	if n < 1 {
+		panic("batch size must be at least 1")
 	}
-	builder.WriteString("}\n")
 
-	return builder.String()
+	keys := t.SortedKeys()
+	for start := 0; start < len(keys); start += n {
+		end := start + n
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := make([]Entry, 0, end-start)
+		for _, key := range keys[start:end] {
+			val, _ := t.Lookup(key)
+			batch = append(batch, Entry{key, val})
+		}
+		f(batch)
+	}
+}
+
+func (t *tree) CountLeaves() int {
+	leaves, _ := t.countNodes()
+	return leaves
+}
+
+func (t *tree) CountInternal() int {
+	_, internal := t.countNodes()
+	return internal
+}
+
+// countNodes walks the tree once, counting leaf and internal nodes
+func (t *tree) countNodes() (leaves, internal int) {
+	if t.IsNil() {
+		return 0, 0
+	}
+
+	if t.isLeaf() {
+		leaves++
+	} else {
+		internal++
+	}
+
+	for _, c := range t.children {
+		if c != nilMap {
+			l, i := c.countNodes()
+			leaves += l
+			internal += i
+		}
+	}
+	return leaves, internal
+}
+
+func (t *tree) MapConcurrent(f func(key string, val Any) Any, workers int) Map {
+	if t.IsNil() {
+		return t
+	}
+	if workers <= 1 {
+		return t.MapValues(f)
+	}
+
+	// number the nodes in pre-order so each worker can write its results
+	// into a fixed slot
+	nodes := make([]*tree, 0, t.count)
+	t.eachNode(func(n *tree) { nodes = append(nodes, n) })
+
+	copies := make([]*tree, len(nodes))
+	var wg sync.WaitGroup
+	chunk := (len(nodes) + workers - 1) / workers
+	for start := 0; start < len(nodes); start += chunk {
+		end := start + chunk
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				copies[i] = nodes[i].mapNodeValues(f)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	next := 0
+	return t.relink(copies, &next)
+}
+
+func (t *tree) ForEachParallel(workers int, f func(key string, val Any)) {
+	if workers <= 1 {
+		t.ForEach(f)
+		return
+	}
+
+	nodes := make([]*tree, 0, t.count)
+	t.eachNode(func(n *tree) { nodes = append(nodes, n) })
+
+	var wg sync.WaitGroup
+	chunk := (len(nodes) + workers - 1) / workers
+	for start := 0; start < len(nodes); start += chunk {
+		end := start + chunk
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		wg.Add(1)
+		go func(nodes []*tree) {
+			defer wg.Done()
+			for _, n := range nodes {
+				n.eachNodeEntry(func(key string, val Any) {
+					if val, ok := resolve(val); ok {
+						f(key, val)
+					}
+				})
+			}
+		}(nodes[start:end])
+	}
+	wg.Wait()
+}
+
+// mapNodeValues returns a copy of the node with f applied to the values of
+// its live keys
+func (t *tree) mapNodeValues(f func(key string, val Any) Any) *tree {
+	apply := func(key string, v Any) Any {
+		val, ok := resolve(v)
+		if !ok {
+			return v
+		}
+		if e, ok := v.(expiring); ok {
+			return expiring{f(key, val), e.expiresAt}
+		}
+		return f(key, val)
+	}
+
+	m := t.clone()
+	m.value = apply(t.key, t.value)
+	if len(t.overflow) > 0 {
+		m.overflow = make([]Entry, len(t.overflow))
+		for i, e := range t.overflow {
+			m.overflow[i] = Entry{e.Key, apply(e.Key, e.Val)}
+		}
+	}
+	return m
+}
+
+// eachNode calls f on every node of the tree in pre-order
+func (t *tree) eachNode(f func(*tree)) {
+	if t.IsNil() {
+		return
+	}
+	f(t)
+	for _, c := range t.children {
+		if c != nilMap {
+			c.eachNode(f)
+		}
+	}
+}
+
+// eachNodeEntry calls f on the key value pairs stored in this node, without
+// resolving tombstones or expiry
+func (t *tree) eachNodeEntry(f func(key string, val Any)) {
+	f(t.key, t.value)
+	for _, e := range t.overflow {
+		f(e.Key, e.Val)
+	}
+}
+
+// eachEntry calls f on every key value pair stored in the tree, without
+// resolving tombstones or expiry
+func (t *tree) eachEntry(f func(key string, val Any)) {
+	t.eachNode(func(n *tree) { n.eachNodeEntry(f) })
+}
+
+// relink replaces the nodes of the tree with copies taken in the same
+// pre-order used by eachNode, linking them into a new tree
+func (t *tree) relink(copies []*tree, next *int) *tree {
+	m := copies[*next]
+	*next++
+	for i, c := range t.children {
+		if c != nilMap {
+			m.children[i] = c.relink(copies, next)
+		}
+	}
+	return m
+}
+
+func (t *tree) Equal(other Map) bool {
+	return t.EqualFunc(other, valuesEqual)
+}
+
+func (t *tree) EqualFunc(other Map, eq func(a, b Any) bool) bool {
+	if other == nil {
+		other = nilMap
+	}
+
+	o, isTree := other.(*tree)
+	if isTree && t == o {
+		return true
+	}
+	if t.Size() != other.Size() {
+		return false
+	}
+	if isTree && t.dead+t.timed == 0 && o.dead+o.timed == 0 && t.opts.sameHash(o.opts) && t.digest != o.digest {
+		return false
+	}
+
+	return t.all(func(key string, val Any) bool {
+		v, ok := other.Lookup(key)
+		return ok && eq(val, v)
+	})
+}
+
+// Equaler is implemented by values which define their own equality, such
+// as one which ignores a timestamp. Equal, Diff and the other operations
+// which compare values call a's Equals method, if it has one, to compare a
+// with b, rather than comparing them with reflect.DeepEqual. Equals should
+// be symmetric, since either value may be the one it's called on.
+//
+// Set still stores a value which Equals the one it replaces: only the
+// values' owner knows whether the fields Equals ignores matter.
+type Equaler interface {
+	Equals(other Any) bool
+}
+
+// valuesEqual compares two values stored in maps. Nested maps with the same
+// contents can have differently shaped trees, so they're compared with Equal.
+func valuesEqual(a, b Any) bool {
+	switch a := a.(type) {
+	case Equaler:
+		return a.Equals(b)
+	case Map:
+		if other, ok := b.(Map); ok {
+			return a.Equal(other)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// all reports whether f returns true for every key value pair in the map,
+// stopping at the first pair for which it returns false
+func (t *tree) all(f func(key string, val Any) bool) bool {
+	if t.IsNil() {
+		return true
+	}
+
+	if val, ok := resolve(t.value); ok && !f(t.key, val) {
+		return false
+	}
+	for _, e := range t.overflow {
+		if val, ok := resolve(e.Val); ok && !f(e.Key, val) {
+			return false
+		}
+	}
+
+	for _, c := range t.children {
+		if c != nilMap && !c.all(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// make it easier to display maps for debugging
+func (t *tree) String() string {
+	return t.Format(FormatOptions{})
 }
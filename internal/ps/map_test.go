@@ -4,6 +4,11 @@ import . "strconv"
 
 import "testing"
 import "sort"
+import "sync"
+import "crypto/sha256"
+import "strings"
+import "math/rand"
+import "math"
 
 func TestMapNil(t *testing.T) {
 	m := NewMap()
@@ -13,6 +18,39 @@ func TestMapNil(t *testing.T) {
 	}
 }
 
+func TestMapIsEmpty(t *testing.T) {
+	if !NewMap().IsEmpty() || !nilMap.IsEmpty() || NewMap().Len() != 0 {
+		t.Errorf("new map should be empty")
+	}
+
+	m := NewMap().Set("a", 1)
+	if m.IsEmpty() || m.Len() != m.Size() {
+		t.Errorf("map with a key shouldn't be empty")
+	}
+	if !m.Delete("a").IsEmpty() {
+		t.Errorf("map with every key deleted should be empty")
+	}
+
+	tombstoned := m.TombstoneDelete("a")
+	if !tombstoned.IsEmpty() || tombstoned.IsNil() {
+		t.Errorf("a map of tombstones is empty but not nil")
+	}
+}
+
+func TestMapClone(t *testing.T) {
+	m := NewMap().Set("a", 1).Set("b", 2)
+	clone := m.Clone()
+	if clone != m || !clone.Equal(m) {
+		t.Errorf("Clone() should return the map itself")
+	}
+	if changed := clone.Set("a", 3); !m.Equal(NewMap().Set("a", 1).Set("b", 2)) || changed.Equal(m) {
+		t.Errorf("changing a clone shouldn't affect the original")
+	}
+
+	type cloner interface{ Clone() Map }
+	var _ cloner = m
+}
+
 func TestMapImmutable(t *testing.T) {
 	// build a couple small maps
 	world := NewMap().Set("hello", "world")
@@ -128,6 +166,710 @@ func TestMapManyKeys(t *testing.T) {
 	}
 }
 
+func TestMapNilValues(t *testing.T) {
+	m := NewMap().Set("other", 1).Set("nil", nil)
+
+	if v, ok := m.Lookup("nil"); !ok || v != nil {
+		t.Errorf("stored nil should be found: %v, %v", v, ok)
+	}
+	if v, ok := m.Lookup("missing"); ok || v != nil {
+		t.Errorf("missing key shouldn't be found: %v, %v", v, ok)
+	}
+	if !m.Contains("nil") || m.Size() != 2 {
+		t.Errorf("stored nil should count as present")
+	}
+	visited := false
+	for it := m.Iterator(); ; {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		visited = visited || (k == "nil" && v == nil)
+	}
+	if !visited {
+		t.Errorf("iterator should visit nil values")
+	}
+	if len(m.Keys()) != 2 || len(m.Values()) != 2 || len(m.ToGoMap()) != 2 {
+		t.Errorf("nil values should be listed")
+	}
+	if m.Equal(NewMap().Set("other", 1)) || m.Equal(NewMap().Set("other", 1).Set("missing", nil)) {
+		t.Errorf("a stored nil isn't the same as a missing key")
+	}
+	if added, _, _ := m.Diff(NewMap().Set("other", 1)); !added.Contains("nil") {
+		t.Errorf("Diff() should see the stored nil as added")
+	}
+	if !m.Intersection(NewMap().Set("nil", 0)).Contains("nil") {
+		t.Errorf("Intersection() should keep the stored nil")
+	}
+
+	deleted := m.Delete("nil")
+	if _, ok := deleted.Lookup("nil"); ok || deleted.Size() != 1 {
+		t.Errorf("Delete() didn't remove the nil value")
+	}
+	if m.Size() != 2 {
+		t.Errorf("Delete() modified the receiving map")
+	}
+
+	// nil values in collision buckets
+	hashes := map[string]uint64{"a": 7, "b": 7}
+	collided := setLowLevel(setLowLevel(nilMap, 7, 7, "a", nil), 7, 7, "b", nil)
+	if _, ok := lookupHashed(collided, hashes, "b"); !ok {
+		t.Errorf("nil value in a collision bucket should be found")
+	}
+	if rest, _ := deleteHashed(collided, hashes, "a"); rest.Size() != 1 {
+		t.Errorf("deleting a nil valued key from a bucket didn't remove it")
+	}
+
+	tr := m.AsTransient()
+	tr.Set("also nil", nil)
+	tr.Delete("nil")
+	if built := tr.Persistent(); !built.Contains("also nil") || built.Contains("nil") {
+		t.Errorf("transient mishandled nil values")
+	}
+}
+
+func TestMapSetSameValue(t *testing.T) {
+	type point struct{ X, Y int }
+	m := NewMap().Set("int", 1).Set("string", "s").Set("struct", point{1, 2}).Set("nil", nil)
+
+	for _, k := range m.Keys() {
+		v, _ := m.Lookup(k)
+		if m.Set(k, v) != m {
+			t.Errorf("setting %s to its current value should return the map", k)
+		}
+	}
+	if m.Set("int", 2) == m || m.Set("int", int64(1)) == m || m.Set("nil", 0) == m {
+		t.Errorf("setting a different value should give a new map")
+	}
+
+	// values which can't be compared with == are stored again
+	withSlice := NewMap().Set("slice", []int{1})
+	v, _ := withSlice.Lookup("slice")
+	if withSlice.Set("slice", v) == withSlice {
+		t.Errorf("slices can't be compared, so should be stored again")
+	}
+	withSliceField := NewMap().Set("iface", struct{ V Any }{[]int{1}})
+	v, _ = withSliceField.Lookup("iface")
+	if withSliceField.Set("iface", v) == withSliceField {
+		t.Errorf("structs holding slices can't be compared, so should be stored again")
+	}
+
+	if tomb := m.TombstoneDelete("int"); tomb.Set("int", 1) == tomb {
+		t.Errorf("setting a deleted key should revive it")
+	}
+
+	// == doesn't tell -0 from 0, but Set must store the new one
+	negZero := math.Copysign(0, -1)
+	for _, test := range []struct{ old, new Any }{
+		{0.0, negZero},
+		{float32(0), float32(negZero)},
+		{complex(0, 1), complex(negZero, 1)},
+		{struct{ F float64 }{0}, struct{ F float64 }{negZero}},
+		{[1]float64{0}, [1]float64{negZero}},
+	} {
+		floats := NewMap().Set("f", test.old)
+		if floats.Set("f", test.new) == floats {
+			t.Errorf("setting %#v over %#v should give a new map", test.new, test.old)
+		}
+	}
+	zero := NewMap().Set("f", 0.0)
+	if v, _ := zero.Set("f", negZero).Lookup("f"); !math.Signbit(v.(float64)) {
+		t.Errorf("-0 didn't replace 0")
+	}
+	if zero.Set("f", 0.0) != zero {
+		t.Errorf("setting a float to the same bits should return the map")
+	}
+}
+
+func TestMapSetIfAbsent(t *testing.T) {
+	m := NewMap().Set("a", 1)
+
+	added, ok := m.SetIfAbsent("b", 2)
+	if !ok || added.Size() != 2 {
+		t.Errorf("missing key wasn't inserted")
+	}
+	if v, _ := added.Lookup("b"); v != 2 || m.Contains("b") {
+		t.Errorf("wrong insertion: %v", v)
+	}
+
+	same, ok := m.SetIfAbsent("a", 100)
+	if ok || same != m {
+		t.Errorf("present key should leave the map unchanged")
+	}
+	if v, _ := same.Lookup("a"); v != 1 {
+		t.Errorf("present key's value was replaced: %v", v)
+	}
+
+	// a deleted key is absent
+	if _, ok := m.TombstoneDelete("a").SetIfAbsent("a", 2); !ok {
+		t.Errorf("tombstoned key should count as absent")
+	}
+}
+
+func TestMapReplace(t *testing.T) {
+	m := NewMap().Set("a", 1)
+
+	replaced, ok := m.Replace("a", 2)
+	if v, _ := replaced.Lookup("a"); !ok || v != 2 {
+		t.Errorf("present key wasn't replaced: %v", v)
+	}
+	if v, _ := m.Lookup("a"); v != 1 {
+		t.Errorf("Replace() modified the receiving map")
+	}
+
+	same, ok := m.Replace("missing", 2)
+	if ok || same != m || same.Contains("missing") {
+		t.Errorf("replacing a missing key should return the map unchanged")
+	}
+	if _, ok := m.TombstoneDelete("a").Replace("a", 2); ok {
+		t.Errorf("tombstoned key shouldn't be replaced")
+	}
+}
+
+func TestMapUpdate(t *testing.T) {
+	increment := func(old Any, existed bool) Any {
+		if !existed {
+			return 1
+		}
+		return old.(int) + 1
+	}
+
+	m := NewMap().Set("a", 1)
+	m2 := m.Update("a", increment).Update("b", increment)
+	if v, _ := m2.Lookup("a"); v != 2 {
+		t.Errorf("existing counter wasn't incremented: %v", v)
+	}
+	if v, _ := m2.Lookup("b"); v != 1 {
+		t.Errorf("missing counter wasn't started: %v", v)
+	}
+	if v, _ := m.Lookup("a"); v != 1 || m.Size() != 1 {
+		t.Errorf("Update() modified the receiving map")
+	}
+
+	decrement := func(old Any, existed bool) Any {
+		if !existed || old.(int) <= 1 {
+			return Remove
+		}
+		return old.(int) - 1
+	}
+	m3 := m2.Update("b", decrement)
+	if _, ok := m3.Lookup("b"); ok || m3.Size() != 1 {
+		t.Errorf("returning Remove should delete the key")
+	}
+	if m4 := m3.Update("missing", decrement); m4 != m3 {
+		t.Errorf("removing a missing key should return the map unchanged")
+	}
+}
+
+func TestFromGoMap(t *testing.T) {
+	native := make(map[string]Any)
+	for i := 0; i < 10000; i++ {
+		native[Itoa(i)] = i
+	}
+
+	m := FromGoMap(native)
+	if m.Size() != len(native) {
+		t.Errorf("wrong size: %d", m.Size())
+	}
+	for k, v := range native {
+		if got, ok := m.Lookup(k); !ok || got != v {
+			t.Errorf("wrong value for %s: %v", k, got)
+		}
+	}
+
+	if m := FromGoMap(nil); !m.IsNil() {
+		t.Errorf("nil map should give an empty map")
+	}
+}
+
+func TestMapToGoMap(t *testing.T) {
+	m := NewMap().Set("a", 1).Set("b", nil)
+	native := m.ToGoMap()
+	if len(native) != 2 || native["a"] != 1 {
+		t.Errorf("wrong contents: %v", native)
+	}
+	if v, ok := native["b"]; !ok || v != nil {
+		t.Errorf("nil value wasn't copied")
+	}
+
+	native["a"] = 100
+	native["c"] = 3
+	delete(native, "b")
+	if v, _ := m.Lookup("a"); v != 1 || m.Size() != 2 || !m.Contains("b") {
+		t.Errorf("modifying the Go map changed the persistent map")
+	}
+	if !FromGoMap(m.ToGoMap()).Equal(m) {
+		t.Errorf("round trip through a Go map changed the map")
+	}
+
+	if native := NewMap().ToGoMap(); native == nil || len(native) != 0 {
+		t.Errorf("empty map should give an empty, non-nil Go map: %#v", native)
+	}
+}
+
+func TestMapContains(t *testing.T) {
+	m := NewMap().Set("a", 1).Set("nil", nil)
+	if !m.Contains("a") || !m.Contains("nil") {
+		t.Errorf("present keys should be contained")
+	}
+	if m.Contains("missing") || m.TombstoneDelete("a").Contains("a") {
+		t.Errorf("missing and deleted keys shouldn't be contained")
+	}
+	if NewMap().Contains("") {
+		t.Errorf("empty map shouldn't contain anything")
+	}
+}
+
+func TestMapGetAll(t *testing.T) {
+	m := NewMap().Set("host", "localhost").Set("port", 5432).Set("nil", nil).Set("other", true)
+
+	got := m.GetAll([]string{"host", "missing", "port", "nil"})
+	if len(got) != 3 || got["host"] != "localhost" || got["port"] != 5432 {
+		t.Errorf("wrong values: %v", got)
+	}
+	if _, ok := got["missing"]; ok {
+		t.Errorf("missing key shouldn't be returned")
+	}
+	if v, ok := got["nil"]; !ok || v != nil {
+		t.Errorf("stored nil should be returned")
+	}
+
+	if got := m.GetAll(nil); got == nil || len(got) != 0 {
+		t.Errorf("no keys should give an empty, non-nil map: %#v", got)
+	}
+}
+
+func TestMapLookupOrDefault(t *testing.T) {
+	m := NewMap().Set("port", 8080).Set("host", nil)
+
+	if v := m.LookupOrDefault("port", 80); v != 8080 {
+		t.Errorf("wrong value for a present key: %v", v)
+	}
+	if v := m.LookupOrDefault("timeout", 30); v != 30 {
+		t.Errorf("wrong value for a missing key: %v", v)
+	}
+	if v := m.LookupOrDefault("host", "localhost"); v != nil {
+		t.Errorf("a stored nil should be returned instead of the default: %v", v)
+	}
+	if v := m.TombstoneDelete("port").LookupOrDefault("port", 80); v != 80 {
+		t.Errorf("a deleted key should give the default: %v", v)
+	}
+}
+
+func TestMapValuesEntries(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 100; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+
+	keys, values, entries := m.Keys(), m.Values(), m.Entries()
+	if len(values) != 100 || len(entries) != 100 {
+		t.Fatalf("wrong lengths: %d values, %d entries", len(values), len(entries))
+	}
+	for i, k := range keys {
+		if n, _ := Atoi(k); values[i] != n {
+			t.Errorf("value %v at index %d doesn't belong to key %s", values[i], i, k)
+		}
+		if entries[i] != (Entry{k, values[i]}) {
+			t.Errorf("wrong entry at index %d: %v", i, entries[i])
+		}
+	}
+
+	tombstoned := m.TombstoneDelete("0")
+	if len(tombstoned.Values()) != 99 || len(tombstoned.Entries()) != 99 {
+		t.Errorf("deleted entries shouldn't be returned")
+	}
+
+	if values := NewMap().Values(); values == nil || len(values) != 0 {
+		t.Errorf("empty map should give an empty, non-nil slice: %#v", values)
+	}
+	if entries := NewMap().Entries(); entries == nil || len(entries) != 0 {
+		t.Errorf("empty map should give an empty, non-nil slice: %#v", entries)
+	}
+}
+
+func TestMapKeysCached(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 100; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+
+	keys := m.Keys()
+	sort.Strings(keys)
+	keys[0] = "changed"
+	again := m.Keys()
+	if len(again) != 100 || sort.StringsAreSorted(again) {
+		t.Errorf("modifying the returned keys changed the map's: %v", again)
+	}
+	for _, k := range again {
+		if k == "changed" {
+			t.Errorf("modifying the returned keys changed the map's")
+		}
+	}
+
+	if keys := m.Set("new", true).Keys(); len(keys) != 101 {
+		t.Errorf("Set() kept the parent's keys: %d", len(keys))
+	}
+	if keys := m.Delete("0").Keys(); len(keys) != 99 {
+		t.Errorf("Delete() kept the parent's keys: %d", len(keys))
+	}
+	if keys := m.TombstoneDelete("0").Keys(); len(keys) != 99 {
+		t.Errorf("TombstoneDelete() kept the parent's keys: %d", len(keys))
+	}
+	if len(m.Keys()) != 100 {
+		t.Errorf("derived maps changed the parent's keys")
+	}
+}
+
+func TestMapMinMaxKey(t *testing.T) {
+	if _, ok := NewMap().MinKey(); ok {
+		t.Errorf("empty map shouldn't have a minimum key")
+	}
+	if _, ok := NewMap().MaxKey(); ok {
+		t.Errorf("empty map shouldn't have a maximum key")
+	}
+
+	m := NewMap().Set("m", 1).Set("b", 2).Set("x", 3).Set("a", 4).Set("z", 5)
+	if k, ok := m.MinKey(); !ok || k != "a" {
+		t.Errorf("wrong minimum key: %q", k)
+	}
+	if k, ok := m.MaxKey(); !ok || k != "z" {
+		t.Errorf("wrong maximum key: %q", k)
+	}
+
+	if k, _ := m.TombstoneDelete("a").MinKey(); k != "b" {
+		t.Errorf("deleted keys shouldn't count: %q", k)
+	}
+	if k, ok := NewMap().Set("", 1).MaxKey(); !ok || k != "" {
+		t.Errorf("the empty key should be found: %q, %v", k, ok)
+	}
+}
+
+func TestMapKeysWithPrefix(t *testing.T) {
+	m := NewMap().
+		Set("db.port", 5432).
+		Set("db.host", "localhost").
+		Set("cache.ttl", 60).
+		Set("db", "not a child")
+
+	keys := m.KeysWithPrefix("db.")
+	if len(keys) != 2 || keys[0] != "db.host" || keys[1] != "db.port" {
+		t.Errorf("wrong keys: %#v", keys)
+	}
+	if keys := m.KeysWithPrefix("missing."); len(keys) != 0 {
+		t.Errorf("expected no keys, got %#v", keys)
+	}
+	if keys := m.KeysWithPrefix(""); len(keys) != 4 {
+		t.Errorf("every key should match the empty prefix: %#v", keys)
+	}
+}
+
+func TestMapKeysOfType(t *testing.T) {
+	m := NewMap().
+		Set("port", 5432).
+		Set("retries", 3).
+		Set("host", "localhost").
+		Set("name", "app").
+		Set("big", int64(1)).
+		Set("unset", nil)
+
+	tests := []struct {
+		example  Any
+		expected string
+	}{
+		{0, "port,retries"},
+		{"", "host,name"},
+		{int64(0), "big"},
+		{nil, "unset"},
+		{1.5, ""},
+	}
+	for _, test := range tests {
+		if got := strings.Join(m.KeysOfType(test.example), ","); got != test.expected {
+			t.Errorf("KeysOfType(%#v) = %s, expected %s", test.example, got, test.expected)
+		}
+	}
+}
+
+func TestMapDeleteMissing(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 100; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+	if m.Delete("missing") != m {
+		t.Errorf("deleting a missing key should return the receiver")
+	}
+	if NewMap().Delete("missing") != NewMap() {
+		t.Errorf("deleting from the empty map should return it")
+	}
+
+	// the key's hash matches a node but the key isn't in its bucket
+	hashes := map[string]uint64{"a": 5, "b": 5, "c": 5}
+	collided := setHashed(nilMap, hashes, "a", "b")
+	if deleted, found := deleteHashed(collided, hashes, "c"); found || deleted != collided {
+		t.Errorf("deleting a missing colliding key should return the receiver")
+	}
+
+	for _, m := range []Map{NewMapWithHash(func(string) uint64 { return 1 }), NewMapWithBloom()} {
+		m = m.Set("a", 1).Set("b", 2)
+		if m.Delete("missing") != m {
+			t.Errorf("deleting a missing key should return the receiver, whatever the options")
+		}
+	}
+}
+
+func TestMapDeletePrefix(t *testing.T) {
+	m := NewMap().
+		Set("feature.experimental.a", 1).
+		Set("feature.experimental.b", 2).
+		Set("feature.stable", 3).
+		Set("other", 4)
+
+	none, removed := m.DeletePrefix("missing.")
+	if removed != 0 || none != m {
+		t.Errorf("removed %d keys matching no prefix", removed)
+	}
+
+	some, removed := m.DeletePrefix("feature.experimental.")
+	if removed != 2 || some.Size() != 2 {
+		t.Errorf("wrong number of keys removed: %d", removed)
+	}
+	if _, ok := some.Lookup("feature.stable"); !ok {
+		t.Errorf("non-matching key was removed")
+	}
+	if m.Size() != 4 {
+		t.Errorf("DeletePrefix() modified the receiving map")
+	}
+
+	all, removed := m.DeletePrefix("")
+	if removed != 4 || all.Size() != 0 {
+		t.Errorf("wrong number of keys removed: %d", removed)
+	}
+}
+
+func TestMapDeleteRange(t *testing.T) {
+	m := NewMap()
+	for _, k := range []string{"a", "c", "e", "g", "i"} {
+		m = m.Set(k, k)
+	}
+
+	tests := []struct {
+		lo, hi   string
+		expected string
+	}{
+		{"c", "g", "a,g,i"}, // lo inclusive, hi exclusive
+		{"b", "h", "a,i"},   // bounds between keys
+		{"", "z", ""},
+		{"a", "b", "c,e,g,i"},
+		{"e", "e", "a,c,e,g,i"},
+		{"g", "c", "a,c,e,g,i"},
+		{"j", "z", "a,c,e,g,i"},
+	}
+	for _, test := range tests {
+		got := m.DeleteRange(test.lo, test.hi)
+		keys := got.SortedKeys()
+		if strings.Join(keys, ",") != test.expected || got.Size() != len(keys) {
+			t.Errorf("DeleteRange(%q, %q) left %v (size %d), expected %s",
+				test.lo, test.hi, keys, got.Size(), test.expected)
+		}
+	}
+	if m.DeleteRange("j", "z") != m {
+		t.Errorf("deleting an empty range should return the receiver")
+	}
+	if m.Size() != 5 {
+		t.Errorf("DeleteRange() modified the receiving map")
+	}
+}
+
+func TestMapCoalesce(t *testing.T) {
+	m := NewMap().Set("a", nil).Set("c", "from c").Set("d", "from d")
+
+	if v, ok := m.Coalesce("missing", "a", "c", "d"); !ok || v != "from c" {
+		t.Errorf("expected the value of c, got %v", v)
+	}
+	if v, ok := m.Coalesce("d", "c"); !ok || v != "from d" {
+		t.Errorf("expected the value of d, got %v", v)
+	}
+	if _, ok := m.Coalesce("a", "missing"); ok {
+		t.Errorf("nil and missing values shouldn't be found")
+	}
+	if _, ok := m.Coalesce(); ok {
+		t.Errorf("no keys shouldn't find a value")
+	}
+}
+
+// setHashed inserts keys with the given hashes, bypassing hashKey
+func setHashed(m *tree, hashes map[string]uint64, keys ...string) *tree {
+	for _, k := range keys {
+		m = setLowLevel(m, hashes[k], hashes[k], k, k)
+	}
+	return m
+}
+
+func lookupHashed(m *tree, hashes map[string]uint64, key string) (Any, bool) {
+	return lookupLowLevel(m, hashes[key], hashes[key], key)
+}
+
+func deleteHashed(m *tree, hashes map[string]uint64, key string) (*tree, bool) {
+	return deleteLowLevel(m, hashes[key], hashes[key], key)
+}
+
+func TestMapDeleteProperty(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	// a poor hash packs the tree with collisions and deep paths
+	poor := func(key string) uint64 { i, _ := Atoi(key); return uint64(i % 61) }
+	for _, m := range []Map{NewMap(), NewMapWithHash(poor), NewMapWithBranching(1)} {
+		expected := map[string]int{}
+		type snapshot struct {
+			m    Map
+			keys map[string]int
+		}
+		var snapshots []snapshot
+
+		for step := 0; step < 5000; step++ {
+			k := Itoa(r.Intn(300))
+			if r.Intn(2) == 0 {
+				m = m.Set(k, step)
+				expected[k] = step
+			} else {
+				m = m.Delete(k)
+				delete(expected, k)
+			}
+
+			if m.Size() != len(expected) {
+				t.Fatalf("step %d: size %d, expected %d", step, m.Size(), len(expected))
+			}
+			if step%250 == 0 {
+				if err := checkCounts(m.(*tree)); err != "" {
+					t.Fatalf("step %d: %s", step, err)
+				}
+				copied := make(map[string]int, len(expected))
+				for k, v := range expected {
+					copied[k] = v
+				}
+				snapshots = append(snapshots, snapshot{m, copied})
+			}
+		}
+
+		// every version must have kept its own keys through later deletes
+		for i, s := range snapshots {
+			if s.m.Size() != len(s.keys) {
+				t.Errorf("snapshot %d: size %d, expected %d", i, s.m.Size(), len(s.keys))
+			}
+			for k, v := range s.keys {
+				if got, ok := s.m.Lookup(k); !ok || got != v {
+					t.Errorf("snapshot %d: Lookup(%q) = %v, %v; expected %d", i, k, got, ok, v)
+				}
+			}
+			for j := 0; j < 300; j++ {
+				if _, ok := s.keys[Itoa(j)]; !ok && s.m.Contains(Itoa(j)) {
+					t.Errorf("snapshot %d: deleted key %d found", i, j)
+				}
+			}
+		}
+	}
+}
+
+// checkCounts describes the first node whose count or digest doesn't match
+// the entries below it, or returns ""
+func checkCounts(t *tree) string {
+	if t.IsNil() {
+		return ""
+	}
+	count := 1 + len(t.overflow)
+	digest := t.hash
+	for range t.overflow {
+		digest ^= t.hash
+	}
+	for _, c := range t.children {
+		if err := checkCounts(c); err != "" {
+			return err
+		}
+		count += c.count
+		digest ^= c.digest
+	}
+	if count != t.count {
+		return "node " + Quote(t.key) + " counts " + Itoa(t.count) + " entries but holds " + Itoa(count)
+	}
+	if digest != t.digest {
+		return "node " + Quote(t.key) + " has a stale digest"
+	}
+	return ""
+}
+
+func TestMapHashCollision(t *testing.T) {
+	// "a", "b" and "c" collide, "d" goes below them
+	hashes := map[string]uint64{"a": 5, "b": 5, "c": 5, "d": 13, "z": 5}
+	m := setHashed(nilMap, hashes, "a", "b", "c", "d")
+
+	if m.Size() != 4 {
+		t.Errorf("wrong size: %d", m.Size())
+	}
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if v, ok := lookupHashed(m, hashes, k); !ok || v != k {
+			t.Errorf("wrong value for %s: %v", k, v)
+		}
+	}
+	if _, ok := lookupHashed(m, hashes, "z"); ok {
+		t.Errorf("found a missing key with a colliding hash")
+	}
+
+	keys := m.Keys()
+	sort.Strings(keys)
+	if len(keys) != 4 || keys[0] != "a" || keys[3] != "d" {
+		t.Errorf("wrong keys: %#v", keys)
+	}
+	visited := 0
+	m.ForEach(func(k string, v Any) { visited++ })
+	if visited != 4 {
+		t.Errorf("ForEach visited %d entries", visited)
+	}
+
+	replaced := setLowLevel(m, 5, 5, "b", "B")
+	if v, _ := lookupHashed(replaced, hashes, "b"); v != "B" || replaced.Size() != 4 {
+		t.Errorf("replacing a colliding key failed: %v", v)
+	}
+	if v, _ := lookupHashed(m, hashes, "b"); v != "b" {
+		t.Errorf("Set() modified the receiving map")
+	}
+
+	for _, k := range []string{"a", "c"} {
+		deleted, found := deleteHashed(m, hashes, k)
+		if !found || deleted.Size() != 3 {
+			t.Errorf("deleting %s failed", k)
+		}
+		if _, ok := lookupHashed(deleted, hashes, k); ok {
+			t.Errorf("%s is still present", k)
+		}
+		for _, other := range []string{"a", "b", "c", "d"} {
+			if _, ok := lookupHashed(deleted, hashes, other); !ok && other != k {
+				t.Errorf("deleting %s lost %s", k, other)
+			}
+		}
+	}
+	if deleted, found := deleteHashed(m, hashes, "z"); found || deleted != m {
+		t.Errorf("deleting a missing key with a colliding hash changed the map")
+	}
+	if m.Size() != 4 {
+		t.Errorf("Delete() modified the receiving map")
+	}
+}
+
+func TestMapHashCollisionMoved(t *testing.T) {
+	// deleting the root moves the colliding node up
+	hashes := map[string]uint64{"x": 0, "a": 8, "b": 8}
+	m := setHashed(nilMap, hashes, "x", "a", "b")
+
+	deleted, _ := deleteHashed(m, hashes, "x")
+	if deleted.Size() != 2 {
+		t.Errorf("wrong size: %d", deleted.Size())
+	}
+	for _, k := range []string{"a", "b"} {
+		if _, ok := lookupHashed(deleted, hashes, k); !ok {
+			t.Errorf("%s was lost", k)
+		}
+	}
+}
+
 func TestMapHashKey(t *testing.T) {
 	hash := hashKey("this is a key")
 	if hash != 10424450902216330915 {
@@ -135,6 +877,243 @@ func TestMapHashKey(t *testing.T) {
 	}
 }
 
+func TestHashKeyStable(t *testing.T) {
+	// maps persisted or sharded by these hashes depend on them not changing
+	tests := []struct {
+		key  string
+		hash uint64
+	}{
+		{"", 0xcbf29ce484222325},
+		{"a", 0xaf63dc4c8601ec8c},
+		{"foobar", 0x85944171f73967e8},
+		{"héllo", 0x0b25d480829d74bf},
+		{"日本", 0x815a76081bfc2484},
+	}
+	for _, test := range tests {
+		if hash := HashKey(test.key); hash != test.hash {
+			t.Errorf("HashKey(%q) = %#x, expected %#x", test.key, hash, test.hash)
+		}
+	}
+
+	// and the map places keys by the same hash
+	m := NewMap().Set("foobar", 1)
+	if m.(*tree).hash != HashKey("foobar") {
+		t.Errorf("the map doesn't place keys by HashKey")
+	}
+}
+
+func TestMapEqual(t *testing.T) {
+	a := NewMap().Set("one", 1).Set("two", 2).Set("three", []int{3})
+	b := NewMap().Set("three", []int{3}).Set("two", 2).Set("one", 1)
+
+	// the cheap checks decide without comparing any values, which the
+	// structural comparison calls eq for
+	compared := 0
+	eq := func(x, y Any) bool { compared++; return valuesEqual(x, y) }
+	check := func(what string, x, y Map, expected bool, comparisons int) {
+		t.Helper()
+		compared = 0
+		if x.EqualFunc(y, eq) != expected {
+			t.Errorf("%s: expected Equal to be %v", what, expected)
+		}
+		if compared != comparisons {
+			t.Errorf("%s: expected %d values compared, got %d", what, comparisons, compared)
+		}
+	}
+
+	check("same contents", a, b, true, 3)
+	check("same contents, the other way", b, a, true, 3)
+	check("identity", a, a, true, 0)
+	check("different sizes", a, a.Delete("one"), false, 0)
+	// without the digest, every key but the missing one would be compared
+	keys := a.Keys()
+	check("different keys", a, a.Delete(keys[len(keys)-1]).Set("four", 1), false, 0)
+
+	if a.Equal(a.Set("two", "2")) {
+		t.Errorf("maps with different values are equal")
+	}
+
+	if !NewMap().Equal(nil) || a.Equal(nil) {
+		t.Errorf("nil map should equal only the empty map")
+	}
+}
+
+func TestMapConcurrent(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 1000; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+
+	double := func(k string, v Any) Any { return v.(int) * 2 }
+	one := m.MapConcurrent(double, 1)
+	eight := m.MapConcurrent(double, 8)
+
+	if !one.Equal(eight) {
+		t.Errorf("results differ between 1 and 8 workers")
+	}
+	for i := 0; i < 1000; i++ {
+		if v, _ := eight.Lookup(Itoa(i)); v != i*2 {
+			t.Errorf("wrong value for key %d: %v", i, v)
+		}
+		if v, _ := m.Lookup(Itoa(i)); v != i {
+			t.Errorf("MapConcurrent() modified the receiving map")
+		}
+	}
+
+	if NewMap().MapConcurrent(double, 8).Size() != 0 {
+		t.Errorf("empty map gained keys")
+	}
+}
+
+func TestMapForEachUntil(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 1000; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+	m = m.TombstoneDelete("0")
+
+	for _, stop := range []int{1, 2, 10, 500} {
+		var visited []string
+		m.ForEachUntil(func(k string, _ Any) bool {
+			visited = append(visited, k)
+			return len(visited) < stop
+		})
+		if len(visited) != stop {
+			t.Errorf("asked to stop after %d entries, visited %d", stop, len(visited))
+		}
+		// the same entries as ForEach visits first
+		keys := m.Keys()
+		for i, k := range visited {
+			if k != keys[i] {
+				t.Errorf("entry %d: visited %s, expected %s", i, k, keys[i])
+				break
+			}
+		}
+	}
+
+	visits := 0
+	m.ForEachUntil(func(string, Any) bool { visits++; return true })
+	if visits != 999 {
+		t.Errorf("expected to visit every live entry, visited %d", visits)
+	}
+	NewMap().ForEachUntil(func(string, Any) bool {
+		t.Errorf("visited an entry of the empty map")
+		return true
+	})
+}
+
+func TestMapForEachParallel(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 1000; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+	m = m.TombstoneDelete("0")
+
+	for _, workers := range []int{1, 3, 8, 2000} {
+		var mu sync.Mutex
+		seen := map[string]int{}
+		m.ForEachParallel(workers, func(k string, v Any) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen[k]++
+			if n, _ := Atoi(k); v != n {
+				t.Errorf("wrong value for %s: %v", k, v)
+			}
+		})
+		if len(seen) != 999 {
+			t.Errorf("%d workers: visited %d keys", workers, len(seen))
+		}
+		for k, n := range seen {
+			if n != 1 {
+				t.Errorf("%d workers: visited %s %d times", workers, k, n)
+			}
+		}
+	}
+
+	NewMap().ForEachParallel(4, func(string, Any) { t.Errorf("empty map has entries") })
+}
+
+// expensive stands in for a callback doing real work
+func expensive(k string, _ Any) {
+	sum := []byte(k)
+	for i := 0; i < 100; i++ {
+		s := sha256.Sum256(sum)
+		sum = s[:]
+	}
+}
+
+func BenchmarkMapForEachSerial(b *testing.B) {
+	m := NewMap()
+	for i := 0; i < 1000; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.ForEach(expensive)
+	}
+}
+
+func BenchmarkMapForEachParallel(b *testing.B) {
+	m := NewMap()
+	for i := 0; i < 1000; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.ForEachParallel(8, expensive)
+	}
+}
+
+func TestMapEqualFunc(t *testing.T) {
+	a := NewMap().Set("x", 1).Set("y", 2.0)
+	b := NewMap().Set("y", 2).Set("x", 1.0)
+
+	if a.Equal(b) {
+		t.Errorf("values of different types are equal")
+	}
+	numeric := func(x, y Any) bool { return toFloat(x) == toFloat(y) }
+	if !a.EqualFunc(b, numeric) {
+		t.Errorf("maps aren't equal with a numeric comparator")
+	}
+	if a.EqualFunc(b.Set("x", 3), numeric) {
+		t.Errorf("differing values on one key are equal")
+	}
+}
+
+func TestMapEqualFuncCaseInsensitive(t *testing.T) {
+	a := NewMap().Set("name", "Alice").Set("city", "PARIS")
+	b := NewMap().Set("city", "paris").Set("name", "alice")
+
+	foldCase := func(x, y Any) bool {
+		return strings.EqualFold(x.(string), y.(string))
+	}
+	if a.Equal(b) {
+		t.Errorf("strings differing in case are equal")
+	}
+	if !a.EqualFunc(b, foldCase) {
+		t.Errorf("maps aren't equal ignoring case")
+	}
+	if a.EqualFunc(b.Set("city", "London"), foldCase) {
+		t.Errorf("different strings are equal ignoring case")
+	}
+
+	// eq must only see keys in both maps, so the int here never reaches it
+	c := NewMap().Set("name", "ALICE").Set("age", 30)
+	if a.EqualFunc(c, foldCase) {
+		t.Errorf("maps with different keys are equal")
+	}
+}
+
+func toFloat(v Any) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return -1
+}
+
 func BenchmarkMapSet(b *testing.B) {
 	m := NewMap()
 	for i := 0; i < b.N; i++ {
@@ -142,6 +1121,23 @@ func BenchmarkMapSet(b *testing.B) {
 	}
 }
 
+func benchmarkMapKeys(b *testing.B, cached bool) {
+	m := NewMap()
+	for i := 0; i < 1000; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !cached {
+			m.(*tree).keys = nil
+		}
+		m.Keys()
+	}
+}
+
+func BenchmarkMapKeys(b *testing.B)         { benchmarkMapKeys(b, true) }
+func BenchmarkMapKeysUncached(b *testing.B) { benchmarkMapKeys(b, false) }
+
 func BenchmarkMapDelete(b *testing.B) {
 	m := NewMap().Set("key", "value")
 	for i := 0; i < b.N; i++ {
@@ -155,3 +1151,311 @@ func BenchmarkHashKey(b *testing.B) {
 		_ = hashKey(key)
 	}
 }
+
+// naturalLess orders runs of digits by their numeric value
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		i, j := 0, 0
+		for i < len(a) && a[i] >= '0' && a[i] <= '9' {
+			i++
+		}
+		for j < len(b) && b[j] >= '0' && b[j] <= '9' {
+			j++
+		}
+		if i > 0 && j > 0 {
+			x, _ := Atoi(a[:i])
+			y, _ := Atoi(b[:j])
+			if x != y {
+				return x < y
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func TestMapForEachKeyCollated(t *testing.T) {
+	m := NewMap()
+	for _, k := range []string{"item10", "item2", "item1", "box3", "item20"} {
+		m = m.Set(k, len(k))
+	}
+
+	var keys []string
+	m.ForEachKeyCollated(naturalLess, func(k string, v Any) {
+		if v != len(k) {
+			t.Errorf("wrong value for %s: %v", k, v)
+		}
+		keys = append(keys, k)
+	})
+
+	expected := []string{"box3", "item1", "item2", "item10", "item20"}
+	if len(keys) != len(expected) {
+		t.Fatalf("wrong keys: %#v", keys)
+	}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Errorf("wrong order: %#v", keys)
+			break
+		}
+	}
+}
+
+func TestMapPageKeys(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 10; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+
+	var all []string
+	pages := 0
+	var cursor PageCursor
+	for more := true; more; {
+		var page []string
+		page, cursor, more = m.PageKeys(cursor, 3)
+		pages++
+		if len(page) > 3 {
+			t.Fatalf("page too long: %#v", page)
+		}
+		all = append(all, page...)
+	}
+
+	if pages != 4 || len(all) != 10 {
+		t.Errorf("expected 10 keys in 4 pages, got %d in %d", len(all), pages)
+	}
+	for i, k := range all {
+		if k != Itoa(i) {
+			t.Errorf("wrong key at %d: %s", i, k)
+		}
+	}
+
+	if page, _, more := m.PageKeys(PageAfter("9"), 3); len(page) != 0 || more {
+		t.Errorf("expected no more keys, got %#v, %v", page, more)
+	}
+	// the page ending exactly at the last key is the final one
+	if page, _, more := m.PageKeys(PageAfter("6"), 3); len(page) != 3 || more {
+		t.Errorf("expected the final page, got %#v, %v", page, more)
+	}
+}
+
+func TestMapPageKeysEmptyKey(t *testing.T) {
+	m := NewMap().Set("", 0).Set("a", 1).Set("b", 2)
+
+	page, cursor, more := m.PageKeys(PageCursor{}, 1)
+	if len(page) != 1 || page[0] != "" || !more {
+		t.Fatalf("expected the first page to hold the empty key, got %#v, %v", page, more)
+	}
+	if page, _, _ = m.PageKeys(cursor, 5); len(page) != 2 || page[0] != "a" {
+		t.Errorf("expected the page after the empty key to start at a, got %#v", page)
+	}
+	if page, _, _ = m.PageKeys(PageAfter(""), 5); len(page) != 2 || page[0] != "a" {
+		t.Errorf("expected PageAfter(\"\") to skip the empty key, got %#v", page)
+	}
+	if page, next, more := m.PageKeys(cursor, 0); len(page) != 0 || next != cursor || !more {
+		t.Errorf("expected an empty page to leave the cursor alone, got %#v, %v, %v", page, next, more)
+	}
+
+	// a cursor saved with After resumes in the same place
+	if key, ok := (PageCursor{}).After(); ok || key != "" {
+		t.Errorf("the zero cursor should start from the first key, got %q, %v", key, ok)
+	}
+	key, ok := cursor.After()
+	if !ok || key != "" {
+		t.Fatalf("expected the cursor after the empty key, got %q, %v", key, ok)
+	}
+	if PageAfter(key) != cursor {
+		t.Errorf("PageAfter(%q) should give back the saved cursor", key)
+	}
+}
+
+func TestMapPageKeysRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := NewMap()
+	for i := 0; i < 500; i++ {
+		m = m.Set(Itoa(r.Intn(10000)), i)
+	}
+
+	for _, limit := range []int{1, 7, 100, 1000} {
+		var all []string
+		var cursor PageCursor
+		for more := true; more; {
+			var page []string
+			page, cursor, more = m.PageKeys(cursor, limit)
+			all = append(all, page...)
+		}
+		if expected := m.SortedKeys(); strings.Join(all, ",") != strings.Join(expected, ",") {
+			t.Errorf("pages of %d don't hold the sorted keys", limit)
+		}
+	}
+}
+
+func TestMapKeysPage(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 10; i++ {
+		m = m.Set(Itoa(i), i)
+	}
+
+	tests := []struct {
+		offset, limit int
+		want          string
+	}{
+		{0, 3, "0,1,2"},
+		{3, 3, "3,4,5"},
+		{9, 3, "9"},
+		{7, 3, "7,8,9"},
+		{8, 10, "8,9"},
+		{10, 3, ""},
+		{25, 3, ""},
+		{0, 10, "0,1,2,3,4,5,6,7,8,9"},
+		{0, 0, "0,1,2,3,4,5,6,7,8,9"},
+		{6, 0, "6,7,8,9"},
+		{10, 0, ""},
+	}
+	for _, test := range tests {
+		page := m.KeysPage(test.offset, test.limit)
+		if page == nil {
+			t.Errorf("KeysPage(%d, %d) returned nil", test.offset, test.limit)
+		}
+		if got := strings.Join(page, ","); got != test.want {
+			t.Errorf("KeysPage(%d, %d) = %q; want %q", test.offset, test.limit, got, test.want)
+		}
+	}
+
+	if page := NewMap().KeysPage(0, 5); len(page) != 0 {
+		t.Errorf("page of an empty map: %#v", page)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("negative offset didn't panic")
+		}
+	}()
+	m.KeysPage(-1, 3)
+}
+
+func TestMapForEachSortedBatch(t *testing.T) {
+	for _, tc := range []struct{ keys, n, batches, last int }{
+		{keys: 9, n: 3, batches: 3, last: 3},
+		{keys: 10, n: 3, batches: 4, last: 1},
+		{keys: 2, n: 5, batches: 1, last: 2},
+		{keys: 0, n: 5, batches: 0},
+	} {
+		m := NewMap()
+		for i := 0; i < tc.keys; i++ {
+			m = m.Set(Itoa(i), i)
+		}
+
+		var batches [][]Entry
+		m.ForEachSortedBatch(tc.n, func(batch []Entry) { batches = append(batches, batch) })
+		if len(batches) != tc.batches {
+			t.Errorf("%d keys: expected %d batches, got %d", tc.keys, tc.batches, len(batches))
+			continue
+		}
+
+		prev := ""
+		for i, batch := range batches {
+			if i < len(batches)-1 && len(batch) != tc.n {
+				t.Errorf("%d keys: batch %d has %d entries", tc.keys, i, len(batch))
+			}
+			for _, e := range batch {
+				if e.Key <= prev {
+					t.Errorf("%d keys: %s isn't after %s", tc.keys, e.Key, prev)
+				}
+				if v, _ := m.Lookup(e.Key); v != e.Val {
+					t.Errorf("wrong value for %s: %v", e.Key, e.Val)
+				}
+				prev = e.Key
+			}
+		}
+		if tc.batches > 0 && len(batches[len(batches)-1]) != tc.last {
+			t.Errorf("%d keys: last batch has %d entries", tc.keys, len(batches[len(batches)-1]))
+		}
+	}
+}
+
+func TestMapSorted(t *testing.T) {
+	m := NewMap()
+	for _, k := range []string{"delta", "alpha", "charlie", "bravo"} {
+		m = m.Set(k, len(k))
+	}
+	expected := []string{"alpha", "bravo", "charlie", "delta"}
+
+	keys := m.SortedKeys()
+	var visited []string
+	m.ForEachSorted(func(k string, v Any) {
+		if v != len(k) {
+			t.Errorf("wrong value for %s: %v", k, v)
+		}
+		visited = append(visited, k)
+	})
+	for i := range expected {
+		if keys[i] != expected[i] || visited[i] != expected[i] {
+			t.Fatalf("wrong order: %#v, %#v", keys, visited)
+		}
+	}
+
+	s := NewMap().Set("b", "2").Set("c", "3").Set("a", "1").String()
+	if s != "{a: 1, b: 2, c: 3}" {
+		t.Errorf("unexpected String(): %q", s)
+	}
+}
+
+func TestMapCountNodes(t *testing.T) {
+	// place keys by hand so the tree shape is known:
+	// 0 is the root, 1 and 2 are its children and 9 hangs below 1
+	var m *tree = nilMap
+	for _, h := range []uint64{0, 1, 2, 9} {
+		m = setLowLevel(m, h, h, Itoa(int(h)), h)
+	}
+
+	if leaves := m.CountLeaves(); leaves != 2 {
+		t.Errorf("wrong number of leaves: %d", leaves)
+	}
+	if internal := m.CountInternal(); internal != 2 {
+		t.Errorf("wrong number of internal nodes: %d", internal)
+	}
+
+	// every node holds exactly one key
+	big := NewMap()
+	for i := 0; i < 100; i++ {
+		big = big.Set(Itoa(i), i)
+	}
+	if total := big.CountLeaves() + big.CountInternal(); total != big.Size() {
+		t.Errorf("node counts don't add up: %d != %d", total, big.Size())
+	}
+
+	if n := NewMap().CountLeaves() + NewMap().CountInternal(); n != 0 {
+		t.Errorf("empty map has nodes: %d", n)
+	}
+}
+
+func BenchmarkMapEqual(b *testing.B) {
+	// same is built separately, in reverse order, so that Equal has to
+	// compare it entry by entry rather than by identity
+	m, same := NewMap(), NewMap()
+	for i := 0; i < 1000; i++ {
+		m = m.Set(Itoa(i), i)
+		same = same.Set(Itoa(999-i), 999-i)
+	}
+	other := m.Delete("0").Set("x", 0)
+
+	b.Run("identity", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.Equal(m)
+		}
+	})
+	b.Run("digest", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.Equal(other)
+		}
+	})
+	b.Run("structural", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.Equal(same)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package ps
+
+// MultiMap is a persistent map from each key to one or more values, for
+// data such as HTTP headers where a key may repeat. Adding a value under a
+// key which is already present keeps the existing values.
+//
+// Like Map, a MultiMap is immutable and safe to copy. The zero value is an
+// empty map.
+type MultiMap struct {
+	m Map // key -> List of values, most recently added first
+}
+
+// NewMultiMap returns a new, empty MultiMap.
+func NewMultiMap() MultiMap {
+	return MultiMap{}
+}
+
+// Size returns the number of keys with at least one value.
+// This takes O(1) time.
+func (mm MultiMap) Size() int {
+	return mm.values().Size()
+}
+
+// Add returns a new map with value added after any other values of key.
+// This operation is O(log N) in the number of keys.
+func (mm MultiMap) Add(key string, value Any) MultiMap {
+	return MultiMap{mm.values().Set(key, mm.list(key).Cons(value))}
+}
+
+// Get returns the values of key in the order they were added, or nil if
+// there are none.
+// This operation is O(log N + M) for M values.
+func (mm MultiMap) Get(key string) []Any {
+	l := mm.list(key)
+	if l.IsNil() {
+		return nil
+	}
+	values := make([]Any, l.Size())
+	i := len(values)
+	l.ForEach(func(v Any) {
+		i--
+		values[i] = v
+	})
+	return values
+}
+
+// RemoveValue returns a new map without the earliest added value of key
+// which is equal to value, as defined by Map.Equal. Other values of key,
+// including any later equal ones, are kept; once the last one is removed
+// the key is too. If key has no such value, the map is returned unchanged.
+// This operation is O(log N + M) for M values.
+func (mm MultiMap) RemoveValue(key string, value Any) MultiMap {
+	values := mm.Get(key)
+	i := 0
+	for i < len(values) && !valuesEqual(values[i], value) {
+		i++
+	}
+	if i == len(values) {
+		return mm
+	}
+
+	rest := NewList()
+	for j, v := range values {
+		if j != i {
+			rest = rest.Cons(v)
+		}
+	}
+	if rest.IsNil() {
+		return MultiMap{mm.values().Delete(key)}
+	}
+	return MultiMap{mm.values().Set(key, rest)}
+}
+
+// Keys returns a slice with all keys in this map.
+// This operation is O(N) in the number of keys.
+func (mm MultiMap) Keys() []string {
+	return mm.values().Keys()
+}
+
+func (mm MultiMap) values() Map {
+	if mm.m == nil {
+		return nilMap
+	}
+	return mm.m
+}
+
+// list returns the values of key, most recently added first
+func (mm MultiMap) list(key string) List {
+	if l, ok := mm.values().Lookup(key); ok {
+		return l.(List)
+	}
+	return NewList()
+}
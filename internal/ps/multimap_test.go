@@ -0,0 +1,59 @@
+package ps
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMultiMap(t *testing.T) {
+	var mm MultiMap
+	if mm.Size() != 0 || mm.Get("a") != nil {
+		t.Errorf("zero MultiMap should be empty")
+	}
+
+	mm = mm.Add("accept", "text/html").Add("accept", "application/json").Add("host", "example.com")
+	if got := mm.Get("accept"); !reflect.DeepEqual(got, []Any{"text/html", "application/json"}) {
+		t.Errorf("values not kept in the order added: %v", got)
+	}
+	if mm.Size() != 2 {
+		t.Errorf("wrong number of keys: %d", mm.Size())
+	}
+	keys := mm.Keys()
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"accept", "host"}) {
+		t.Errorf("wrong keys: %v", keys)
+	}
+
+	more := mm.Add("accept", "*/*")
+	if len(mm.Get("accept")) != 2 || len(more.Get("accept")) != 3 {
+		t.Errorf("Add() modified the receiving map")
+	}
+}
+
+func TestMultiMapRemoveValue(t *testing.T) {
+	mm := NewMultiMap().Add("tag", "a").Add("tag", "b").Add("tag", "a").Add("tag", "c")
+
+	removed := mm.RemoveValue("tag", "a")
+	if got := removed.Get("tag"); !reflect.DeepEqual(got, []Any{"b", "a", "c"}) {
+		t.Errorf("wrong values after removing the first a: %v", got)
+	}
+	if len(mm.Get("tag")) != 4 {
+		t.Errorf("RemoveValue() modified the receiving map")
+	}
+	if got := removed.Add("tag", "d").Get("tag"); got[len(got)-1] != "d" {
+		t.Errorf("values added after a removal should go last: %v", got)
+	}
+
+	if same := mm.RemoveValue("tag", "missing"); !reflect.DeepEqual(same, mm) {
+		t.Errorf("removing a missing value should return the map unchanged")
+	}
+	if same := mm.RemoveValue("missing", "a"); !reflect.DeepEqual(same, mm) {
+		t.Errorf("removing from a missing key should return the map unchanged")
+	}
+
+	single := NewMultiMap().Add("only", 1).Add("other", 2).RemoveValue("only", 1)
+	if single.Get("only") != nil || single.Size() != 1 {
+		t.Errorf("removing the last value should remove the key")
+	}
+}
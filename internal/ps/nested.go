@@ -0,0 +1,110 @@
+package ps
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (t *tree) DeepMerge(other Map) Map {
+	if other == nil {
+		return t
+	}
+
+	var m Map = t
+	forEachStored(other, func(key string, val, stored Any) {
+		if nested, ok := val.(Map); ok {
+			if prev, found := m.Lookup(key); found {
+				if prevNested, ok := prev.(Map); ok {
+					stored = withExpiry(prevNested.DeepMerge(nested), stored)
+				}
+			}
+		}
+		m = m.Set(key, stored)
+	})
+	return m
+}
+
+func (t *tree) Flatten(sep string) Map {
+	var m Map = t.empty()
+
+	var flatten func(prefix string, src Map)
+	flatten = func(prefix string, src Map) {
+		forEachStored(src, func(key string, val, stored Any) {
+			if nested, ok := val.(Map); ok && nested.Size() > 0 {
+				flatten(prefix+key+sep, nested)
+				return
+			}
+			m = m.Set(prefix+key, stored)
+		})
+	}
+	flatten("", t)
+	return m
+}
+
+func (t *tree) Walk(visit func(path []string, val Any) (descend bool)) {
+	var path []string
+	var walk func(m Map)
+	walk = func(m Map) {
+		m.ForEachSorted(func(key string, val Any) {
+			path = append(path, key)
+			if descend := visit(path, val); descend {
+				if nested, ok := val.(Map); ok {
+					walk(nested)
+				}
+			}
+			path = path[:len(path)-1]
+		})
+	}
+	walk(t)
+}
+
+func (t *tree) Unflatten(sep string) Map {
+	// sorting keeps the result independent of iteration order
+	var m Map = t.empty()
+	for _, key := range t.SortedKeys() {
+		if _, stored, ok := lookupStored(t, key); ok {
+			m = setPath(m, strings.Split(key, sep), stored)
+		}
+	}
+	return m
+}
+
+func (t *tree) UnflattenStrict(sep string) (Map, error) {
+	var err error
+	t.all(func(key string, _ Any) bool {
+		path := strings.Split(key, sep)
+		for i := 1; i < len(path); i++ {
+			prefix := strings.Join(path[:i], sep)
+			if val, ok := t.Lookup(prefix); ok {
+				if _, isMap := val.(Map); !isMap {
+					err = fmt.Errorf("key %q is both a value and the prefix of %q", prefix, key)
+					return false
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.Unflatten(sep), nil
+}
+
+// setPath returns m with val stored under the nested maps named by path.
+// Nested maps win over values on the way.
+func setPath(m Map, path []string, val Any) Map {
+	prev, found := m.Lookup(path[0])
+	prevNested, prevIsMap := prev.(Map)
+
+	if len(path) == 1 {
+		if prevIsMap {
+			return m
+		}
+		return m.Set(path[0], val)
+	}
+
+	if !found || !prevIsMap {
+		prevNested = NewMap()
+	}
+	return m.Set(path[0], setPath(prevNested, path[1:], val))
+}
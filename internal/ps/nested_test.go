@@ -0,0 +1,162 @@
+package ps
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeepMerge(t *testing.T) {
+	base := NewMap().
+		Set("name", "base").
+		Set("db", NewMap().Set("host", "localhost").Set("port", 5432))
+	override := NewMap().
+		Set("name", "override").
+		Set("db", NewMap().Set("host", "db.internal"))
+
+	merged := base.DeepMerge(override)
+	expected := NewMap().
+		Set("name", "override").
+		Set("db", NewMap().Set("host", "db.internal").Set("port", 5432))
+	if !merged.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, merged)
+	}
+
+	db, _ := base.Lookup("db")
+	if host, _ := db.(Map).Lookup("host"); host != "localhost" {
+		t.Errorf("DeepMerge() modified the receiving map")
+	}
+}
+
+func TestDeepMergeDisjointLeaves(t *testing.T) {
+	a := NewMap().
+		Set("db", NewMap().Set("host", "localhost")).
+		Set("cache", NewMap().Set("ttl", 60))
+	b := NewMap().
+		Set("db", NewMap().Set("port", 5432)).
+		Set("cache", NewMap().Set("size", 100))
+
+	expected := NewMap().
+		Set("db", NewMap().Set("host", "localhost").Set("port", 5432)).
+		Set("cache", NewMap().Set("ttl", 60).Set("size", 100))
+	if merged := a.DeepMerge(b); !merged.Equal(expected) {
+		t.Errorf("expected the union of the leaves %s, got %s", expected, merged)
+	}
+	if merged := b.DeepMerge(a); !merged.Equal(expected) {
+		t.Errorf("expected the same union merging the other way, got %s", merged)
+	}
+}
+
+func TestDeepMergeCollision(t *testing.T) {
+	nested := NewMap().Set("b", 1)
+
+	// other's value wins whether it's the map or the scalar
+	if m := NewMap().Set("a", "scalar").DeepMerge(NewMap().Set("a", nested)); !m.Equal(NewMap().Set("a", nested)) {
+		t.Errorf("map didn't replace the scalar: %s", m)
+	}
+	if m := NewMap().Set("a", nested).DeepMerge(NewMap().Set("a", "scalar")); !m.Equal(NewMap().Set("a", "scalar")) {
+		t.Errorf("scalar didn't replace the map: %s", m)
+	}
+
+	m := NewMap().Set("a", 1)
+	if m.DeepMerge(nil) != m {
+		t.Errorf("merging nil changed the map")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	m := NewMap().
+		Set("name", "app").
+		Set("db", NewMap().Set("host", "localhost").Set("port", 5432)).
+		Set("secrets", NewMap().Set("token", "x").Set("nested", NewMap().Set("key", "y")))
+
+	var visited []string
+	m.Walk(func(path []string, val Any) bool {
+		visited = append(visited, strings.Join(path, "."))
+		return path[len(path)-1] != "secrets"
+	})
+	expected := "db,db.host,db.port,name,secrets"
+	if got := strings.Join(visited, ","); got != expected {
+		t.Errorf("expected to visit %s, got %s", expected, got)
+	}
+
+	// the whole tree is visited when the visitor always descends
+	count := 0
+	m.Walk(func([]string, Any) bool { count++; return true })
+	if count != 8 {
+		t.Errorf("expected 8 entries in all, visited %d", count)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	m := NewMap().
+		Set("name", "api").
+		Set("db", NewMap().
+			Set("host", "localhost").
+			Set("pool", NewMap().Set("size", 10))).
+		Set("empty", NewMap())
+
+	flat := m.Flatten(".")
+	expected := NewMap().
+		Set("name", "api").
+		Set("db.host", "localhost").
+		Set("db.pool.size", 10).
+		Set("empty", NewMap())
+	if !flat.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, flat)
+	}
+
+	if round := flat.Unflatten("."); !round.Equal(m) {
+		t.Errorf("round trip failed: expected %s, got %s", m, round)
+	}
+}
+
+func TestFlattenSeparatorInKey(t *testing.T) {
+	m := NewMap().Set("a.b", 1).Set("c", NewMap().Set("d", 2))
+	flat := m.Flatten(".")
+	if !flat.Equal(NewMap().Set("a.b", 1).Set("c.d", 2)) {
+		t.Errorf("wrong flattened map: %s", flat)
+	}
+
+	// keys which already contained the separator are split
+	round := flat.Unflatten(".")
+	expected := NewMap().Set("a", NewMap().Set("b", 1)).Set("c", NewMap().Set("d", 2))
+	if !round.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, round)
+	}
+
+	// a different separator leaves them alone
+	if round := m.Flatten("/").Unflatten("/"); !round.Equal(m) {
+		t.Errorf("round trip failed: expected %s, got %s", m, round)
+	}
+}
+
+func TestUnflatten(t *testing.T) {
+	flat := NewMap().Set("db.host", "localhost").Set("db.port", 5432).Set("name", "api")
+	expected := NewMap().
+		Set("db", NewMap().Set("host", "localhost").Set("port", 5432)).
+		Set("name", "api")
+
+	if m := flat.Unflatten("."); !m.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, m)
+	}
+	if m, err := flat.UnflattenStrict("."); err != nil || !m.Equal(expected) {
+		t.Errorf("expected %s, got %s, %v", expected, m, err)
+	}
+}
+
+func TestUnflattenConflict(t *testing.T) {
+	flat := NewMap().Set("a", "scalar").Set("a.b", 1).Set("a.c.d", 2)
+
+	// the nested map wins
+	expected := NewMap().Set("a", NewMap().Set("b", 1).Set("c", NewMap().Set("d", 2)))
+	if m := flat.Unflatten("."); !m.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, m)
+	}
+
+	if _, err := flat.UnflattenStrict("."); err == nil {
+		t.Errorf("expected an error for the conflicting key")
+	}
+	if _, err := flat.Delete("a").Set("a.c", 3).UnflattenStrict("."); err == nil {
+		t.Errorf("expected an error for the nested conflicting key")
+	}
+}
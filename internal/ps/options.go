@@ -0,0 +1,190 @@
+package ps
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// options holds the settings a map was created with. They are carried by
+// the root of every map derived from it; a nil *options means the defaults.
+type options struct {
+	keyPattern *regexp.Regexp
+	hash       func(string) uint64
+	bits       uint // branching factor is 1<<bits; 0 means shiftSize
+	bloom      bool // keep a Bloom filter of the keys; see NewMapWithBloom
+	normalize  func(string) string
+	intern     *sync.Map // the stored copy of each key; see NewMapWithInterning
+}
+
+// NewMapKeyPattern returns a new, empty map which only accepts keys
+// matching re. Set panics on any other key and SetChecked returns an error.
+// Every map derived from the result enforces the same pattern.
+func NewMapKeyPattern(re *regexp.Regexp) Map {
+	return newMapWithOptions(&options{keyPattern: re})
+}
+
+// NewMapWithHash returns a new, empty map which places keys using h rather
+// than the default FNV-1a hash. Every map derived from the result uses h
+// too. Keys which collide under h still work, but a hash with many
+// collisions makes operations degrade towards O(N).
+func NewMapWithHash(h func(string) uint64) Map {
+	if h == nil {
+		panic("NewMapWithHash needs a hash function")
+	}
+	return newMapWithOptions(&options{hash: h})
+}
+
+// NewMapWithBranching returns a new, empty map whose nodes use up to
+// 1<<bits children rather than the default 8, making the tree deeper.
+// Every map derived from the result has the same branching factor. It's
+// meant for measuring how depth affects a workload, as the benchmarks do:
+// every node keeps room for 8 children whatever bits is, so a narrower
+// tree neither saves memory nor makes Set and Delete copy less.
+//
+// Each level places a key by the next bits bits of its hash, but keys are
+// only placed over the first 21 or so levels, so about the low 21*bits
+// bits of the hash are used. Keys
+// which agree on those are chained below one another like keys whose
+// hashes collide, which with bits 1 starts to show in maps of around a
+// million keys. bits must be between 1 and 3; NewMapWithBranching panics
+// otherwise.
+func NewMapWithBranching(bits uint) Map {
+	if bits < 1 || bits > shiftSize {
+		panic(fmt.Sprintf("branching bits must be between 1 and %d, got %d", shiftSize, bits))
+	}
+	return newMapWithOptions(&options{bits: bits})
+}
+
+// NewMapWithKeyNormalizer returns a new, empty map which passes every key
+// through norm before using it, so keys which norm makes the same, such as
+// "Key" and "key" under strings.ToLower, are the same entry. Set stores
+// the normalized key, so Keys and ForEach give normalized keys, and
+// Lookup, Delete and the other operations taking a key normalize it too.
+// A key pattern, if any, is checked against the normalized key. Every map
+// derived from the result normalizes keys the same way.
+//
+// Operations taking a prefix, such as KeysWithPrefix and DeletePrefix,
+// compare it with the stored keys as given: norm isn't assumed to work on
+// parts of keys. norm must be deterministic and should be idempotent;
+// NewMapWithKeyNormalizer panics if it's nil.
+func NewMapWithKeyNormalizer(norm func(string) string) Map {
+	if norm == nil {
+		panic("NewMapWithKeyNormalizer needs a normalization function")
+	}
+	return newMapWithOptions(&options{normalize: norm})
+}
+
+// NewMapWithInterning returns a new, empty map which interns its keys: the
+// first time Set stores a key its string is remembered, and every later Set
+// of an equal key, in this map or any map derived from it, stores that
+// same string instead of the one it was given. Replacing the value of a key
+// already keeps the string stored for it, but a key which is deleted and
+// set again, or set in versions built separately from the same empty map,
+// would otherwise take new memory each time; with interning all of those
+// versions, such as the ones a History retains, share a single copy of
+// each key. MemStats counts the bytes of every key in one map; KeyStrings
+// shows the sharing across versions.
+//
+// Remembered keys are never forgotten, even once no version holds them,
+// so interning suits maps whose set of keys is bounded, however often
+// they're set.
+func NewMapWithInterning() Map {
+	return newMapWithOptions(&options{intern: &sync.Map{}})
+}
+
+func newMapWithOptions(opts *options) *tree {
+	m := nilMap.clone()
+	m.opts = opts
+	return m
+}
+
+// normalizeKey returns the key these options store key as
+func (o *options) normalizeKey(key string) string {
+	if o == nil || o.normalize == nil {
+		return key
+	}
+	return o.normalize(key)
+}
+
+// internKey returns the string to store for key, which has been checked
+func (o *options) internKey(key string) string {
+	if o == nil || o.intern == nil {
+		return key
+	}
+	if stored, ok := o.intern.Load(key); ok {
+		return stored.(string)
+	}
+	stored, _ := o.intern.LoadOrStore(key, key)
+	return stored.(string)
+}
+
+// checkKey returns an error if key isn't accepted by these options
+func (o *options) checkKey(key string) error {
+	if o == nil {
+		return nil
+	}
+	if o.keyPattern != nil && !o.keyPattern.MatchString(key) {
+		return fmt.Errorf("key %q doesn't match pattern %q", key, o.keyPattern)
+	}
+	return nil
+}
+
+// wantsBloom returns true if maps with these options keep a Bloom filter
+func (o *options) wantsBloom() bool {
+	return o != nil && o.bloom
+}
+
+// hashKey returns the hash these options place key by
+func (o *options) hashKey(key string) uint64 {
+	if o == nil || o.hash == nil {
+		return hashKey(key)
+	}
+	return o.hash(key)
+}
+
+// partialHash returns the value hash is placed by. The tree takes
+// shiftSize bits of it per level, so for a smaller branching factor each
+// group of shiftSize bits holds only the next bits bits of hash.
+func (o *options) partialHash(hash uint64) uint64 {
+	if o == nil || o.bits == 0 || o.bits == shiftSize {
+		return hash
+	}
+	var partial uint64
+	mask := uint64(1)<<o.bits - 1
+	for level := uint(0); level*shiftSize < 64 && level*o.bits < 64; level++ {
+		partial |= (hash >> (level * o.bits) & mask) << (level * shiftSize)
+	}
+	return partial
+}
+
+// sameHash reports whether maps with options o and other place every key
+// in the same way, so that their digests can be compared
+func (o *options) sameHash(other *options) bool {
+	if o == other {
+		return true
+	}
+	return (o == nil || o.hash == nil) && (other == nil || other.hash == nil)
+}
+
+// empty returns an empty map with the same options as t
+func (t *tree) empty() *tree {
+	if t.opts == nil {
+		return nilMap
+	}
+	return newMapWithOptions(t.opts)
+}
+
+// adopt returns m with the same options as t. Operations which may replace
+// the root with another node use it to keep the options on the result.
+func (t *tree) adopt(m *tree) *tree {
+	if m.opts == t.opts {
+		return m
+	}
+	if m.IsNil() {
+		return t.empty()
+	}
+	m = m.clone()
+	m.opts = t.opts
+	return m
+}
@@ -0,0 +1,244 @@
+package ps
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNewMapKeyPattern(t *testing.T) {
+	m := NewMapKeyPattern(regexp.MustCompile(`^[a-z0-9._]+$`))
+
+	m, err := m.SetChecked("db.host", "localhost")
+	if err != nil {
+		t.Fatalf("valid key rejected: %v", err)
+	}
+	m = m.Set("db.port", 5432)
+	if m.Size() != 2 {
+		t.Errorf("wrong size: %d", m.Size())
+	}
+
+	if _, err := m.SetChecked("DB Host", "x"); err == nil {
+		t.Errorf("invalid key accepted")
+	}
+
+	var panicVal any
+	func() {
+		defer func() { panicVal = recover() }()
+		m.Set("Bad-Key", 1)
+	}()
+	if panicVal == nil {
+		t.Errorf("expected panic, didn't")
+	}
+
+	// the pattern survives deleting every key
+	empty := m.Delete("db.host").Delete("db.port")
+	if _, err := empty.SetChecked("Bad-Key", 1); err == nil {
+		t.Errorf("pattern was lost after Delete")
+	}
+	if _, err := NewMap().SetChecked("Bad-Key", 1); err != nil {
+		t.Errorf("plain maps should accept any key")
+	}
+}
+
+func TestNewMapWithHash(t *testing.T) {
+	calls := 0
+	constant := func(string) uint64 {
+		calls++
+		return 42
+	}
+
+	m := NewMapWithHash(constant)
+	plain := NewMap()
+	for i := 0; i < 100; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+		plain = plain.Set(strconv.Itoa(i), i)
+	}
+	if calls == 0 {
+		t.Fatalf("custom hash wasn't used")
+	}
+	// every key collides, so they all share the root
+	if root := m.(*tree); root.hash != 42 || len(root.overflow) != 99 {
+		t.Errorf("keys weren't placed by the custom hash")
+	}
+
+	for i := 0; i < 100; i += 2 {
+		m = m.Delete(strconv.Itoa(i))
+		plain = plain.Delete(strconv.Itoa(i))
+	}
+	if m.Size() != 50 {
+		t.Errorf("wrong size: %d", m.Size())
+	}
+	for i := 0; i < 100; i++ {
+		_, ok := m.Lookup(strconv.Itoa(i))
+		if ok != (i%2 == 1) {
+			t.Errorf("wrong presence for %d: %v", i, ok)
+		}
+	}
+	if !m.Equal(plain) || !plain.Equal(m) {
+		t.Errorf("maps with the same contents should be equal whatever their hash")
+	}
+
+	// the hash survives deleting every key
+	for i := 1; i < 100; i += 2 {
+		m = m.Delete(strconv.Itoa(i))
+	}
+	calls = 0
+	if m.Set("a", 1); calls == 0 {
+		t.Errorf("custom hash was lost after Delete")
+	}
+
+	tr := NewMapWithHash(constant).AsTransient()
+	tr.Set("a", 1)
+	tr.Set("b", 2)
+	if root := tr.Persistent().(*tree); root.hash != 42 || len(root.overflow) != 1 {
+		t.Errorf("transient didn't use the custom hash")
+	}
+}
+
+func TestNewMapWithBranching(t *testing.T) {
+	for bits := uint(1); bits <= shiftSize; bits++ {
+		m := NewMapWithBranching(bits)
+		for i := 0; i < 1000; i++ {
+			m = m.Set(strconv.Itoa(i), i)
+		}
+		for i := 0; i < 1000; i += 2 {
+			m = m.Delete(strconv.Itoa(i))
+		}
+
+		if m.Size() != 500 {
+			t.Errorf("bits %d: wrong size %d", bits, m.Size())
+		}
+		for i := 0; i < 1000; i++ {
+			v, ok := m.Lookup(strconv.Itoa(i))
+			if ok != (i%2 == 1) || (ok && v != i) {
+				t.Errorf("bits %d: wrong value for %d: %v", bits, i, v)
+			}
+		}
+
+		m.(*tree).eachNode(func(n *tree) {
+			for i := 1 << bits; i < childCount; i++ {
+				if n.children[i] != nilMap {
+					t.Fatalf("bits %d: node uses child %d", bits, i)
+				}
+			}
+		})
+
+		tr := m.AsTransient()
+		tr.Set("transient", 1)
+		if v, _ := tr.Persistent().Lookup("transient"); v != 1 {
+			t.Errorf("bits %d: transient lost its key", bits)
+		}
+	}
+
+	// smaller factors give deeper trees
+	internalNodes := func(bits uint) int {
+		m := NewMapWithBranching(bits)
+		for i := 0; i < 1000; i++ {
+			m = m.Set(strconv.Itoa(i), i)
+		}
+		return m.CountInternal()
+	}
+	if internalNodes(1) <= internalNodes(3) {
+		t.Errorf("binary tree should need more internal nodes than the default")
+	}
+
+	for _, bits := range []uint{0, shiftSize + 1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic for %d bits", bits)
+				}
+			}()
+			NewMapWithBranching(bits)
+		}()
+	}
+}
+
+func benchmarkBranching(b *testing.B, bits uint, lookup bool) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	build := func() Map {
+		m := NewMapWithBranching(bits)
+		for _, k := range keys {
+			m = m.Set(k, k)
+		}
+		return m
+	}
+
+	if !lookup {
+		for i := 0; i < b.N; i++ {
+			build()
+		}
+		return
+	}
+	m := build()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Lookup(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkBranchingSet2(b *testing.B)    { benchmarkBranching(b, 1, false) }
+func BenchmarkBranchingSet4(b *testing.B)    { benchmarkBranching(b, 2, false) }
+func BenchmarkBranchingSet8(b *testing.B)    { benchmarkBranching(b, 3, false) }
+func BenchmarkBranchingLookup2(b *testing.B) { benchmarkBranching(b, 1, true) }
+func BenchmarkBranchingLookup4(b *testing.B) { benchmarkBranching(b, 2, true) }
+func BenchmarkBranchingLookup8(b *testing.B) { benchmarkBranching(b, 3, true) }
+
+func TestNewMapWithKeyNormalizer(t *testing.T) {
+	m := NewMapWithKeyNormalizer(strings.ToLower)
+
+	m = m.Set("Key", 1)
+	if v, ok := m.Lookup("key"); !ok || v != 1 {
+		t.Errorf("Lookup(key) = %v, %v; want 1, true", v, ok)
+	}
+	if v, ok := m.Lookup("KEY"); !ok || v != 1 {
+		t.Errorf("Lookup(KEY) = %v, %v; want 1, true", v, ok)
+	}
+	m = m.Set("KEY", 2).Set("Other", 3)
+	if m.Size() != 2 {
+		t.Errorf("wrong size: %d", m.Size())
+	}
+	keys := m.Keys()
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "key,other" {
+		t.Errorf("keys not normalized: %v", keys)
+	}
+
+	// derived maps normalize keys too
+	derived := m.Filter(func(_ string, v Any) bool { return v.(int) > 2 })
+	if _, ok := derived.Lookup("OTHER"); !ok {
+		t.Errorf("normalization lost by Filter")
+	}
+	derived = m.Merge(NewMap().Set("x", 4))
+	if v, ok := derived.Lookup("X"); !ok || v != 4 {
+		t.Errorf("normalization lost by Merge: %v, %v", v, ok)
+	}
+
+	m = m.Delete("OTHER")
+	if _, ok := m.Lookup("other"); ok || m.Size() != 1 {
+		t.Errorf("Delete(OTHER) kept other")
+	}
+
+	tr := m.AsTransient()
+	tr.Set("New", 5)
+	tr.Delete("KEY")
+	m = tr.Persistent()
+	if _, ok := m.Lookup("new"); !ok || m.Size() != 1 {
+		t.Errorf("Transient didn't normalize keys: %v", m.Keys())
+	}
+
+	c := m.Cursor()
+	c.Set("CURSOR", 6)
+	if v, ok := c.Lookup("Cursor"); !ok || v != 6 {
+		t.Errorf("Cursor.Lookup(Cursor) = %v, %v; want 6, true", v, ok)
+	}
+	if _, ok := c.Map().Lookup("cursor"); !ok {
+		t.Errorf("Cursor didn't normalize keys")
+	}
+}
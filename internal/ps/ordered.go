@@ -0,0 +1,128 @@
+package ps
+
+// OrderedMap is a persistent map which remembers the order its keys were
+// first set in. Setting a key which is already present changes its value
+// but keeps its position; deleting it and setting it again moves it to the
+// end.
+//
+// Like Map, an OrderedMap is immutable and safe to copy. The zero value is
+// an empty map.
+type OrderedMap struct {
+	entries Map    // key -> orderedEntry
+	order   Vector // keys by position; deleted keys leave a deletedKey
+	dead    int    // number of deleted positions in order
+}
+
+// orderedEntry is what an OrderedMap stores for each key
+type orderedEntry struct {
+	position int
+	val      Any
+}
+
+// deletedKey marks the position of a deleted key in an OrderedMap's order
+type deletedKey struct{}
+
+// NewOrderedMap returns a new, empty OrderedMap.
+func NewOrderedMap() OrderedMap {
+	return OrderedMap{}
+}
+
+// Size returns the number of key value pairs in the map.
+// This takes O(1) time.
+func (o OrderedMap) Size() int {
+	return o.entriesMap().Size()
+}
+
+// Set returns a new map in which key and value are associated. A new key
+// goes after every other key.
+// This operation is O(log N) in the number of keys.
+func (o OrderedMap) Set(key string, value Any) OrderedMap {
+	entries, order := o.entriesMap(), o.orderVector()
+	if old, ok := entries.Lookup(key); ok {
+		e := old.(orderedEntry)
+		e.val = value
+		o.entries = entries.Set(key, e)
+		return o
+	}
+
+	o.entries = entries.Set(key, orderedEntry{order.Len(), value})
+	o.order = order.Append(key)
+	return o
+}
+
+// Delete returns a new map with the association for key, if any, removed.
+// Deleted keys keep their position until they make up half of the
+// positions, when the order is rebuilt without them.
+// This operation is amortized O(log N) in the number of keys.
+func (o OrderedMap) Delete(key string) OrderedMap {
+	entries := o.entriesMap()
+	old, ok := entries.Lookup(key)
+	if !ok {
+		return o
+	}
+
+	o.entries = entries.Delete(key)
+	o.order = o.order.Set(old.(orderedEntry).position, deletedKey{})
+	o.dead++
+	if o.dead*2 >= o.order.Len() {
+		o = o.compact()
+	}
+	return o
+}
+
+// Lookup returns the value associated with a key, if any.  If the key
+// exists, the second return value is true; otherwise, false.
+// This operation is O(log N) in the number of keys.
+func (o OrderedMap) Lookup(key string) (Any, bool) {
+	e, ok := o.entriesMap().Lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return e.(orderedEntry).val, true
+}
+
+// ForEach executes a callback on each key value pair in the map, in the
+// order the keys were first set.
+// This operation is O(N log N) in the number of keys.
+func (o OrderedMap) ForEach(f func(key string, val Any)) {
+	o.orderVector().ForEach(func(_ int, k Any) {
+		if key, ok := k.(string); ok {
+			val, _ := o.Lookup(key)
+			f(key, val)
+		}
+	})
+}
+
+// Keys returns a slice with all keys in this map, in the order they were
+// first set.
+// This operation is O(N) in the number of keys.
+func (o OrderedMap) Keys() []string {
+	keys := make([]string, 0, o.Size())
+	o.orderVector().ForEach(func(_ int, k Any) {
+		if key, ok := k.(string); ok {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// compact rebuilds the order without deleted keys
+func (o OrderedMap) compact() OrderedMap {
+	var c OrderedMap
+	o.ForEach(func(key string, val Any) { c = c.Set(key, val) })
+	return c
+}
+
+func (o OrderedMap) entriesMap() Map {
+	if o.entries == nil {
+		return nilMap
+	}
+	return o.entries
+}
+
+func (o OrderedMap) orderVector() Vector {
+	if o.order == nil {
+		return nilVector
+	}
+	return o.order
+}
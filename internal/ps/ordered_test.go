@@ -0,0 +1,79 @@
+package ps
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestOrderedMap(t *testing.T) {
+	var o OrderedMap
+	if o.Size() != 0 || len(o.Keys()) != 0 {
+		t.Errorf("zero OrderedMap should be empty")
+	}
+
+	o = o.Set("zebra", 1).Set("apple", 2).Set("mango", 3)
+	if keys := o.Keys(); !reflect.DeepEqual(keys, []string{"zebra", "apple", "mango"}) {
+		t.Errorf("keys not in insertion order: %v", keys)
+	}
+
+	// updating keeps the position
+	updated := o.Set("zebra", 10)
+	if keys := updated.Keys(); keys[0] != "zebra" || len(keys) != 3 {
+		t.Errorf("updated key moved: %v", keys)
+	}
+	if v, _ := updated.Lookup("zebra"); v != 10 {
+		t.Errorf("wrong value after update: %v", v)
+	}
+	if v, _ := o.Lookup("zebra"); v != 1 {
+		t.Errorf("Set() modified the receiving map")
+	}
+
+	// deleting and setting again moves the key to the end
+	moved := o.Delete("zebra").Set("zebra", 1)
+	if keys := moved.Keys(); !reflect.DeepEqual(keys, []string{"apple", "mango", "zebra"}) {
+		t.Errorf("re-added key should go last: %v", keys)
+	}
+	if _, ok := o.Delete("apple").Lookup("apple"); ok {
+		t.Errorf("deleted key was found")
+	}
+	if o.Delete("missing").Size() != 3 {
+		t.Errorf("deleting a missing key changed the map")
+	}
+
+	var visited []string
+	moved.ForEach(func(k string, v Any) {
+		visited = append(visited, k+"="+strconv.Itoa(v.(int)))
+	})
+	if !reflect.DeepEqual(visited, []string{"apple=2", "mango=3", "zebra=1"}) {
+		t.Errorf("wrong ForEach order: %v", visited)
+	}
+}
+
+func TestOrderedMapManyDeletes(t *testing.T) {
+	o := NewOrderedMap()
+	for i := 0; i < 100; i++ {
+		o = o.Set(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 100; i++ {
+		if i%3 != 0 {
+			o = o.Delete(strconv.Itoa(i))
+		}
+	}
+
+	var expected []string
+	for i := 0; i < 100; i += 3 {
+		expected = append(expected, strconv.Itoa(i))
+	}
+	if keys := o.Keys(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("wrong keys after deletes: %v", keys)
+	}
+	if o.order.Len() > 2*o.Size() {
+		t.Errorf("deleted positions weren't compacted: %d for %d keys", o.order.Len(), o.Size())
+	}
+
+	o = o.Set("new", -1)
+	if keys := o.Keys(); keys[len(keys)-1] != "new" {
+		t.Errorf("key set after compaction should go last: %v", keys)
+	}
+}
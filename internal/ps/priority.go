@@ -0,0 +1,113 @@
+package ps
+
+// PriorityQueue is a persistent queue of possibly heterogenous values which
+// are retrieved lowest priority first. Values with the same priority come
+// out in the order they were inserted. Inserting and deleting return new
+// queues, so every earlier version stays valid.
+type PriorityQueue interface {
+	// Insert returns a new queue with v added at the given priority.
+	// This takes O(log N) time.
+	Insert(priority int, v Any) PriorityQueue
+
+	// FindMin returns the value with the lowest priority, if any.
+	// This takes O(1) time.
+	FindMin() (Any, bool)
+
+	// DeleteMin returns the value with the lowest priority and a queue
+	// without it. If the queue is empty, the third return value is false
+	// and the queue is returned unchanged.  This takes O(log N) time.
+	DeleteMin() (Any, PriorityQueue, bool)
+
+	// Size returns the number of values in the queue.  This takes O(1) time.
+	Size() int
+}
+
+// priorityQueue is a leftist heap: every node has a lower priority than
+// its children, and the path down the right children is never longer than
+// the one down the left. Merging two heaps only walks their right paths,
+// whose length is O(log N), and copies the nodes along them, so the rest
+// of each heap is shared.
+type priorityQueue struct {
+	root *heapNode
+	size int
+	seq  uint64 // sequence number of the next value inserted
+}
+
+type heapNode struct {
+	priority    int
+	seq         uint64 // breaks ties between equal priorities
+	value       Any
+	rank        int // length of the right path, counting this node
+	left, right *heapNode
+}
+
+// An empty priority queue shared by all priority queues
+var nilPriorityQueue = &priorityQueue{}
+
+// NewPriorityQueue returns a new, empty priority queue.
+func NewPriorityQueue() PriorityQueue {
+	return nilPriorityQueue
+}
+
+func (q *priorityQueue) Insert(priority int, v Any) PriorityQueue {
+	n := &heapNode{priority: priority, seq: q.seq, value: v, rank: 1}
+	return &priorityQueue{mergeHeaps(q.root, n), q.size + 1, q.seq + 1}
+}
+
+func (q *priorityQueue) FindMin() (Any, bool) {
+	if q.root == nil {
+		return nil, false
+	}
+	return q.root.value, true
+}
+
+func (q *priorityQueue) DeleteMin() (Any, PriorityQueue, bool) {
+	if q.root == nil {
+		return nil, q, false
+	}
+	if q.size == 1 {
+		return q.root.value, nilPriorityQueue, true
+	}
+	rest := &priorityQueue{mergeHeaps(q.root.left, q.root.right), q.size - 1, q.seq}
+	return q.root.value, rest, true
+}
+
+func (q *priorityQueue) Size() int {
+	return q.size
+}
+
+// before reports whether n comes out of the queue before other
+func (n *heapNode) before(other *heapNode) bool {
+	if n.priority != other.priority {
+		return n.priority < other.priority
+	}
+	return n.seq < other.seq
+}
+
+func (n *heapNode) rankOf() int {
+	if n == nil {
+		return 0
+	}
+	return n.rank
+}
+
+// mergeHeaps returns a heap holding the values of a and b, which are left
+// unchanged
+func mergeHeaps(a, b *heapNode) *heapNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if b.before(a) {
+		a, b = b, a
+	}
+	m := *a
+	m.right = mergeHeaps(a.right, b)
+	if m.left.rankOf() < m.right.rankOf() {
+		m.left, m.right = m.right, m.left
+	}
+	m.rank = m.right.rankOf() + 1
+	return &m
+}
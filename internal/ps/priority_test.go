@@ -0,0 +1,114 @@
+package ps
+
+import "testing"
+
+// drainPriorities deletes everything left in q, lowest priority first
+func drainPriorities(q PriorityQueue) []Any {
+	var out []Any
+	for {
+		v, next, ok := q.DeleteMin()
+		if !ok {
+			return out
+		}
+		out = append(out, v)
+		q = next
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	empty := NewPriorityQueue()
+	if _, ok := empty.FindMin(); ok || empty.Size() != 0 {
+		t.Errorf("new priority queue should be empty")
+	}
+	if _, q, ok := empty.DeleteMin(); ok || q != empty {
+		t.Errorf("deleting from an empty priority queue should fail")
+	}
+
+	q := empty
+	for _, p := range []int{5, 3, 8, 1, 9, 2, 7, 4, 6, 0} {
+		q = q.Insert(p, p*10)
+	}
+	if min, ok := q.FindMin(); !ok || min != 0 || q.Size() != 10 {
+		t.Errorf("wrong min %v or size %d", min, q.Size())
+	}
+	got := drainPriorities(q)
+	if len(got) != 10 {
+		t.Fatalf("drained %d values, expected 10", len(got))
+	}
+	for i, v := range got {
+		if v != i*10 {
+			t.Errorf("deleted %v at %d, expected %d", v, i, i*10)
+		}
+	}
+	if q.Size() != 10 || empty.Size() != 0 {
+		t.Errorf("deleting modified earlier versions")
+	}
+}
+
+func TestPriorityQueueTies(t *testing.T) {
+	q := NewPriorityQueue().Insert(2, "c").Insert(1, "a").Insert(2, "d").Insert(1, "b")
+	got := drainPriorities(q)
+	for i, expected := range []string{"a", "b", "c", "d"} {
+		if got[i] != expected {
+			t.Errorf("got %v, expected insertion order within a priority", got)
+			break
+		}
+	}
+}
+
+func TestPriorityQueueBranches(t *testing.T) {
+	base := NewPriorityQueue().Insert(3, 3).Insert(1, 1).Insert(5, 5)
+
+	left := base.Insert(0, 0)
+	_, right, _ := base.DeleteMin()
+	right = right.Insert(4, 4)
+	_, left, _ = left.DeleteMin()
+	left = left.Insert(2, 2)
+
+	check := func(name string, q PriorityQueue, expected ...Any) {
+		got := drainPriorities(q)
+		if len(got) != len(expected) || q.Size() != len(expected) {
+			t.Errorf("%s: got %v, expected %v", name, got, expected)
+			return
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("%s: got %v, expected %v", name, got, expected)
+				return
+			}
+		}
+	}
+	check("base", base, 1, 3, 5)
+	check("left", left, 1, 2, 3, 5)
+	check("right", right, 3, 4, 5)
+}
+
+func TestPriorityQueueLeftist(t *testing.T) {
+	q := NewPriorityQueue()
+	for i := 0; i < 1000; i++ {
+		q = q.Insert((i*7919)%1000, i)
+		if i%3 == 0 {
+			_, q, _ = q.DeleteMin()
+		}
+	}
+
+	var check func(n *heapNode) int
+	check = func(n *heapNode) int {
+		if n == nil {
+			return 0
+		}
+		size := 1 + check(n.left) + check(n.right)
+		if n.left.rankOf() < n.right.rankOf() || n.rank != n.right.rankOf()+1 {
+			t.Fatalf("node %d breaks the leftist property", n.priority)
+		}
+		for _, c := range []*heapNode{n.left, n.right} {
+			if c != nil && c.before(n) {
+				t.Fatalf("child %d comes before its parent %d", c.priority, n.priority)
+			}
+		}
+		return size
+	}
+	if size := check(q.(*priorityQueue).root); size != q.Size() {
+		t.Errorf("heap holds %d values, Size is %d", size, q.Size())
+	}
+}
@@ -0,0 +1,74 @@
+package ps
+
+// Queue is a persistent first in, first out queue of possibly heterogenous
+// values. Enqueueing and dequeueing return new queues, so every earlier
+// version stays valid.
+type Queue interface {
+	// Enqueue returns a new queue with v added at the back.
+	// This takes amortized O(1) time.
+	Enqueue(v Any) Queue
+
+	// Dequeue returns the value at the front and a queue without it. If
+	// the queue is empty, the third return value is false and the queue is
+	// returned unchanged.  This takes amortized O(1) time.
+	Dequeue() (Any, Queue, bool)
+
+	// Peek returns the value at the front, if any.  This takes O(1) time.
+	Peek() (Any, bool)
+
+	// Size returns the number of values in the queue.  This takes O(1) time.
+	Size() int
+}
+
+// queue is a banker's queue: values are dequeued from the head of front
+// and enqueued onto the head of back, which is reversed to become the new
+// front once front runs out. Front is only empty if the whole queue is, so
+// the next value is always at its head.
+//
+// The amortized bounds assume each version is dequeued from once;
+// repeatedly dequeueing from a version which is about to reverse back
+// repeats the O(N) reversal each time.
+type queue struct {
+	front, back List
+}
+
+// An empty queue shared by all queues
+var nilQueue = &queue{nilList, nilList}
+
+// NewQueue returns a new, empty queue.
+func NewQueue() Queue {
+	return nilQueue
+}
+
+// newQueue keeps the invariant that front is only empty if back is too
+func newQueue(front, back List) *queue {
+	if front.IsNil() {
+		if back.IsNil() {
+			return nilQueue
+		}
+		return &queue{back.Reverse(), nilList}
+	}
+	return &queue{front, back}
+}
+
+func (q *queue) Enqueue(v Any) Queue {
+	return newQueue(q.front, q.back.Cons(v))
+}
+
+func (q *queue) Dequeue() (Any, Queue, bool) {
+	if q.front.IsNil() {
+		return nil, q, false
+	}
+	return q.front.Head(), newQueue(q.front.Tail(), q.back), true
+}
+
+func (q *queue) Peek() (Any, bool) {
+	if q.front.IsNil() {
+		return nil, false
+	}
+	return q.front.Head(), true
+}
+
+func (q *queue) Size() int {
+	return q.front.Size() + q.back.Size()
+}
@@ -0,0 +1,72 @@
+package ps
+
+import "testing"
+
+// drain dequeues everything left in q
+func drain(q Queue) []Any {
+	var out []Any
+	for {
+		v, next, ok := q.Dequeue()
+		if !ok {
+			return out
+		}
+		out = append(out, v)
+		q = next
+	}
+}
+
+func TestQueue(t *testing.T) {
+	empty := NewQueue()
+	if _, ok := empty.Peek(); ok || empty.Size() != 0 {
+		t.Errorf("new queue should be empty")
+	}
+	if _, q, ok := empty.Dequeue(); ok || q != empty {
+		t.Errorf("dequeueing an empty queue should fail")
+	}
+
+	q := empty
+	for i := 0; i < 10; i++ {
+		q = q.Enqueue(i)
+	}
+	if front, ok := q.Peek(); !ok || front != 0 || q.Size() != 10 {
+		t.Errorf("wrong front %v or size %d", front, q.Size())
+	}
+	for i, v := range drain(q) {
+		if v != i {
+			t.Errorf("dequeued %v, expected %d", v, i)
+		}
+	}
+	if !empty.(*queue).front.IsNil() {
+		t.Errorf("enqueueing modified the empty queue")
+	}
+}
+
+func TestQueueBranches(t *testing.T) {
+	base := NewQueue().Enqueue(1).Enqueue(2)
+	_, base, _ = base.Dequeue()
+	base = base.Enqueue(3)
+
+	// two versions derived from the same queue, interleaving operations
+	left := base.Enqueue("left")
+	_, right, _ := base.Dequeue()
+	right = right.Enqueue("right")
+	_, left, _ = left.Dequeue()
+	left = left.Enqueue("left again")
+
+	check := func(name string, q Queue, expected ...Any) {
+		got := drain(q)
+		if len(got) != len(expected) || q.Size() != len(expected) {
+			t.Errorf("%s: got %v, expected %v", name, got, expected)
+			return
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("%s: got %v, expected %v", name, got, expected)
+				return
+			}
+		}
+	}
+	check("base", base, 2, 3)
+	check("left", left, 3, "left", "left again")
+	check("right", right, 3, "right")
+}
@@ -0,0 +1,119 @@
+package ps
+
+import "sync/atomic"
+
+// Ref holds the current version of a map for sharing between goroutines.
+// Readers Load whichever version is current and keep a consistent view of
+// it however the Ref changes. Writers replace the version atomically, so
+// a Ref is safe for concurrent use without locks.
+//
+// The zero Ref holds an empty map. A Ref must not be copied after first
+// use.
+type Ref struct {
+	p    atomic.Pointer[refVersion]
+	subs atomic.Pointer[[]*subscriber] // replaced, never modified
+}
+
+type subscriber struct {
+	f func(old, new Map)
+}
+
+// refVersion boxes a Map, which is an interface, for atomic.Pointer
+type refVersion struct {
+	m Map
+}
+
+// NewRef returns a Ref holding m.
+func NewRef(m Map) *Ref {
+	r := &Ref{}
+	r.Store(m)
+	return r
+}
+
+// Load returns the current version of the map.
+func (r *Ref) Load() Map {
+	return r.p.Load().value()
+}
+
+// Store makes m the current version of the map. A nil m stores an empty
+// map.
+func (r *Ref) Store(m Map) {
+	next := &refVersion{m}
+	old := r.p.Swap(next)
+	r.notify(old, next)
+}
+
+// Update makes f(current) the current version of the map. If another
+// goroutine changes the map while f runs, f is called again with the new
+// version, so f must be free of side effects and may run several times.
+func (r *Ref) Update(f func(Map) Map) {
+	for {
+		old := r.p.Load()
+		next := &refVersion{f(old.value())}
+		if r.p.CompareAndSwap(old, next) {
+			r.notify(old, next)
+			return
+		}
+	}
+}
+
+// Subscribe arranges for f to be called with the previous and the new
+// version every time the map is replaced by Store or Update, and returns a
+// function which cancels the subscription.
+//
+// f runs synchronously, on the goroutine which replaced the map, once the
+// new version is current; a slow f holds up that writer but not readers or
+// other writers. When several goroutines replace the map at once, their
+// calls to f may overlap and needn't arrive in the order the versions were
+// stored, so f must be safe for concurrent use. A Store which happens to
+// store the current version again is still reported.
+func (r *Ref) Subscribe(f func(old, new Map)) (unsubscribe func()) {
+	s := &subscriber{f}
+	r.editSubscribers(func(subs []*subscriber) []*subscriber {
+		return append(subs, s)
+	})
+	return func() {
+		r.editSubscribers(func(subs []*subscriber) []*subscriber {
+			for i, other := range subs {
+				if other == s {
+					return append(subs[:i], subs[i+1:]...)
+				}
+			}
+			return subs
+		})
+	}
+}
+
+// editSubscribers replaces the subscribers with those f returns. f is
+// given a copy it may modify.
+func (r *Ref) editSubscribers(f func([]*subscriber) []*subscriber) {
+	for {
+		old := r.subs.Load()
+		var subs []*subscriber
+		if old != nil {
+			subs = append(subs, *old...)
+		}
+		subs = f(subs)
+		if r.subs.CompareAndSwap(old, &subs) {
+			return
+		}
+	}
+}
+
+func (r *Ref) notify(old, next *refVersion) {
+	subs := r.subs.Load()
+	if subs == nil {
+		return
+	}
+	for _, s := range *subs {
+		s.f(old.value(), next.value())
+	}
+}
+
+// value returns the boxed map, or an empty one if there is none
+func (v *refVersion) value() Map {
+	if v == nil || v.m == nil {
+		return nilMap
+	}
+	return v.m
+}
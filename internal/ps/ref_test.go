@@ -0,0 +1,101 @@
+package ps
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRef(t *testing.T) {
+	var zero Ref
+	if !zero.Load().IsNil() {
+		t.Errorf("zero Ref should hold an empty map")
+	}
+
+	m := NewMap().Set("a", 1)
+	r := NewRef(m)
+	if r.Load() != m {
+		t.Errorf("Load() should return the stored map")
+	}
+
+	r.Update(func(m Map) Map { return m.Set("b", 2) })
+	if r.Load().Size() != 2 || m.Size() != 1 {
+		t.Errorf("Update() should replace the map without changing the old version")
+	}
+
+	r.Store(nil)
+	if !r.Load().IsNil() {
+		t.Errorf("storing nil should hold an empty map")
+	}
+}
+
+func TestRefConcurrentUpdate(t *testing.T) {
+	r := NewRef(NewMap().Set("count", 0))
+
+	const goroutines, updates = 16, 200
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				r.Update(func(m Map) Map {
+					count, _ := m.Lookup("count")
+					return m.Set("count", count.(int)+1).Set(strconv.Itoa(g)+"/"+strconv.Itoa(i), true)
+				})
+				r.Load().Lookup("count")
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	m := r.Load()
+	if count, _ := m.Lookup("count"); count != goroutines*updates {
+		t.Errorf("lost updates: count is %v", count)
+	}
+	if m.Size() != goroutines*updates+1 {
+		t.Errorf("lost updates: size is %d", m.Size())
+	}
+}
+
+func TestRefSubscribe(t *testing.T) {
+	var r Ref
+	type change struct{ old, new Map }
+	var changes []change
+	unsubscribe := r.Subscribe(func(old, new Map) {
+		if r.Load() != new {
+			t.Errorf("subscriber called before the new version was current")
+		}
+		changes = append(changes, change{old, new})
+	})
+	calls := 0
+	unsubscribeOther := r.Subscribe(func(Map, Map) { calls++ })
+
+	first := NewMap().Set("a", 1)
+	r.Store(first)
+	r.Update(func(m Map) Map { return m.Set("b", 2) })
+	if len(changes) != 2 || calls != 2 {
+		t.Fatalf("expected 2 notifications each, got %d and %d", len(changes), calls)
+	}
+	if !changes[0].old.IsNil() || changes[0].new != first {
+		t.Errorf("wrong versions for Store(): %s -> %s", changes[0].old, changes[0].new)
+	}
+	if changes[1].old != first || changes[1].new != r.Load() {
+		t.Errorf("wrong versions for Update(): %s -> %s", changes[1].old, changes[1].new)
+	}
+
+	unsubscribe()
+	unsubscribe() // cancelling twice is harmless
+	r.Store(NewMap())
+	if len(changes) != 2 {
+		t.Errorf("subscriber called after unsubscribing")
+	}
+	if calls != 3 {
+		t.Errorf("unsubscribing one subscriber stopped another")
+	}
+	unsubscribeOther()
+	r.Store(first)
+	if calls != 3 {
+		t.Errorf("subscriber called after unsubscribing")
+	}
+}
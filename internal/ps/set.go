@@ -0,0 +1,131 @@
+package ps
+
+// Set is a persistent set of strings. Like Map, every operation which
+// changes a set returns a new one, leaving the original intact.
+type Set interface {
+	// IsNil returns true if the set is empty
+	IsNil() bool
+
+	// Add returns a new set which also contains elem.
+	// This operation is O(log N) in the size of the set.
+	Add(elem string) Set
+
+	// Remove returns a new set without elem. If elem isn't present, the
+	// set is returned unchanged.
+	// This operation is O(log N) in the size of the set.
+	Remove(elem string) Set
+
+	// Contains reports whether elem is in the set.
+	// This operation is O(log N) in the size of the set.
+	Contains(elem string) bool
+
+	// Size returns the number of elements in the set.  This takes O(1) time.
+	Size() int
+
+	// ForEach executes a callback on each element in the set, in no
+	// particular order.
+	ForEach(f func(elem string))
+
+	// Union returns a set with the elements of both sets.
+	// This operation is O(M log N), where M is the size of the smaller set.
+	Union(other Set) Set
+
+	// Intersection returns a set with the elements in both sets.
+	// This operation is O(N log N) in the size of the larger set.
+	Intersection(other Set) Set
+
+	// Difference returns a set with the elements of this set which aren't
+	// in other.
+	// This operation is O(N log N) in the size of the larger set.
+	Difference(other Set) Set
+}
+
+// set stores its elements as the keys of a map
+type set struct {
+	m Map
+}
+
+// member is the value stored for every element
+var member = struct{}{}
+
+// An empty set shared by all sets
+var nilSet = &set{nilMap}
+
+// NewSet returns a new set holding the given elements.
+func NewSet(elems ...string) Set {
+	tr := NewMap().AsTransient()
+	for _, elem := range elems {
+		tr.Set(elem, member)
+	}
+	return wrapSet(tr.Persistent())
+}
+
+func wrapSet(m Map) Set {
+	if m.IsNil() {
+		return nilSet
+	}
+	return &set{m}
+}
+
+// asMap returns the elements of other as the keys of a map
+func asMap(other Set) Map {
+	if other == nil {
+		return nilMap
+	}
+	if s, ok := other.(*set); ok {
+		return s.m
+	}
+	tr := NewMap().AsTransient()
+	other.ForEach(func(elem string) { tr.Set(elem, member) })
+	return tr.Persistent()
+}
+
+func (s *set) IsNil() bool {
+	return s.m.IsNil()
+}
+
+func (s *set) Add(elem string) Set {
+	if s.Contains(elem) {
+		return s
+	}
+	return &set{s.m.Set(elem, member)}
+}
+
+func (s *set) Remove(elem string) Set {
+	if !s.Contains(elem) {
+		return s
+	}
+	return wrapSet(s.m.Delete(elem))
+}
+
+func (s *set) Contains(elem string) bool {
+	return s.m.Contains(elem)
+}
+
+func (s *set) Size() int {
+	return s.m.Size()
+}
+
+func (s *set) ForEach(f func(elem string)) {
+	s.m.ForEach(func(key string, _ Any) { f(key) })
+}
+
+func (s *set) Union(other Set) Set {
+	return s.wrap(s.m.Merge(asMap(other)))
+}
+
+func (s *set) Intersection(other Set) Set {
+	return s.wrap(s.m.Intersection(asMap(other)))
+}
+
+func (s *set) Difference(other Set) Set {
+	return s.wrap(s.m.Difference(asMap(other)))
+}
+
+// wrap returns the set with elements m, reusing s if nothing changed
+func (s *set) wrap(m Map) Set {
+	if m == s.m {
+		return s
+	}
+	return wrapSet(m)
+}
@@ -0,0 +1,113 @@
+package ps
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func elems(s Set) []string {
+	var out []string
+	s.ForEach(func(elem string) { out = append(out, elem) })
+	sort.Strings(out)
+	return out
+}
+
+func setEqual(a, b Set) bool {
+	if a.Size() != b.Size() {
+		return false
+	}
+	equal := true
+	a.ForEach(func(elem string) { equal = equal && b.Contains(elem) })
+	return equal
+}
+
+func TestSet(t *testing.T) {
+	empty := NewSet()
+	if !empty.IsNil() || empty.Size() != 0 {
+		t.Errorf("new set should be empty")
+	}
+
+	s := empty.Add("a").Add("b").Add("a")
+	if s.Size() != 2 || !s.Contains("a") || !s.Contains("b") || s.Contains("c") {
+		t.Errorf("wrong elements: %v", elems(s))
+	}
+	if !empty.IsNil() {
+		t.Errorf("Add() modified the receiving set")
+	}
+	if s.Add("a") != s {
+		t.Errorf("adding a present element should return the set")
+	}
+
+	removed := s.Remove("a")
+	if removed.Size() != 1 || removed.Contains("a") || !s.Contains("a") {
+		t.Errorf("wrong Remove() result: %v, original %v", elems(removed), elems(s))
+	}
+	if s.Remove("missing") != s {
+		t.Errorf("removing a missing element should return the set")
+	}
+	if !removed.Remove("b").IsNil() {
+		t.Errorf("removing every element should give an empty set")
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	a := NewSet("1", "2", "3", "4")
+	b := NewSet("3", "4", "5")
+
+	if got := elems(a.Union(b)); len(got) != 5 {
+		t.Errorf("wrong union: %v", got)
+	}
+	if got := elems(a.Intersection(b)); len(got) != 2 || got[0] != "3" || got[1] != "4" {
+		t.Errorf("wrong intersection: %v", got)
+	}
+	if got := elems(a.Difference(b)); len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Errorf("wrong difference: %v", got)
+	}
+	if a.Size() != 4 || b.Size() != 3 {
+		t.Errorf("set operations modified their operands")
+	}
+}
+
+func TestSetLaws(t *testing.T) {
+	var sets []Set
+	for n := 0; n < 5; n++ {
+		s := NewSet()
+		for i := 0; i < 20; i++ {
+			if (i+1)%(n+1) == 0 {
+				s = s.Add(strconv.Itoa(i))
+			}
+		}
+		sets = append(sets, s)
+	}
+	empty := NewSet()
+
+	for _, a := range sets {
+		if !setEqual(a.Union(a), a) || !setEqual(a.Intersection(a), a) {
+			t.Errorf("union and intersection should be idempotent")
+		}
+		if !setEqual(a.Union(empty), a) || !a.Intersection(empty).IsNil() {
+			t.Errorf("the empty set should be the identity of union and absorb intersection")
+		}
+		if !a.Difference(a).IsNil() || !setEqual(a.Difference(empty), a) {
+			t.Errorf("wrong difference with itself or the empty set")
+		}
+
+		for _, b := range sets {
+			if !setEqual(a.Union(b), b.Union(a)) || !setEqual(a.Intersection(b), b.Intersection(a)) {
+				t.Errorf("union and intersection should commute")
+			}
+			if !setEqual(a.Union(a.Intersection(b)), a) {
+				t.Errorf("absorption law doesn't hold")
+			}
+			if !a.Difference(b).Intersection(b).IsNil() {
+				t.Errorf("difference shouldn't share elements with what was removed")
+			}
+			for _, c := range sets {
+				if !setEqual(a.Union(b.Intersection(c)), a.Union(b).Intersection(a.Union(c))) {
+					t.Errorf("union should distribute over intersection")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package ps
+
+// SortedMap is a persistent map which keeps its keys in order, for ordered
+// iteration and range scans. It's a path-copying AVL tree, so every
+// operation touches O(log N) nodes, but unlike Map each comparison calls
+// the ordering function rather than comparing hashes.
+//
+// Like Map, a SortedMap is immutable and safe to copy. The zero value is
+// an empty map ordered lexicographically.
+type SortedMap struct {
+	root *sortedNode
+	less func(a, b string) bool
+}
+
+type sortedNode struct {
+	key         string
+	value       Any
+	left, right *sortedNode
+	height      int // of the subtree rooted here; leaves have height 1
+	size        int // number of keys in the subtree rooted here
+}
+
+// NewSortedMap returns a new, empty map ordered by less, which must be a
+// strict weak ordering. Keys which are neither less than the other are
+// treated as the same key. A nil less orders keys lexicographically.
+func NewSortedMap(less func(a, b string) bool) SortedMap {
+	return SortedMap{less: less}
+}
+
+// Size returns the number of key value pairs in the map.
+// This takes O(1) time.
+func (s SortedMap) Size() int {
+	return s.root.count()
+}
+
+// Set returns a new map in which key and value are associated.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) Set(key string, value Any) SortedMap {
+	s.root = s.root.set(key, value, s.lessFunc())
+	return s
+}
+
+// Delete returns a new map with the association for key, if any, removed.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) Delete(key string) SortedMap {
+	if root, found := s.root.delete(key, s.lessFunc()); found {
+		s.root = root
+	}
+	return s
+}
+
+// Lookup returns the value associated with a key, if any.  If the key
+// exists, the second return value is true; otherwise, false.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) Lookup(key string) (Any, bool) {
+	less := s.lessFunc()
+	for n := s.root; n != nil; {
+		switch {
+		case less(key, n.key):
+			n = n.left
+		case less(n.key, key):
+			n = n.right
+		default:
+			return n.value, true
+		}
+	}
+	return nil, false
+}
+
+// ForEach executes a callback on each key value pair in the map, in key
+// order.
+// This operation is O(N) in the number of keys.
+func (s SortedMap) ForEach(f func(key string, val Any)) {
+	s.root.forEach(f)
+}
+
+// Min returns the smallest key and its value. If the map is empty, the
+// third return value is false.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) Min() (key string, val Any, ok bool) {
+	n := s.root
+	if n == nil {
+		return "", nil, false
+	}
+	for n.left != nil {
+		n = n.left
+	}
+	return n.key, n.value, true
+}
+
+// Max returns the largest key and its value. If the map is empty, the
+// third return value is false.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) Max() (key string, val Any, ok bool) {
+	n := s.root
+	if n == nil {
+		return "", nil, false
+	}
+	for n.right != nil {
+		n = n.right
+	}
+	return n.key, n.value, true
+}
+
+// MinKey returns the smallest key, like Min without the value.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) MinKey() (string, bool) {
+	key, _, ok := s.Min()
+	return key, ok
+}
+
+// MaxKey returns the largest key, like Max without the value.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) MaxKey() (string, bool) {
+	key, _, ok := s.Max()
+	return key, ok
+}
+
+// Range returns the entries with keys from lo, inclusive, up to hi,
+// exclusive, in key order. Ranges are half open so that adjacent ranges
+// such as [a, m) and [m, z) don't overlap.
+// This operation is O(log N + M) for M entries in the range.
+func (s SortedMap) Range(lo, hi string) []Entry {
+	var entries []Entry
+	less := s.lessFunc()
+	var visit func(n *sortedNode)
+	visit = func(n *sortedNode) {
+		if n == nil {
+			return
+		}
+		if less(lo, n.key) {
+			visit(n.left)
+		}
+		if !less(n.key, lo) && less(n.key, hi) {
+			entries = append(entries, Entry{n.key, n.value})
+		}
+		if less(n.key, hi) {
+			visit(n.right)
+		}
+	}
+	visit(s.root)
+	return entries
+}
+
+// DeleteRange returns a new map without the keys from lo, inclusive, up to
+// hi, exclusive, the keys Range would return. The tree is split at lo and
+// hi and the outer parts joined, so only O(log N) nodes are copied however
+// many keys are removed. If no key is in the range, the map is returned
+// unchanged.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) DeleteRange(lo, hi string) SortedMap {
+	less := s.lessFunc()
+	if !less(lo, hi) {
+		return s
+	}
+	below, rest := s.root.split(lo, less)
+	inside, above := rest.split(hi, less)
+	if inside == nil {
+		return s
+	}
+	s.root = joinSorted(below, above)
+	return s
+}
+
+// Rank returns the number of keys less than key, which is the position key
+// has, or would have if it were set, in key order.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) Rank(key string) int {
+	less := s.lessFunc()
+	rank := 0
+	for n := s.root; n != nil; {
+		switch {
+		case less(key, n.key):
+			n = n.left
+		case less(n.key, key):
+			rank += n.left.count() + 1
+			n = n.right
+		default:
+			return rank + n.left.count()
+		}
+	}
+	return rank
+}
+
+// Select returns the key at position i in key order, counting from 0, and
+// its value, so that Rank(key) is i. If i is negative or not less than
+// Size, the third return value is false.
+// This operation is O(log N) in the number of keys.
+func (s SortedMap) Select(i int) (key string, val Any, ok bool) {
+	if i < 0 || i >= s.Size() {
+		return "", nil, false
+	}
+	n := s.root
+	for {
+		switch left := n.left.count(); {
+		case i < left:
+			n = n.left
+		case i > left:
+			i -= left + 1
+			n = n.right
+		default:
+			return n.key, n.value, true
+		}
+	}
+}
+
+// Keys returns a slice with all keys in this map, in key order.
+// This operation is O(N) in the number of keys.
+func (s SortedMap) Keys() []string {
+	keys := make([]string, 0, s.Size())
+	s.ForEach(func(k string, _ Any) { keys = append(keys, k) })
+	return keys
+}
+
+func (s SortedMap) lessFunc() func(a, b string) bool {
+	if s.less == nil {
+		return func(a, b string) bool { return a < b }
+	}
+	return s.less
+}
+
+func (n *sortedNode) count() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func (n *sortedNode) depth() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+// newSortedNode returns a node with the given contents, which must
+// already be balanced
+func newSortedNode(key string, value Any, left, right *sortedNode) *sortedNode {
+	height := left.depth()
+	if right.depth() > height {
+		height = right.depth()
+	}
+	return &sortedNode{key, value, left, right, height + 1, left.count() + right.count() + 1}
+}
+
+// balance returns a node with the given contents, rotating it if the
+// heights of left and right differ by two
+func balance(key string, value Any, left, right *sortedNode) *sortedNode {
+	switch {
+	case left.depth() > right.depth()+1:
+		if left.left.depth() >= left.right.depth() {
+			return newSortedNode(left.key, left.value, left.left,
+				newSortedNode(key, value, left.right, right))
+		}
+		lr := left.right
+		return newSortedNode(lr.key, lr.value,
+			newSortedNode(left.key, left.value, left.left, lr.left),
+			newSortedNode(key, value, lr.right, right))
+	case right.depth() > left.depth()+1:
+		if right.right.depth() >= right.left.depth() {
+			return newSortedNode(right.key, right.value,
+				newSortedNode(key, value, left, right.left), right.right)
+		}
+		rl := right.left
+		return newSortedNode(rl.key, rl.value,
+			newSortedNode(key, value, left, rl.left),
+			newSortedNode(right.key, right.value, rl.right, right.right))
+	}
+	return newSortedNode(key, value, left, right)
+}
+
+func (n *sortedNode) set(key string, value Any, less func(a, b string) bool) *sortedNode {
+	switch {
+	case n == nil:
+		return newSortedNode(key, value, nil, nil)
+	case less(key, n.key):
+		return balance(n.key, n.value, n.left.set(key, value, less), n.right)
+	case less(n.key, key):
+		return balance(n.key, n.value, n.left, n.right.set(key, value, less))
+	}
+	return newSortedNode(n.key, value, n.left, n.right)
+}
+
+func (n *sortedNode) delete(key string, less func(a, b string) bool) (*sortedNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	switch {
+	case less(key, n.key):
+		left, found := n.left.delete(key, less)
+		if !found {
+			return n, false
+		}
+		return balance(n.key, n.value, left, n.right), true
+	case less(n.key, key):
+		right, found := n.right.delete(key, less)
+		if !found {
+			return n, false
+		}
+		return balance(n.key, n.value, n.left, right), true
+	}
+
+	// replace this node with the smallest node on its right
+	if n.left == nil {
+		return n.right, true
+	}
+	if n.right == nil {
+		return n.left, true
+	}
+	successor := n.right
+	for successor.left != nil {
+		successor = successor.left
+	}
+	return balance(successor.key, successor.value, n.left, n.right.deleteMin()), true
+}
+
+func (n *sortedNode) deleteMin() *sortedNode {
+	if n.left == nil {
+		return n.right
+	}
+	return balance(n.key, n.value, n.left.deleteMin(), n.right)
+}
+
+// split returns the trees of n's keys less than key and of the rest
+func (n *sortedNode) split(key string, less func(a, b string) bool) (*sortedNode, *sortedNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if less(n.key, key) {
+		below, rest := n.right.split(key, less)
+		return join(n.left, n.key, n.value, below), rest
+	}
+	below, rest := n.left.split(key, less)
+	return below, join(rest, n.key, n.value, n.right)
+}
+
+// join returns a balanced tree of the keys of left, then key, then those
+// of right. Going down the side of the taller tree to a subtree as tall as
+// the other means only one level at a time can become unbalanced, which
+// balance repairs on the way back up.
+func join(left *sortedNode, key string, value Any, right *sortedNode) *sortedNode {
+	switch {
+	case left.depth() > right.depth()+1:
+		return balance(left.key, left.value, left.left, join(left.right, key, value, right))
+	case right.depth() > left.depth()+1:
+		return balance(right.key, right.value, join(left, key, value, right.left), right.right)
+	}
+	return newSortedNode(key, value, left, right)
+}
+
+// joinSorted returns a balanced tree of the keys of left then those of
+// right
+func joinSorted(left, right *sortedNode) *sortedNode {
+	if right == nil {
+		return left
+	}
+	min := right
+	for min.left != nil {
+		min = min.left
+	}
+	return join(left, min.key, min.value, right.deleteMin())
+}
+
+func (n *sortedNode) forEach(f func(key string, val Any)) {
+	if n == nil {
+		return
+	}
+	n.left.forEach(f)
+	f(n.key, n.value)
+	n.right.forEach(f)
+}
@@ -0,0 +1,253 @@
+package ps
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// checkAVL fails the test if the subtree at n isn't ordered and balanced,
+// or has the wrong heights or sizes
+func checkAVL(t *testing.T, n *sortedNode, less func(a, b string) bool) {
+	t.Helper()
+	if n == nil {
+		return
+	}
+	if n.left != nil && !less(n.left.key, n.key) || n.right != nil && !less(n.key, n.right.key) {
+		t.Fatalf("keys out of order at %s", n.key)
+	}
+	if d := n.left.depth() - n.right.depth(); d < -1 || d > 1 {
+		t.Fatalf("unbalanced at %s: %d", n.key, d)
+	}
+	if n.height != 1+max(n.left.depth(), n.right.depth()) || n.size != 1+n.left.count()+n.right.count() {
+		t.Fatalf("wrong height or size at %s", n.key)
+	}
+	checkAVL(t, n.left, less)
+	checkAVL(t, n.right, less)
+}
+
+func TestSortedMap(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	s := NewSortedMap(nil)
+	expected := map[string]int{}
+	less := s.lessFunc()
+
+	for i := 0; i < 2000; i++ {
+		k := strconv.Itoa(r.Intn(500))
+		if r.Intn(3) == 0 {
+			s = s.Delete(k)
+			delete(expected, k)
+		} else {
+			s = s.Set(k, i)
+			expected[k] = i
+		}
+	}
+	checkAVL(t, s.root, less)
+
+	if s.Size() != len(expected) {
+		t.Errorf("wrong size: %d, expected %d", s.Size(), len(expected))
+	}
+	var keys []string
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if got := s.Keys(); !reflect.DeepEqual(got, keys) {
+		t.Errorf("keys not in order")
+	}
+	for k, v := range expected {
+		if got, ok := s.Lookup(k); !ok || got != v {
+			t.Errorf("wrong value for %s: %v", k, got)
+		}
+	}
+	if _, ok := s.Lookup("missing"); ok {
+		t.Errorf("found a missing key")
+	}
+
+	if k, _, ok := s.Min(); !ok || k != keys[0] {
+		t.Errorf("wrong minimum: %s", k)
+	}
+	if k, _, ok := s.Max(); !ok || k != keys[len(keys)-1] {
+		t.Errorf("wrong maximum: %s", k)
+	}
+	if k, ok := s.MinKey(); !ok || k != keys[0] {
+		t.Errorf("wrong minimum key: %s", k)
+	}
+	if k, ok := s.MaxKey(); !ok || k != keys[len(keys)-1] {
+		t.Errorf("wrong maximum key: %s", k)
+	}
+}
+
+func TestSortedMapImmutable(t *testing.T) {
+	var s SortedMap
+	if _, _, ok := s.Min(); ok || s.Size() != 0 {
+		t.Errorf("zero SortedMap should be empty")
+	}
+
+	one := s.Set("b", 1)
+	two := one.Set("a", 2)
+	changed := two.Set("b", 3)
+	deleted := changed.Delete("a")
+
+	if v, _ := one.Lookup("b"); v != 1 || one.Size() != 1 {
+		t.Errorf("Set() modified the receiving map")
+	}
+	if v, _ := two.Lookup("b"); v != 1 || !reflect.DeepEqual(two.Keys(), []string{"a", "b"}) {
+		t.Errorf("later versions changed an earlier one")
+	}
+	if deleted.Size() != 1 || changed.Size() != 2 {
+		t.Errorf("Delete() modified the receiving map")
+	}
+	if deleted.Delete("missing").root != deleted.root {
+		t.Errorf("deleting a missing key should leave the tree alone")
+	}
+}
+
+func TestSortedMapRange(t *testing.T) {
+	s := NewSortedMap(nil)
+	for _, k := range []string{"a", "c", "e", "g", "i"} {
+		s = s.Set(k, k)
+	}
+
+	keys := func(entries []Entry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Key)
+		}
+		return out
+	}
+	tests := []struct {
+		lo, hi   string
+		expected []string
+	}{
+		{"c", "g", []string{"c", "e"}},      // lo inclusive, hi exclusive
+		{"b", "h", []string{"c", "e", "g"}}, // bounds between keys
+		{"", "z", []string{"a", "c", "e", "g", "i"}},
+		{"e", "e", nil},
+		{"g", "c", nil},
+	}
+	for _, test := range tests {
+		if got := keys(s.Range(test.lo, test.hi)); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Range(%q, %q) = %v, expected %v", test.lo, test.hi, got, test.expected)
+		}
+	}
+}
+
+func TestSortedMapDeleteRange(t *testing.T) {
+	s := NewSortedMap(nil)
+	for _, k := range []string{"a", "c", "e", "g", "i"} {
+		s = s.Set(k, k)
+	}
+
+	tests := []struct {
+		lo, hi   string
+		expected []string
+	}{
+		{"c", "g", []string{"a", "g", "i"}}, // lo inclusive, hi exclusive
+		{"b", "h", []string{"a", "i"}},      // bounds between keys
+		{"", "z", []string{}},
+		{"a", "b", []string{"c", "e", "g", "i"}},
+		{"e", "e", []string{"a", "c", "e", "g", "i"}},
+		{"g", "c", []string{"a", "c", "e", "g", "i"}},
+	}
+	for _, test := range tests {
+		got := s.DeleteRange(test.lo, test.hi)
+		checkAVL(t, got.root, got.lessFunc())
+		if keys := got.Keys(); !reflect.DeepEqual(keys, test.expected) || got.Size() != len(keys) {
+			t.Errorf("DeleteRange(%q, %q) left %v (size %d), expected %v",
+				test.lo, test.hi, keys, got.Size(), test.expected)
+		}
+	}
+	if s.DeleteRange("j", "z").root != s.root {
+		t.Errorf("deleting an empty range should leave the tree alone")
+	}
+	if s.Size() != 5 {
+		t.Errorf("DeleteRange() modified the receiving map")
+	}
+
+	// compare against deleting one key at a time, on trees of every shape
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		s := NewSortedMap(nil)
+		for j := r.Intn(100); j > 0; j-- {
+			s = s.Set(strconv.Itoa(r.Intn(1000)), j)
+		}
+		lo, hi := strconv.Itoa(r.Intn(1000)), strconv.Itoa(r.Intn(1000))
+		got := s.DeleteRange(lo, hi)
+		checkAVL(t, got.root, got.lessFunc())
+
+		expected := s
+		for _, e := range s.Range(lo, hi) {
+			expected = expected.Delete(e.Key)
+		}
+		if !reflect.DeepEqual(got.Keys(), expected.Keys()) {
+			t.Fatalf("DeleteRange(%q, %q) left %v, expected %v", lo, hi, got.Keys(), expected.Keys())
+		}
+	}
+}
+
+func TestSortedMapRankSelect(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	s := NewSortedMap(nil)
+	for i := 0; i < 500; i++ {
+		s = s.Set(strconv.Itoa(r.Intn(1000)), i)
+	}
+
+	keys := s.Keys()
+	for i, expected := range keys {
+		key, val, ok := s.Select(i)
+		if !ok || key != expected {
+			t.Fatalf("Select(%d) = %q, %v; expected %q", i, key, ok, expected)
+		}
+		if v, _ := s.Lookup(key); v != val {
+			t.Errorf("Select(%d) gave value %v, expected %v", i, val, v)
+		}
+		if rank := s.Rank(key); rank != i {
+			t.Errorf("Rank(Select(%d)) = %d", i, rank)
+		}
+	}
+
+	// keys which aren't present rank where they would go
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(r.Intn(2000)) + "x"
+		if rank, expected := s.Rank(key), sort.SearchStrings(keys, key); rank != expected {
+			t.Errorf("Rank(%q) = %d, expected %d", key, rank, expected)
+		}
+	}
+
+	for _, i := range []int{-1, s.Size()} {
+		if _, _, ok := s.Select(i); ok {
+			t.Errorf("Select(%d) should fail", i)
+		}
+	}
+	if _, _, ok := NewSortedMap(nil).Select(0); ok || NewSortedMap(nil).Rank("a") != 0 {
+		t.Errorf("empty map should have no ranks")
+	}
+}
+
+func TestSortedMapComparator(t *testing.T) {
+	byNumber := func(a, b string) bool {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x < y
+	}
+	s := NewSortedMap(byNumber)
+	for _, k := range []string{"10", "9", "100", "1"} {
+		s = s.Set(k, k)
+	}
+	if keys := s.Keys(); !reflect.DeepEqual(keys, []string{"1", "9", "10", "100"}) {
+		t.Errorf("keys not in comparator order: %v", keys)
+	}
+	if got := s.Range("5", "50"); len(got) != 2 || got[0].Key != "9" || got[1].Key != "10" {
+		t.Errorf("wrong range with a comparator: %v", got)
+	}
+
+	// keys the comparator can't tell apart are the same key
+	s = s.Set("010", "zero ten")
+	if v, _ := s.Lookup("10"); s.Size() != 4 || v != "zero ten" {
+		t.Errorf("equivalent key should replace the value: %v", v)
+	}
+	checkAVL(t, s.root, byNumber)
+}
@@ -0,0 +1,55 @@
+package ps
+
+// Stack is a persistent last in, first out stack of possibly heterogenous
+// values. Pushing and popping return new stacks, so every earlier version
+// stays valid.
+type Stack interface {
+	// Push returns a new stack with v on top.  This takes O(1) time.
+	Push(v Any) Stack
+
+	// Pop returns the top value and a stack without it. If the stack is
+	// empty, the third return value is false and the stack is returned
+	// unchanged.  This takes O(1) time.
+	Pop() (Any, Stack, bool)
+
+	// Peek returns the top value, if any.  This takes O(1) time.
+	Peek() (Any, bool)
+
+	// Size returns the number of values on the stack.  This takes O(1) time.
+	Size() int
+}
+
+// stack keeps its top value at the head of a list
+type stack struct {
+	l List
+}
+
+// An empty stack shared by all stacks
+var nilStack = &stack{nilList}
+
+// NewStack returns a new, empty stack.
+func NewStack() Stack {
+	return nilStack
+}
+
+func (s *stack) Push(v Any) Stack {
+	return &stack{s.l.Cons(v)}
+}
+
+func (s *stack) Pop() (Any, Stack, bool) {
+	if s.l.IsNil() {
+		return nil, s, false
+	}
+	return s.l.Head(), &stack{s.l.Tail()}, true
+}
+
+func (s *stack) Peek() (Any, bool) {
+	if s.l.IsNil() {
+		return nil, false
+	}
+	return s.l.Head(), true
+}
+
+func (s *stack) Size() int {
+	return s.l.Size()
+}
@@ -0,0 +1,50 @@
+package ps
+
+import "testing"
+
+func TestStack(t *testing.T) {
+	empty := NewStack()
+	if _, ok := empty.Peek(); ok || empty.Size() != 0 {
+		t.Errorf("new stack should be empty")
+	}
+	if _, s, ok := empty.Pop(); ok || s != empty {
+		t.Errorf("popping an empty stack should fail")
+	}
+
+	s := empty.Push(1).Push(2).Push(3)
+	if top, ok := s.Peek(); !ok || top != 3 || s.Size() != 3 {
+		t.Errorf("wrong top %v or size %d", top, s.Size())
+	}
+	for want := 3; want > 0; want-- {
+		var v Any
+		var ok bool
+		v, s, ok = s.Pop()
+		if !ok || v != want {
+			t.Errorf("popped %v, expected %d", v, want)
+		}
+	}
+	if s.Size() != 0 {
+		t.Errorf("stack should be empty after popping everything")
+	}
+}
+
+func TestStackImmutable(t *testing.T) {
+	base := NewStack().Push("a").Push("b")
+	branch := base.Push("c")
+
+	_, popped, _ := branch.Pop()
+	_, popped, _ = popped.Pop()
+	if popped.Size() != 1 {
+		t.Errorf("wrong size after popping: %d", popped.Size())
+	}
+
+	if top, _ := branch.Peek(); top != "c" || branch.Size() != 3 {
+		t.Errorf("popping modified the derived stack")
+	}
+	if top, _ := base.Peek(); top != "b" || base.Size() != 2 {
+		t.Errorf("popping modified the original stack")
+	}
+	if other := base.Push("d"); other.Size() != 3 || branch.Size() != 3 {
+		t.Errorf("pushing onto a shared stack affected another version")
+	}
+}
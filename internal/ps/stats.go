@@ -0,0 +1,244 @@
+package ps
+
+import "unsafe"
+
+// MapStats describes the memory used by a map, as returned by
+// Map.MemStats.
+type MapStats struct {
+	// Nodes is the number of tree nodes.
+	Nodes int
+
+	// Entries is the number of stored entries, including tombstones left
+	// by TombstoneDelete which haven't been compacted yet.
+	Entries int
+
+	// Bytes estimates the memory used: the size of every node and
+	// collision bucket plus the bytes of every key. Values, and anything
+	// else they point to, aren't included.
+	Bytes int
+}
+
+func (t *tree) MemStats() MapStats {
+	var stats MapStats
+	if t.IsNil() {
+		return stats
+	}
+	t.eachNode(func(n *tree) {
+		stats.Nodes++
+		stats.Bytes += int(unsafe.Sizeof(*n)) + cap(n.overflow)*int(unsafe.Sizeof(Entry{}))
+		n.eachNodeEntry(func(key string, _ Any) {
+			stats.Entries++
+			stats.Bytes += len(key)
+		})
+	})
+	return stats
+}
+
+// KeyStrings returns the number of distinct key strings held by the given
+// maps, counting equal keys which share their bytes in memory once, and
+// the bytes those strings take. Versions of a map made with
+// NewMapWithInterning hold one string per key however many of them set it.
+// This operation is O(N) in the number of keys of all the maps.
+func KeyStrings(maps ...Map) (count, bytes int) {
+	seen := make(map[keyString]struct{})
+	for _, m := range maps {
+		if m == nil {
+			continue
+		}
+		m.ForEach(func(key string, _ Any) {
+			s := keyString{unsafe.StringData(key), len(key)}
+			if _, ok := seen[s]; !ok {
+				seen[s] = struct{}{}
+				bytes += len(key)
+			}
+		})
+	}
+	return len(seen), bytes
+}
+
+// keyString identifies the memory holding a string
+type keyString struct {
+	data *byte
+	len  int
+}
+
+// SharingRatio returns the fraction of child's tree nodes which are shared
+// (i.e. pointer-identical) with parent's tree. A map derived from parent by a
+// single Set shares all but O(log N) of its nodes, so the ratio is close to
+// 1; a map built from scratch shares nothing and the ratio is 0.
+//
+// An empty child has no nodes and yields 0.
+func SharingRatio(parent, child Map) float64 {
+	c, ok := child.(*tree)
+	if !ok || c.IsNil() {
+		return 0
+	}
+	p, ok := parent.(*tree)
+	if !ok {
+		return 0
+	}
+	return float64(sharedNodes(p, c)) / float64(c.nodeCount())
+}
+
+// SharedNodeCount returns the number of tree nodes a and b share, that is,
+// which are the same node in memory rather than equal copies. It's meant
+// for tests of structural sharing: a map derived from m by a single Set
+// shares all but O(log N) of m's nodes.
+// This operation is O(N) in the number of nodes of a and b.
+func SharedNodeCount(a, b Map) int {
+	at, ok := a.(*tree)
+	bt, ok2 := b.(*tree)
+	if !ok || !ok2 || at.IsNil() {
+		return 0
+	}
+	return sharedNodes(at, bt)
+}
+
+// VersionDelta compares two versions of a map in a single walk over both
+// trees. It returns the number of entries added, removed and changed (as
+// defined by Map.Diff) from parent to child, along with the number of
+// child nodes shared with parent. Subtrees which are shared are skipped, so
+// comparing a version with its close descendant is cheap. A nil Map is
+// treated as empty, and maps which aren't built by this package are
+// compared with Diff instead, sharing no nodes.
+func VersionDelta(parent, child Map) (added, removed, changed, sharedNodes int) {
+	if parent == nil {
+		parent = nilMap
+	}
+	if child == nil {
+		child = nilMap
+	}
+	p, ok := parent.(*tree)
+	c, ok2 := child.(*tree)
+	if !ok || !ok2 {
+		a, r, ch := child.Diff(parent)
+		return a.Size(), r.Size(), ch.Size(), 0
+	}
+
+	var walk func(pn, cn *tree)
+	walk = func(pn, cn *tree) {
+		// a nil node is an empty subtree, like nilMap
+		if pn == nil {
+			pn = nilMap
+		}
+		if cn == nil {
+			cn = nilMap
+		}
+		if pn == cn {
+			if !cn.IsNil() {
+				sharedNodes += cn.nodeCount()
+			}
+			return
+		}
+
+		if !cn.IsNil() {
+			cn.eachNodeEntry(func(key string, val Any) {
+				val, ok := resolve(val)
+				if !ok {
+					return
+				}
+				prev, found := p.Lookup(key)
+				switch {
+				case !found:
+					added++
+				case !valuesEqual(prev, val):
+					changed++
+				}
+			})
+		}
+		if !pn.IsNil() {
+			pn.eachNodeEntry(func(key string, val Any) {
+				if _, ok := resolve(val); !ok {
+					return
+				}
+				if _, found := c.Lookup(key); !found {
+					removed++
+				}
+			})
+		}
+
+		for i := range cn.children {
+			walk(pn.children[i], cn.children[i])
+		}
+	}
+	walk(p, c)
+	return added, removed, changed, sharedNodes
+}
+
+// sharedNodes returns the number of nodes in b which also appear in a.
+// Identical subtrees are counted without being walked.
+func sharedNodes(a, b *tree) int {
+	seen := make(map[*tree]struct{}, a.count)
+	a.eachNode(func(n *tree) { seen[n] = struct{}{} })
+
+	var count func(*tree) int
+	count = func(n *tree) int {
+		if _, ok := seen[n]; ok {
+			return n.nodeCount()
+		}
+		shared := 0
+		for _, c := range n.children {
+			if c != nilMap {
+				shared += count(c)
+			}
+		}
+		return shared
+	}
+
+	if b.IsNil() {
+		return 0
+	}
+	return count(b)
+}
+
+func (t *tree) Depth() int {
+	_, max, _ := t.BalanceStats()
+	return max
+}
+
+func (t *tree) BalanceStats() (min, max, avg int) {
+	if t.IsNil() {
+		return 0, 0, 0
+	}
+
+	leaves, total := 0, 0
+	var walk func(n *tree, depth int)
+	walk = func(n *tree, depth int) {
+		if n.isLeaf() {
+			if leaves == 0 || depth < min {
+				min = depth
+			}
+			if depth > max {
+				max = depth
+			}
+			leaves++
+			total += depth
+			return
+		}
+		for _, c := range n.children {
+			if c != nilMap {
+				walk(c, depth+1)
+			}
+		}
+	}
+	walk(t, 1)
+	return min, max, total / leaves
+}
+
+func (t *tree) CollisionStats() (nodesWithMultipleKeys, maxKeysPerNode int) {
+	t.eachNode(func(n *tree) {
+		if keys := 1 + len(n.overflow); keys > 1 {
+			nodesWithMultipleKeys++
+			if keys > maxKeysPerNode {
+				maxKeysPerNode = keys
+			}
+		}
+	})
+	return nodesWithMultipleKeys, maxKeysPerNode
+}
+
+// nodeCount returns the number of nodes in the tree
+func (t *tree) nodeCount() int {
+	leaves, internal := t.countNodes()
+	return leaves + internal
+}
@@ -0,0 +1,290 @@
+package ps
+
+import (
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+func TestSharingRatio(t *testing.T) {
+	parent := NewMap()
+	for i := 0; i < 1000; i++ {
+		parent = parent.Set(strconv.Itoa(i), i)
+	}
+
+	child := parent.Set("new", true)
+	if r := SharingRatio(parent, child); r < 0.95 || r >= 1 {
+		t.Errorf("a single Set should share most nodes, got %f", r)
+	}
+
+	if r := SharingRatio(parent, parent); r != 1 {
+		t.Errorf("a map should share every node with itself, got %f", r)
+	}
+
+	rebuilt := NewMap()
+	parent.ForEach(func(k string, v Any) { rebuilt = rebuilt.Set(k, v) })
+	if r := SharingRatio(parent, rebuilt); r != 0 {
+		t.Errorf("a rebuilt map shouldn't share nodes, got %f", r)
+	}
+
+	if r := SharingRatio(parent, NewMap()); r != 0 {
+		t.Errorf("an empty child should have a ratio of 0, got %f", r)
+	}
+}
+
+func TestSharedNodeCount(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 10000; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	nodes := m.MemStats().Nodes
+
+	// the Set copies the path down to the new key's place, which is no
+	// deeper than the tree
+	child := m.Set("new", true)
+	shared := SharedNodeCount(m, child)
+	if copied := nodes - shared; copied < 1 || copied > m.Depth() {
+		t.Errorf("expected at most %d copied nodes, got %d", m.Depth(), copied)
+	}
+	if SharedNodeCount(child, m) != shared {
+		t.Errorf("SharedNodeCount() should be symmetric")
+	}
+
+	if n := SharedNodeCount(m, m); n != nodes {
+		t.Errorf("a map should share all %d nodes with itself, got %d", nodes, n)
+	}
+	if n := SharedNodeCount(m, numbers(10000)); n != 0 {
+		t.Errorf("separately built maps shouldn't share nodes, got %d", n)
+	}
+	if SharedNodeCount(NewMap(), m) != 0 || SharedNodeCount(m, NewMap()) != 0 {
+		t.Errorf("the empty map has no nodes to share")
+	}
+}
+
+func TestVersionDelta(t *testing.T) {
+	parent := NewMap()
+	for i := 0; i < 1000; i++ {
+		parent = parent.Set(strconv.Itoa(i), i)
+	}
+	child := parent.
+		Set("new", true).
+		Set("10", "changed").
+		Set("20", 20). // same value
+		Delete("30").
+		Delete("missing")
+
+	added, removed, changed, shared := VersionDelta(parent, child)
+	if added != 1 || removed != 1 || changed != 1 {
+		t.Errorf("expected 1 added, removed and changed, got %d, %d, %d", added, removed, changed)
+	}
+	if expected := sharedNodes(parent.(*tree), child.(*tree)); shared != expected {
+		t.Errorf("expected %d shared nodes, got %d", expected, shared)
+	}
+	if shared < 900 {
+		t.Errorf("too few shared nodes: %d", shared)
+	}
+
+	if a, r, c, s := VersionDelta(parent, parent); a+r+c != 0 || s != 1000 {
+		t.Errorf("a version compared with itself: %d, %d, %d, %d", a, r, c, s)
+	}
+	if a, r, c, s := VersionDelta(NewMap(), parent); a != 1000 || r+c+s != 0 {
+		t.Errorf("a version compared with the empty map: %d, %d, %d, %d", a, r, c, s)
+	}
+}
+
+// wrappedMap is a Map which isn't a *tree
+type wrappedMap struct{ Map }
+
+func TestVersionDeltaOtherMaps(t *testing.T) {
+	parent := NewMap().Set("a", 1).Set("b", 2).Set("c", 3)
+	child := parent.Set("a", 10).Delete("b").Set("d", 4)
+
+	for _, test := range []struct{ parent, child Map }{
+		{wrappedMap{parent}, child},
+		{parent, wrappedMap{child}},
+		{wrappedMap{parent}, wrappedMap{child}},
+	} {
+		added, removed, changed, shared := VersionDelta(test.parent, test.child)
+		if added != 1 || removed != 1 || changed != 1 || shared != 0 {
+			t.Errorf("%T to %T: expected 1 added, removed and changed, got %d, %d, %d, %d",
+				test.parent, test.child, added, removed, changed, shared)
+		}
+	}
+
+	if a, r, c, s := VersionDelta(nil, wrappedMap{child}); a != 3 || r+c+s != 0 {
+		t.Errorf("nil compared with a wrapped map: %d, %d, %d, %d", a, r, c, s)
+	}
+	if a, r, c, s := VersionDelta(wrappedMap{parent}, nil); r != 3 || a+c+s != 0 {
+		t.Errorf("a wrapped map compared with nil: %d, %d, %d, %d", a, r, c, s)
+	}
+}
+
+func TestMemStats(t *testing.T) {
+	// a root with a colliding key in its bucket and two children
+	hashes := map[string]uint64{"root": 0, "collides": 0, "one": 1, "two": 2}
+	m := setHashed(nilMap, hashes, "root", "collides", "one", "two")
+
+	stats := m.MemStats()
+	if stats.Nodes != 3 || stats.Entries != 4 {
+		t.Errorf("wrong shape: %+v", stats)
+	}
+	nodeBytes := 3*int(unsafe.Sizeof(tree{})) + len("rootcollidesonetwo")
+	if stats.Bytes < nodeBytes || stats.Bytes > nodeBytes+4*int(unsafe.Sizeof(Entry{})) {
+		t.Errorf("byte estimate %d too far from %d", stats.Bytes, nodeBytes)
+	}
+
+	if stats := m.TombstoneDelete("one").MemStats(); stats.Entries != 4 {
+		t.Errorf("tombstones should still be counted: %+v", stats)
+	}
+	if stats := NewMap().MemStats(); stats != (MapStats{}) {
+		t.Errorf("empty map should use nothing: %+v", stats)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	if NewMap().Depth() != 0 {
+		t.Errorf("empty map should have no depth")
+	}
+	if d := NewMap().Set("a", 1).Depth(); d != 1 {
+		t.Errorf("a single node should have depth 1, got %d", d)
+	}
+
+	balanced := NewMap()
+	// this hash leaves the low 30 bits, which pick the first ten levels'
+	// children, empty, so every key goes down the same path at first
+	skewed := NewMapWithHash(func(key string) uint64 {
+		i, _ := strconv.Atoi(key)
+		return uint64(i) << 30
+	})
+	for i := 0; i < 1000; i++ {
+		balanced = balanced.Set(strconv.Itoa(i), i)
+		skewed = skewed.Set(strconv.Itoa(i), i)
+	}
+
+	if d := balanced.Depth(); d < 4 || d > 8 {
+		t.Errorf("unexpected depth for a well distributed map: %d", d)
+	}
+	if skewed.Depth() <= balanced.Depth()+5 {
+		t.Errorf("skewed map should be much deeper: %d vs %d", skewed.Depth(), balanced.Depth())
+	}
+
+	min, max, avg := balanced.BalanceStats()
+	if min < 1 || min > avg || avg > max || max != balanced.Depth() {
+		t.Errorf("inconsistent balance stats: %d, %d, %d", min, max, avg)
+	}
+	if _, _, skewedAvg := skewed.BalanceStats(); skewedAvg <= avg {
+		t.Errorf("skewed map should have deeper leaves on average: %d vs %d", skewedAvg, avg)
+	}
+}
+
+func TestCollisionStats(t *testing.T) {
+	plain := NewMap()
+	for i := 0; i < 100; i++ {
+		plain = plain.Set(strconv.Itoa(i), i)
+	}
+	if nodes, max := plain.CollisionStats(); nodes != 0 || max != 0 {
+		t.Errorf("FNV-1a shouldn't collide on these keys: %d, %d", nodes, max)
+	}
+
+	// keys collide in groups sharing the same last digit
+	lastDigit := func(key string) uint64 { return uint64(key[len(key)-1]) }
+	m := NewMapWithHash(lastDigit)
+	for i := 0; i < 25; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	// digits 0-4 have three keys each (e.g. 0, 10, 20), 5-9 have two
+	if nodes, max := m.CollisionStats(); nodes != 10 || max != 3 {
+		t.Errorf("wrong collision stats: %d, %d", nodes, max)
+	}
+
+	if nodes, max := NewMap().CollisionStats(); nodes != 0 || max != 0 {
+		t.Errorf("empty map has no collisions: %d, %d", nodes, max)
+	}
+}
+
+func TestKeyStringsInterning(t *testing.T) {
+	// each version sets the same keys, built afresh every time, starting
+	// from empty, or deleting them first, as undoing and redoing might
+	build := func(empty Map) []Map {
+		var versions []Map
+		for v := 0; v < 100; v++ {
+			m := empty
+			if v%2 == 1 {
+				m = versions[v-1]
+			}
+			for k := 0; k < 10; k++ {
+				m = m.Delete("key"+strconv.Itoa(k)).Set("key"+strconv.Itoa(k), v)
+			}
+			versions = append(versions, m)
+		}
+		return versions
+	}
+
+	count, bytes := KeyStrings(build(NewMap())...)
+	if count != 1000 || bytes != 4000 {
+		t.Errorf("without interning: %d keys, %d bytes; want 1000, 4000", count, bytes)
+	}
+
+	interned := build(NewMapWithInterning())
+	count, bytes = KeyStrings(interned...)
+	if count != 10 || bytes != 40 {
+		t.Errorf("with interning: %d keys, %d bytes; want 10, 40", count, bytes)
+	}
+	last := interned[len(interned)-1]
+	if v, ok := last.Lookup("key3"); !ok || v != 99 {
+		t.Errorf("Lookup(key3) = %v, %v; want 99, true", v, ok)
+	}
+
+	// a Transient interns keys too
+	tr := last.AsTransient()
+	tr.Delete("key3")
+	tr.Set("key"+strconv.Itoa(3), -1)
+	tr.Set("new", 0)
+	if count, _ := KeyStrings(append(interned, tr.Persistent())...); count != 11 {
+		t.Errorf("Transient didn't intern: %d keys", count)
+	}
+}
+
+func TestTrimToSize(t *testing.T) {
+	big := NewMapWithBloom()
+	for i := 0; i < 10000; i++ {
+		big = big.Set(strconv.Itoa(i), i)
+	}
+	shrunk := big
+	for i := 0; i < 10000; i++ {
+		switch {
+		case i%100 == 1:
+			shrunk = shrunk.TombstoneDelete(strconv.Itoa(i))
+		case i%100 != 0:
+			shrunk = shrunk.Delete(strconv.Itoa(i))
+		}
+	}
+
+	trimmed := shrunk.TrimToSize()
+	if !trimmed.Equal(shrunk) || trimmed.Size() != 100 {
+		t.Fatalf("trimming changed the contents")
+	}
+	before, after := shrunk.MemStats(), trimmed.MemStats()
+	if before.Entries != 200 || after.Entries != 100 {
+		t.Errorf("expected the tombstones to go: %d entries before, %d after", before.Entries, after.Entries)
+	}
+	// one node per key is as few as the keys' hashes allow
+	if after.Nodes != 100 || after.Bytes >= before.Bytes {
+		t.Errorf("expected 100 nodes in fewer bytes, got %+v from %+v", after, before)
+	}
+	if shared := SharedNodeCount(shrunk, trimmed); shared != 0 {
+		t.Errorf("trimmed map shares %d nodes with the original", shared)
+	}
+
+	// the Bloom filter sized for the big map is replaced by a small one
+	if f, old := trimmed.(*tree).filter, shrunk.(*tree).filter; f == nil || len(f.blocks) >= len(old.blocks) {
+		t.Errorf("expected a smaller Bloom filter")
+	}
+	if _, ok := trimmed.Lookup("500"); !ok {
+		t.Errorf("lost a key")
+	}
+
+	if !NewMap().TrimToSize().IsNil() {
+		t.Errorf("trimming an empty map should give an empty map")
+	}
+}
@@ -0,0 +1,229 @@
+package ps
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+func (t *tree) DecodeStruct(out interface{}) error {
+	ptr := reflect.ValueOf(out)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Struct {
+		return errors.New("DecodeStruct needs a non-nil pointer to a struct")
+	}
+	return decodeStruct(t, ptr.Elem())
+}
+
+func (t *tree) Scan(dest interface{}) error {
+	return t.DecodeStruct(dest)
+}
+
+// decodeStruct assigns the entries of m to the fields of structVal, as
+// described for DecodeStruct
+func decodeStruct(m Map, structVal reflect.Value) error {
+	structType := structVal.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		key, required, ok := fieldKey(field)
+		if !ok {
+			continue
+		}
+
+		val, found := m.Lookup(key)
+		if !found {
+			if required {
+				return fmt.Errorf("missing required key %q for field %s", key, field.Name)
+			}
+			continue
+		}
+
+		if err := assign(structVal.Field(i), val); err != nil {
+			return fmt.Errorf("key %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// FromStruct returns a new map holding the exported fields of the given
+// struct (or pointer to struct), keyed as described for DecodeStruct. Fields
+// tagged "-" are omitted and nested structs become nested maps, except for
+// structs such as time.Time which implement encoding.TextMarshaler or have
+// no exported fields: those would lose their data as maps, so they're
+// stored as they are.
+func FromStruct(in interface{}) (Map, error) {
+	v := reflect.ValueOf(in)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("FromStruct needs a struct, got %T", in)
+	}
+	return structToMap(v), nil
+}
+
+func structToMap(v reflect.Value) Map {
+	m := NewMap()
+	for i := 0; i < v.NumField(); i++ {
+		key, _, ok := fieldKey(v.Type().Field(i))
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct && !isOpaqueStruct(field.Type()) {
+			m = m.Set(key, structToMap(field))
+		} else {
+			m = m.Set(key, field.Interface())
+		}
+	}
+	return m
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// isOpaqueStruct reports whether FromStruct stores a struct of type t as a
+// value rather than as a nested map
+func isOpaqueStruct(t reflect.Type) bool {
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if _, _, ok := fieldKey(t.Field(i)); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldKey returns the map key for a struct field and whether the field is
+// required. ok is false for unexported fields and fields tagged "-".
+func fieldKey(field reflect.StructField) (key string, required, ok bool) {
+	if field.PkgPath != "" {
+		return "", false, false
+	}
+
+	tag, tagged := field.Tag.Lookup("ps")
+	if !tagged {
+		tag = field.Tag.Get("json")
+	}
+	if tag == "-" {
+		return "", false, false
+	}
+
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == "required" {
+			required = true
+		}
+	}
+	return name, required, true
+}
+
+// assign sets field to val, converting between numeric types when no
+// information is lost and decoding a nested Map into a struct or a pointer
+// to one
+func assign(field reflect.Value, val Any) error {
+	if val == nil {
+		switch field.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		return fmt.Errorf("cannot assign nil to %s", field.Type())
+	}
+
+	v := reflect.ValueOf(val)
+	if v.Type().AssignableTo(field.Type()) {
+		field.Set(v)
+		return nil
+	}
+
+	if nested, ok := val.(Map); ok {
+		switch {
+		case field.Kind() == reflect.Struct:
+			return decodeStruct(nested, field)
+		case field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct:
+			if field.IsNil() {
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+			return decodeStruct(nested, field.Elem())
+		}
+	}
+
+	if isNumeric(v.Kind()) && isNumeric(field.Kind()) {
+		if !fitsNumeric(v, field.Type()) {
+			return fmt.Errorf("%v overflows or truncates in %s", val, field.Type())
+		}
+		field.Set(v.Convert(field.Type()))
+		return nil
+	}
+
+	return fmt.Errorf("cannot assign %T to %s", val, field.Type())
+}
+
+// fitsNumeric reports whether the number v can be converted to the numeric
+// type t without changing its value. Converting a number which doesn't fit
+// wraps around or gives an arbitrary value rather than failing, so a
+// conversion can't be checked by converting back: the sign and range of t
+// are checked instead.
+func fitsNumeric(v reflect.Value, t reflect.Type) bool {
+	dest := reflect.Zero(t)
+	switch {
+	case isSigned(v.Kind()) && isSigned(t.Kind()):
+		return !dest.OverflowInt(v.Int())
+	case isSigned(v.Kind()) && isUnsigned(t.Kind()):
+		return v.Int() >= 0 && !dest.OverflowUint(uint64(v.Int()))
+	case isUnsigned(v.Kind()) && isSigned(t.Kind()):
+		return v.Uint() <= math.MaxInt64 && !dest.OverflowInt(int64(v.Uint()))
+	case isUnsigned(v.Kind()) && isUnsigned(t.Kind()):
+		return !dest.OverflowUint(v.Uint())
+	case isFloat(v.Kind()) && isSigned(t.Kind()):
+		f := v.Float()
+		return floatIsInt(f) && !dest.OverflowInt(int64(f))
+	case isFloat(v.Kind()) && isUnsigned(t.Kind()):
+		f := v.Float()
+		return floatIsUint(f) && !dest.OverflowUint(uint64(f))
+	case isSigned(v.Kind()):
+		f := v.Convert(t).Float()
+		return floatIsInt(f) && int64(f) == v.Int()
+	case isUnsigned(v.Kind()):
+		f := v.Convert(t).Float()
+		return floatIsUint(f) && uint64(f) == v.Uint()
+	}
+	return v.Convert(t).Convert(v.Type()).Equal(v)
+}
+
+// floatIsInt reports whether f is a whole number in the range of int64.
+// Converting a float outside an integer type's range gives an arbitrary
+// value, so the range is checked before any conversion.
+func floatIsInt(f float64) bool {
+	return f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+}
+
+// floatIsUint reports whether f is a whole number in the range of uint64
+func floatIsUint(f float64) bool {
+	return f == math.Trunc(f) && f >= 0 && f < math.MaxUint64
+}
+
+func isSigned(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUnsigned(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uint64
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func isNumeric(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Uint64) || k == reflect.Float32 || k == reflect.Float64
+}
@@ -0,0 +1,265 @@
+package ps
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+type decodeConfig struct {
+	Host    string `json:"host"`
+	Port    int    `ps:"port,required"`
+	Debug   bool
+	Ignored string `ps:"-"`
+	hidden  string
+}
+
+func TestDecodeStruct(t *testing.T) {
+	m := NewMap().
+		Set("host", "localhost").
+		Set("port", 8080.0). // as decoded from JSON
+		Set("Debug", true).
+		Set("Ignored", "x").
+		Set("hidden", "x")
+
+	var c decodeConfig
+	if err := m.DecodeStruct(&c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := decodeConfig{Host: "localhost", Port: 8080, Debug: true}
+	if c != expected {
+		t.Errorf("expected %+v, got %+v", expected, c)
+	}
+}
+
+func TestDecodeStructMissingField(t *testing.T) {
+	var c decodeConfig
+	err := NewMap().Set("host", "localhost").DecodeStruct(&c)
+	if err == nil || !strings.Contains(err.Error(), `"port"`) {
+		t.Errorf("expected an error about the missing port, got %v", err)
+	}
+
+	// optional fields are left alone
+	c = decodeConfig{Host: "default"}
+	if err := NewMap().Set("port", 1).DecodeStruct(&c); err != nil || c.Host != "default" {
+		t.Errorf("optional field was changed: %+v, %v", c, err)
+	}
+}
+
+func TestDecodeStructTypeMismatch(t *testing.T) {
+	var c decodeConfig
+	for _, m := range []Map{
+		NewMap().Set("port", "8080"),
+		NewMap().Set("port", 80.5),
+		NewMap().Set("port", 1).Set("Debug", 1),
+	} {
+		if err := m.DecodeStruct(&c); err == nil {
+			t.Errorf("expected an error decoding %s", m)
+		}
+	}
+
+	if err := NewMap().DecodeStruct(c); err == nil {
+		t.Errorf("expected an error decoding into a non-pointer")
+	}
+}
+
+type decodeNumbers struct {
+	Uint   uint
+	Int64  int64
+	Int8   int8
+	Uint8  uint8
+	Uint64 uint64
+	Float  float64
+}
+
+func TestDecodeStructNumericRange(t *testing.T) {
+	tests := []struct {
+		key string
+		val Any
+		ok  bool
+	}{
+		{"Uint", -1, false},
+		{"Uint", int8(-128), false},
+		{"Uint", 7, true},
+		{"Int64", uint64(1) << 63, false},
+		{"Int64", uint64(math.MaxInt64), true},
+		{"Int64", -1, true},
+		{"Int8", uint64(5), true},
+		{"Int8", 128, false},
+		{"Int8", -129, false},
+		{"Uint8", uint(256), false},
+		{"Uint8", int16(-1), false},
+		{"Uint", -1.0, false},
+		{"Int64", 1e19, false},
+		{"Int64", -9.3e18, false},
+		{"Int64", math.NaN(), false},
+		{"Uint64", float64(1 << 63), true},
+		{"Uint64", 2e19, false},
+		{"Float", int64(1)<<53 + 1, false},
+		{"Float", uint64(math.MaxUint64), false},
+		{"Float", int64(1) << 53, true},
+	}
+	for _, test := range tests {
+		var n decodeNumbers
+		err := NewMap().Set(test.key, test.val).DecodeStruct(&n)
+		if test.ok && err != nil {
+			t.Errorf("%T %v into %s: unexpected error: %v", test.val, test.val, test.key, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%T %v into %s: expected an error, got %+v", test.val, test.val, test.key, n)
+		}
+	}
+}
+
+type scanServer struct {
+	Name     string `ps:"name"`
+	Database struct {
+		Host string `ps:"host"`
+		Port int    `ps:"port,required"`
+	} `ps:"db"`
+	Cache *struct {
+		TTL int `json:"ttl"`
+	} `ps:"cache"`
+	Replicas int
+}
+
+func TestScanNested(t *testing.T) {
+	m := NewMap().
+		Set("name", "api").
+		Set("db", NewMap().Set("host", "db.local").Set("port", 5432.0)).
+		Set("cache", NewMap().Set("ttl", 60))
+
+	s := scanServer{Replicas: 3}
+	if err := m.Scan(&s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Name != "api" || s.Database.Host != "db.local" || s.Database.Port != 5432 {
+		t.Errorf("wrong fields: %+v", s)
+	}
+	if s.Cache == nil || s.Cache.TTL != 60 {
+		t.Errorf("pointer to a struct wasn't filled in: %+v", s.Cache)
+	}
+	if s.Replicas != 3 {
+		t.Errorf("a missing key changed its field: %d", s.Replicas)
+	}
+
+	// round trip through FromStruct
+	back, err := FromStruct(s.Database)
+	if err != nil || !back.Equal(NewMap().Set("host", "db.local").Set("port", 5432)) {
+		t.Errorf("round trip failed: %s, %v", back, err)
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	tests := []struct {
+		m        Map
+		expected []string
+	}{
+		{NewMap().Set("name", 1), []string{`"name"`, "string"}},
+		{NewMap().Set("db", NewMap().Set("port", "x")), []string{`"db"`, `"port"`, "int"}},
+		{NewMap().Set("db", NewMap()), []string{`"db"`, `missing required key "port"`}},
+		{NewMap().Set("db", "localhost"), []string{`"db"`, "cannot assign string"}},
+		{NewMap().Set("Replicas", uint64(1)<<63), []string{`"Replicas"`, "overflows"}},
+		{NewMap().Set("db", NewMap().Set("port", -1.5)), []string{`"db"`, `"port"`, "truncates"}},
+	}
+	for _, test := range tests {
+		var s scanServer
+		err := test.m.Scan(&s)
+		if err == nil {
+			t.Errorf("expected an error scanning %s", test.m)
+			continue
+		}
+		for _, want := range test.expected {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error scanning %s doesn't mention %s: %v", test.m, want, err)
+			}
+		}
+	}
+
+	if err := NewMap().Scan(scanServer{}); err == nil {
+		t.Errorf("expected an error scanning into a non-pointer")
+	}
+}
+
+type encodeServer struct {
+	Name     string `json:"name"`
+	Database struct {
+		Host string `ps:"host"`
+		Port int
+	} `ps:"db"`
+	Secret string `json:"-"`
+}
+
+func TestFromStruct(t *testing.T) {
+	m, err := FromStruct(decodeConfig{Host: "localhost", Port: 80, Debug: true, Ignored: "x"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := NewMap().Set("host", "localhost").Set("port", 80).Set("Debug", true)
+	if !m.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, m)
+	}
+
+	var c decodeConfig
+	if err := m.DecodeStruct(&c); err != nil || c.Port != 80 {
+		t.Errorf("round trip failed: %+v, %v", c, err)
+	}
+}
+
+func TestFromStructNested(t *testing.T) {
+	var s encodeServer
+	s.Name = "api"
+	s.Database.Host = "db.local"
+	s.Database.Port = 5432
+	s.Secret = "hunter2"
+
+	m, err := FromStruct(&s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := m.Lookup("Secret"); ok || m.Size() != 2 {
+		t.Errorf("field tagged \"-\" wasn't omitted: %s", m)
+	}
+
+	db, _ := m.Lookup("db")
+	expected := NewMap().Set("host", "db.local").Set("Port", 5432)
+	if nested, ok := db.(Map); !ok || !nested.Equal(expected) {
+		t.Errorf("expected nested map %s, got %v", expected, db)
+	}
+
+	if _, err := FromStruct(42); err == nil {
+		t.Errorf("expected an error for a non-struct")
+	}
+}
+
+func TestFromStructOpaque(t *testing.T) {
+	type opaque struct{ n int }
+	type event struct {
+		Name   string
+		At     time.Time
+		Secret opaque
+	}
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	in := event{"launch", at, opaque{7}}
+
+	m, err := FromStruct(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _ := m.Lookup("At"); v != at {
+		t.Errorf("expected the time to be stored as it is, got %#v", v)
+	}
+	if v, _ := m.Lookup("Secret"); v != (opaque{7}) {
+		t.Errorf("expected a struct without exported fields to be stored as it is, got %#v", v)
+	}
+
+	var out event
+	if err := m.DecodeStruct(&out); err != nil || out != in {
+		t.Errorf("expected %+v to decode back, got %+v, %v", in, out, err)
+	}
+
+	if _, err := FromStruct("x"); err == nil {
+		t.Errorf("expected an error for a non-struct")
+	}
+}
@@ -0,0 +1,60 @@
+package ps
+
+import "time"
+
+// tombstone is stored in place of the value of a key removed by
+// TombstoneDelete
+type tombstone struct{}
+
+func isTombstone(v Any) bool {
+	_, ok := v.(tombstone)
+	return ok
+}
+
+func (t *tree) TombstoneDelete(key string) Map {
+	if _, ok := t.Lookup(key); !ok {
+		return t
+	}
+	return t.Set(key, tombstone{})
+}
+
+func (t *tree) Compact() Map {
+	return t.CompactAt(time.Now())
+}
+
+func (t *tree) CompactAt(now time.Time) Map {
+	if t.dead == 0 && t.expiredAt(now) == 0 {
+		return t
+	}
+	return buildMap(t.opts, t.liveEntries(now))
+}
+
+func (t *tree) TrimToSize() Map {
+	m := buildMap(t.opts, t.liveEntries(time.Now()))
+	if t.opts.wantsBloom() && !m.IsNil() {
+		m.filter = newBloomFilter(m)
+	}
+	return m
+}
+
+// liveEntries returns the entries which are neither tombstoned nor expired
+// at the given time, with their values as stored
+func (t *tree) liveEntries(now time.Time) []Entry {
+	live := make([]Entry, 0, t.count)
+	t.eachEntry(func(key string, val Any) {
+		if _, ok := resolveAt(val, now); ok {
+			live = append(live, Entry{key, val})
+		}
+	})
+	return live
+}
+
+func (t *tree) RawForEach(f func(key string, val Any, dead bool)) {
+	t.eachEntry(func(key string, val Any) {
+		if val, ok := resolve(val); ok {
+			f(key, val, false)
+		} else {
+			f(key, nil, true)
+		}
+	})
+}
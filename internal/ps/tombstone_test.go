@@ -0,0 +1,126 @@
+package ps
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestTombstoneDelete(t *testing.T) {
+	m := NewMap().Set("a", 1).Set("b", 2).Set("c", 3)
+	dead := m.TombstoneDelete("b")
+
+	if _, ok := dead.Lookup("b"); ok {
+		t.Errorf("tombstoned key is still visible to Lookup")
+	}
+	if v, _ := m.Lookup("b"); v != 2 {
+		t.Errorf("TombstoneDelete() modified the receiving map")
+	}
+	if size := dead.Size(); size != 2 {
+		t.Errorf("wrong size: %d", size)
+	}
+	keys := dead.Keys()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Errorf("wrong keys: %#v", keys)
+	}
+	if !dead.Equal(NewMap().Set("a", 1).Set("c", 3)) {
+		t.Errorf("tombstoned map isn't equal to its live contents")
+	}
+
+	var raw []string
+	dead.RawForEach(func(k string, v Any, isDead bool) {
+		if isDead != (k == "b") {
+			t.Errorf("wrong tombstone flag for %s", k)
+		}
+		raw = append(raw, k)
+	})
+	if len(raw) != 3 {
+		t.Errorf("RawForEach should include tombstones: %#v", raw)
+	}
+
+	if dead.TombstoneDelete("missing") != dead {
+		t.Errorf("tombstoning a missing key changed the map")
+	}
+
+	revived := dead.Set("b", 4)
+	if v, ok := revived.Lookup("b"); !ok || v != 4 || revived.Size() != 3 {
+		t.Errorf("Set() didn't replace the tombstone")
+	}
+}
+
+func TestCompactRebalances(t *testing.T) {
+	// deleting most keys leaves the survivors deeper than they need be
+	m := NewMap()
+	for i := 0; i < 100000; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	deleted, tombstoned := m, m
+	for i := 0; i < 100000; i++ {
+		if i%50 != 0 {
+			deleted = deleted.Delete(strconv.Itoa(i))
+			tombstoned = tombstoned.TombstoneDelete(strconv.Itoa(i))
+		}
+	}
+
+	compacted := tombstoned.Compact()
+	if compacted.Depth() >= deleted.Depth() {
+		t.Errorf("compacting didn't reduce the depth of %d", deleted.Depth())
+	}
+	if !compacted.Equal(deleted) || compacted.Size() != 2000 {
+		t.Errorf("compacting changed the contents")
+	}
+	if compacted.Compact() != compacted {
+		t.Errorf("compacting a map with nothing to remove should return it unchanged")
+	}
+
+	// with nothing to remove, only TrimToSize rebuilds
+	if deleted.Compact() != deleted {
+		t.Errorf("compacting a map without tombstones should return it unchanged")
+	}
+	if trimmed := deleted.TrimToSize(); trimmed.Depth() >= deleted.Depth() || !trimmed.Equal(deleted) {
+		t.Errorf("trimming didn't rebuild the map")
+	}
+
+	// keys stay where the map's options place them
+	narrow := NewMapWithBranching(2)
+	for i := 0; i < 1000; i++ {
+		narrow = narrow.Set(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 1000; i += 2 {
+		narrow = narrow.TombstoneDelete(strconv.Itoa(i))
+	}
+	compacted = narrow.Compact()
+	if compacted.(*tree).opts != narrow.(*tree).opts || compacted.Size() != 500 {
+		t.Errorf("compacting lost the map's options")
+	}
+	for i := 1; i < 1000; i += 2 {
+		if v, ok := compacted.Lookup(strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("lost %d after compacting: %v, %v", i, v, ok)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	m := NewMap().Set("a", 1).Set("b", 2).Set("c", 3)
+	if m.Compact() != m {
+		t.Errorf("compacting a map without tombstones should return it unchanged")
+	}
+
+	dead := m.TombstoneDelete("a").TombstoneDelete("c")
+	compacted := dead.Compact()
+
+	visited := 0
+	compacted.RawForEach(func(k string, v Any, isDead bool) {
+		visited++
+		if isDead {
+			t.Errorf("tombstone for %s survived compaction", k)
+		}
+	})
+	if visited != 1 || compacted.Size() != 1 {
+		t.Errorf("wrong number of entries after compaction: %d", visited)
+	}
+	if dead.Size() != 1 {
+		t.Errorf("Compact() modified the receiving map")
+	}
+}
@@ -0,0 +1,179 @@
+package ps
+
+// Transient is a mutable view of a Map for building it up efficiently, like
+// Clojure's transients. Nodes copied by a Transient belong to it, so later
+// changes reaching the same nodes modify them in place instead of copying
+// them again. Nodes shared with the Map it came from are never modified.
+//
+// Call Persistent to get the result. The Transient must not be used after
+// that: the returned Map shares its nodes, so any further changes would
+// show through it. A Transient isn't safe for concurrent use.
+type Transient struct {
+	root  *tree
+	done  bool
+	nodes nodeSlab // where the nodes the Transient copies are allocated
+}
+
+func (t *tree) AsTransient() *Transient {
+	return &Transient{root: t}
+}
+
+func (t *tree) SetMany(pairs map[string]Any) Map {
+	if len(pairs) == 0 {
+		return t
+	}
+	tr := t.AsTransient()
+	for k, v := range pairs {
+		tr.Set(k, v)
+	}
+	return tr.Persistent()
+}
+
+func (t *tree) DeleteMany(keys []string) Map {
+	tr := t.AsTransient()
+	for _, k := range keys {
+		tr.Delete(k)
+	}
+	return tr.Persistent()
+}
+
+// Set associates key and value, panicking like Map.Set if the map only
+// accepts keys matching a pattern and key doesn't match it.
+// This operation is O(log N) in the number of keys.
+func (tr *Transient) Set(key string, value Any) {
+	tr.check()
+	key = tr.root.opts.normalizeKey(key)
+	if err := tr.root.opts.checkKey(key); err != nil {
+		panic(err)
+	}
+	key = tr.root.opts.internKey(key)
+	hash := tr.root.opts.hashKey(key)
+	tr.root = tr.set(tr.root, tr.root.opts.partialHash(hash), hash, key, value)
+}
+
+// Delete removes the association for key, if any.
+// This operation is O(log N) in the number of keys.
+func (tr *Transient) Delete(key string) {
+	tr.check()
+	key = tr.root.opts.normalizeKey(key)
+	hash := tr.root.opts.hashKey(key)
+	if root, found := tr.delete(tr.root, tr.root.opts.partialHash(hash), hash, key); found {
+		tr.root = tr.root.adopt(root)
+	}
+}
+
+// Lookup returns the value associated with a key, if any, as Map.Lookup.
+// This operation is O(log N) in the number of keys.
+func (tr *Transient) Lookup(key string) (Any, bool) {
+	tr.check()
+	return tr.root.Lookup(key)
+}
+
+// Size returns the number of key value pairs held.
+// This operation is O(1).
+func (tr *Transient) Size() int {
+	tr.check()
+	return tr.root.Size()
+}
+
+// Persistent returns the map built by the Transient, which must not be
+// used afterwards.
+func (tr *Transient) Persistent() Map {
+	tr.check()
+	tr.done = true
+	tr.nodes = nodeSlab{}
+	return tr.root
+}
+
+func (tr *Transient) check() {
+	if tr.done {
+		panic("Transient used after Persistent")
+	}
+}
+
+// editable returns a node which may be modified in place: n itself if it
+// belongs to tr, otherwise a copy of it which does
+func (tr *Transient) editable(n *tree) *tree {
+	if n.owner == tr {
+		return n
+	}
+	m := tr.nodes.alloc()
+	*m = *n
+	m.filter = nil
+	m.keys = nil
+	m.owner = tr
+	return m
+}
+
+// nodeSlab hands out nodes from blocks allocated together, so building a
+// map allocates once per block rather than once per node. A node can't be
+// known to be unreachable once it's been handed out, since any version of
+// the map may still hold it, so nodes are never recycled. Instead blocks
+// are kept small, doubling from nodeSlabMin up to nodeSlabMax nodes: a
+// block is only freed once none of its nodes is reachable, and a block of
+// a map which later versions have mostly replaced holds on to the rest.
+type nodeSlab struct {
+	free []tree // the rest of the current block
+	size int    // of the current block
+}
+
+const (
+	nodeSlabMin = 8
+	nodeSlabMax = 128
+)
+
+// alloc returns a zeroed node
+func (s *nodeSlab) alloc() *tree {
+	if len(s.free) == 0 {
+		s.size = min(max(2*s.size, nodeSlabMin), nodeSlabMax)
+		s.free = make([]tree, s.size)
+	}
+	n := &s.free[0]
+	s.free = s.free[1:]
+	return n
+}
+
+// set is setLowLevel, modifying nodes which belong to tr
+func (tr *Transient) set(n *tree, partialHash, hash uint64, key string, value Any) *tree {
+	m := tr.editable(n)
+	switch {
+	case n.IsNil():
+		m.hash = hash
+		m.key = key
+		m.value = value
+	case hash != n.hash:
+		i := partialHash % childCount
+		m.children[i] = tr.set(m.children[i], partialHash>>shiftSize, hash, key, value)
+	case key == n.key:
+		m.value = value
+	default:
+		// the bucket may be shared with the node m was copied from
+		i := n.overflowIndex(key)
+		m.overflow = append(make([]Entry, 0, len(n.overflow)+1), n.overflow...)
+		if i < 0 {
+			m.overflow = append(m.overflow, Entry{key, value})
+		} else {
+			m.overflow[i].Val = value
+		}
+	}
+	recalculateCount(m)
+	return m
+}
+
+// delete walks down to the node holding key in place, then removes the
+// key from it as deleteLowLevel does
+func (tr *Transient) delete(n *tree, partialHash, hash uint64, key string) (*tree, bool) {
+	if n.IsNil() || hash == n.hash {
+		return deleteLowLevel(n, partialHash, hash, key)
+	}
+
+	i := partialHash % childCount
+	child, found := tr.delete(n.children[i], partialHash>>shiftSize, hash, key)
+	if !found {
+		return n, false
+	}
+	m := tr.editable(n)
+	m.children[i] = child
+	recalculateCount(m)
+	return m, true
+}
@@ -0,0 +1,217 @@
+package ps
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestTransient(t *testing.T) {
+	naive := NewMap()
+	tr := NewMap().AsTransient()
+	for i := 0; i < 1000; i++ {
+		naive = naive.Set(strconv.Itoa(i), i)
+		tr.Set(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 1000; i += 3 {
+		naive = naive.Delete(strconv.Itoa(i))
+		tr.Delete(strconv.Itoa(i))
+	}
+	tr.Delete("missing")
+
+	if tr.Size() != naive.Size() {
+		t.Errorf("wrong size: %d", tr.Size())
+	}
+	if v, ok := tr.Lookup("1"); !ok || v != 1 {
+		t.Errorf("wrong value for 1: %v", v)
+	}
+	m := tr.Persistent()
+	if !m.Equal(naive) {
+		t.Errorf("transient built a different map")
+	}
+	if m.(*tree).digest != naive.(*tree).digest {
+		t.Errorf("transient built a map with the wrong digest")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("using a transient after Persistent() should panic")
+		}
+	}()
+	tr.Set("a", 1)
+}
+
+func TestTransientLeavesSourceAlone(t *testing.T) {
+	source := NewMap()
+	for i := 0; i < 100; i++ {
+		source = source.Set(strconv.Itoa(i), i)
+	}
+
+	tr := source.AsTransient()
+	for i := 0; i < 100; i++ {
+		tr.Set(strconv.Itoa(i), -i)
+	}
+	for i := 0; i < 50; i++ {
+		tr.Delete(strconv.Itoa(i))
+	}
+	changed := tr.Persistent()
+
+	if source.Size() != 100 || changed.Size() != 50 {
+		t.Errorf("wrong sizes: %d and %d", source.Size(), changed.Size())
+	}
+	for i := 0; i < 100; i++ {
+		if v, _ := source.Lookup(strconv.Itoa(i)); v != i {
+			t.Errorf("transient modified the source map at %d: %v", i, v)
+		}
+	}
+	if v, _ := changed.Lookup("99"); v != -99 {
+		t.Errorf("wrong value in the result: %v", v)
+	}
+
+	// the result is a normal map again
+	if next := changed.Set("new", 1); changed.Size() != 50 || next.Size() != 51 {
+		t.Errorf("Set() on the result modified it")
+	}
+}
+
+func TestTransientCollisions(t *testing.T) {
+	tr := NewMap().AsTransient()
+	for _, k := range []string{"a", "b", "c"} {
+		tr.root = tr.set(tr.root, 7, 7, k, k)
+	}
+	tr.root = tr.set(tr.root, 7, 7, "b", "B")
+	if tr.Size() != 3 {
+		t.Errorf("wrong size: %d", tr.Size())
+	}
+	if v, _ := lookupLowLevel(tr.root, 7, 7, "b"); v != "B" {
+		t.Errorf("wrong value for b: %v", v)
+	}
+
+	root, found := tr.delete(tr.root, 7, 7, "a")
+	if !found || root.count != 2 {
+		t.Errorf("wrong result deleting from a collision bucket: %v, %d", found, root.count)
+	}
+}
+
+func TestTransientKeyPattern(t *testing.T) {
+	tr := NewMapKeyPattern(regexp.MustCompile(`^[a-z]+$`)).AsTransient()
+	tr.Set("a", 1)
+	tr.Delete("a")
+	m := tr.Persistent()
+	if _, err := m.SetChecked("Not OK", 1); err == nil {
+		t.Errorf("transient lost the key pattern")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic for a rejected key")
+		}
+	}()
+	m.AsTransient().Set("Not OK", 1)
+}
+
+func TestSetManyDeleteMany(t *testing.T) {
+	m := NewMap().Set("a", 1).Set("b", 2)
+	pairs := map[string]Any{"b": 20, "c": 30, "d": nil}
+	for i := 0; i < 100; i++ {
+		pairs[strconv.Itoa(i)] = i
+	}
+
+	expected := m
+	for k, v := range pairs {
+		expected = expected.Set(k, v)
+	}
+	set := m.SetMany(pairs)
+	if !set.Equal(expected) {
+		t.Errorf("SetMany() differs from repeated Set")
+	}
+	if m.Size() != 2 {
+		t.Errorf("SetMany() modified the receiving map")
+	}
+
+	keys := []string{"a", "c", "missing", "50", "50"}
+	expected = set
+	for _, k := range keys {
+		expected = expected.Delete(k)
+	}
+	if deleted := set.DeleteMany(keys); !deleted.Equal(expected) || deleted.Size() != set.Size()-3 {
+		t.Errorf("DeleteMany() differs from repeated Delete")
+	}
+
+	if m.SetMany(nil) != m || m.SetMany(map[string]Any{}) != m {
+		t.Errorf("SetMany() with no pairs should return the receiver")
+	}
+	if m.DeleteMany(nil) != m || m.DeleteMany([]string{"missing"}) != m {
+		t.Errorf("DeleteMany() removing nothing should return the receiver")
+	}
+}
+
+func TestTransientConcurrentBuilders(t *testing.T) {
+	base := NewMap()
+	for i := 0; i < 1000; i++ {
+		base = base.Set(strconv.Itoa(i), i)
+	}
+
+	// each builder copies nodes of the shared base into its own blocks
+	results := make([]Map, 8)
+	var wg sync.WaitGroup
+	for w := range results {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			tr := base.AsTransient()
+			for i := 0; i < 1000; i++ {
+				tr.Set(strconv.Itoa(i), i*w)
+				tr.Set(strconv.Itoa(w)+"/"+strconv.Itoa(i), w)
+			}
+			for i := 0; i < 1000; i += 10 {
+				tr.Delete(strconv.Itoa(i))
+			}
+			results[w] = tr.Persistent()
+		}(w)
+	}
+	wg.Wait()
+
+	for w, m := range results {
+		if m.Size() != 1900 {
+			t.Errorf("builder %d: wrong size %d", w, m.Size())
+		}
+		for i := 1; i < 1000; i += 10 {
+			if v, _ := m.Lookup(strconv.Itoa(i)); v != i*w {
+				t.Errorf("builder %d: wrong value for %d: %v", w, i, v)
+			}
+		}
+		if err := checkCounts(m.(*tree)); err != "" {
+			t.Errorf("builder %d: %s", w, err)
+		}
+	}
+	if base.Size() != 1000 {
+		t.Errorf("a builder modified the shared base")
+	}
+	for i := 0; i < 1000; i++ {
+		if v, _ := base.Lookup(strconv.Itoa(i)); v != i {
+			t.Fatalf("a builder modified the shared base at %d: %v", i, v)
+		}
+	}
+}
+
+func BenchmarkBulkInsertNaive(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		m := NewMap()
+		for j := 0; j < 100000; j++ {
+			m = m.Set(strconv.Itoa(j), j)
+		}
+	}
+}
+
+func BenchmarkBulkInsertTransient(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tr := NewMap().AsTransient()
+		for j := 0; j < 100000; j++ {
+			tr.Set(strconv.Itoa(j), j)
+		}
+		tr.Persistent()
+	}
+}
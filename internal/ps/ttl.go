@@ -0,0 +1,97 @@
+package ps
+
+import "time"
+
+// expiring is stored in place of the value of a key set by SetTTL
+type expiring struct {
+	value     Any
+	expiresAt time.Time
+}
+
+// resolve returns the value a node's stored value stands for, and false if
+// the key is tombstoned or has expired
+func resolve(v Any) (Any, bool) {
+	switch v := v.(type) {
+	case tombstone:
+		return nil, false
+	case expiring:
+		return resolveAt(v, time.Now())
+	}
+	return v, true
+}
+
+// resolveAt is like resolve but checks expiry against the given time
+func resolveAt(v Any, now time.Time) (Any, bool) {
+	switch v := v.(type) {
+	case tombstone:
+		return nil, false
+	case expiring:
+		if !now.Before(v.expiresAt) {
+			return nil, false
+		}
+		return v.value, true
+	}
+	return v, true
+}
+
+func (t *tree) SetTTL(key string, value Any, expiresAt time.Time) Map {
+	return t.Set(key, expiring{value, expiresAt})
+}
+
+func (t *tree) LookupAt(key string, now time.Time) (Any, bool) {
+	key = t.opts.normalizeKey(key)
+	hash := t.opts.hashKey(key)
+	val, ok := lookupLowLevel(t, t.opts.partialHash(hash), hash, key)
+	if !ok {
+		return nil, false
+	}
+	return resolveAt(val, now)
+}
+
+// forEachStored executes f on each key value pair in m, like ForEach, also
+// passing the value as it's stored. For a key set by SetTTL that holds the
+// expiry, so operations which build a new map by setting m's entries give
+// the stored value to Set to keep it. For a map not built by this package
+// the two are the same.
+func forEachStored(m Map, f func(key string, val, stored Any)) {
+	t, ok := m.(*tree)
+	if !ok {
+		m.ForEach(func(key string, val Any) { f(key, val, val) })
+		return
+	}
+	now := time.Now()
+	t.eachEntry(func(key string, stored Any) {
+		if val, ok := resolveAt(stored, now); ok {
+			f(key, val, stored)
+		}
+	})
+}
+
+// lookupStored is Lookup, also returning the value as stored; see
+// forEachStored
+func lookupStored(m Map, key string) (val, stored Any, ok bool) {
+	t, isTree := m.(*tree)
+	if !isTree {
+		val, ok = m.Lookup(key)
+		return val, val, ok
+	}
+	key = t.opts.normalizeKey(key)
+	hash := t.opts.hashKey(key)
+	stored, ok = lookupLowLevel(t, t.opts.partialHash(hash), hash, key)
+	if !ok {
+		return nil, nil, false
+	}
+	if val, ok = resolve(stored); !ok {
+		return nil, nil, false
+	}
+	return val, stored, true
+}
+
+// withExpiry returns val to be stored with the same expiry as stored, if
+// stored is the value of a key set by SetTTL
+func withExpiry(val, stored Any) Any {
+	if e, ok := stored.(expiring); ok {
+		return expiring{val, e.expiresAt}
+	}
+	return val
+}
@@ -0,0 +1,172 @@
+package ps
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSetTTL(t *testing.T) {
+	now := time.Now()
+	m := NewMap().
+		Set("forever", 1).
+		SetTTL("fresh", 2, now.Add(time.Hour)).
+		SetTTL("stale", 3, now.Add(-time.Hour))
+
+	if v, ok := m.Lookup("fresh"); !ok || v != 2 {
+		t.Errorf("value which hasn't expired wasn't found: %v", v)
+	}
+	if _, ok := m.Lookup("stale"); ok {
+		t.Errorf("expired value was found")
+	}
+	if keys := m.Keys(); len(keys) != 2 {
+		t.Errorf("expired key is listed: %#v", keys)
+	}
+
+	later := now.Add(2 * time.Hour)
+	if _, ok := m.LookupAt("fresh", later); ok {
+		t.Errorf("value was found after its expiry")
+	}
+	if v, ok := m.LookupAt("stale", now.Add(-2*time.Hour)); !ok || v != 3 {
+		t.Errorf("value wasn't found before its expiry: %v", v)
+	}
+
+	// Set clears the expiry
+	if v, ok := m.Set("stale", 4).Lookup("stale"); !ok || v != 4 {
+		t.Errorf("Set() didn't replace the expired value: %v", v)
+	}
+}
+
+func TestKeysExpire(t *testing.T) {
+	m := NewMap().Set("forever", 1).SetTTL("brief", 2, time.Now().Add(20*time.Millisecond))
+	if keys := m.Keys(); len(keys) != 2 {
+		t.Errorf("key which hasn't expired isn't listed: %#v", keys)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if keys := m.Keys(); len(keys) != 1 || keys[0] != "forever" {
+		t.Errorf("Keys() kept listing an expired key: %#v", keys)
+	}
+}
+
+func TestCompactExpired(t *testing.T) {
+	now := time.Now()
+	m := NewMap().
+		Set("forever", 1).
+		SetTTL("fresh", 2, now.Add(time.Hour)).
+		SetTTL("stale", 3, now.Add(-time.Hour))
+
+	compacted := m.Compact()
+	if compacted.Size() != 2 {
+		t.Errorf("expected 2 keys after compaction, got %d", compacted.Size())
+	}
+	stale := false
+	compacted.RawForEach(func(k string, v Any, dead bool) { stale = stale || k == "stale" })
+	if stale {
+		t.Errorf("expired key survived compaction")
+	}
+
+	if c := m.CompactAt(now.Add(2 * time.Hour)); c.Size() != 1 {
+		t.Errorf("expected 1 key after a later compaction, got %d", c.Size())
+	}
+	if c := compacted.Compact(); c != compacted {
+		t.Errorf("compacting without expired keys changed the map")
+	}
+}
+
+func TestExpiredKeysNotCounted(t *testing.T) {
+	a := NewMap().Set("a", 1).SetTTL("b", 2, time.Now().Add(-time.Hour))
+	b := NewMap().Set("a", 1).Set("b", 2)
+
+	if a.Size() != 1 || a.Len() != len(a.Keys()) {
+		t.Errorf("expected the expired key not to be counted, got Size() %d and Keys() %#v", a.Size(), a.Keys())
+	}
+	if a.Equal(b) || b.Equal(a) {
+		t.Errorf("a map with an expired key equals one with the key present: %v, %v", a.Equal(b), b.Equal(a))
+	}
+	if !a.Equal(NewMap().Set("a", 1)) || !NewMap().Set("a", 1).Equal(a) {
+		t.Errorf("a map with an expired key doesn't equal one without the key")
+	}
+
+	gone := NewMap().SetTTL("only", 1, time.Now().Add(-time.Minute))
+	if !gone.IsEmpty() || gone.Size() != 0 {
+		t.Errorf("a map whose only key has expired isn't empty: Size() %d", gone.Size())
+	}
+	if !gone.Equal(NewMap()) || !NewMap().Equal(gone) {
+		t.Errorf("a map whose only key has expired doesn't equal an empty map")
+	}
+}
+
+// checkExpiry checks that m holds key with val until the TTL key's expiry,
+// and not after it
+func checkExpiry(t *testing.T, op string, m Map, key string, val Any, expiresAt time.Time) {
+	t.Helper()
+	if v, ok := m.LookupAt(key, expiresAt.Add(-time.Second)); !ok || !valuesEqual(v, val) {
+		t.Errorf("%s: expected %s to hold %v before it expires, got %v, %v", op, key, val, v, ok)
+	}
+	if v, ok := m.LookupAt(key, expiresAt); ok {
+		t.Errorf("%s: %s outlived its expiry: %v", op, key, v)
+	}
+}
+
+func TestRebuildsKeepExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+	m := NewMap().Set("a", 1).Set("x.y", 3).SetTTL("b", 2, expiresAt)
+
+	deleted, _ := m.DeletePrefix("x")
+	checkExpiry(t, "DeletePrefix", deleted, "b", 2, expiresAt)
+	checkExpiry(t, "DeepMerge", NewMap().Set("c", 3).DeepMerge(m), "b", 2, expiresAt)
+	checkExpiry(t, "Flatten", NewMap().Set("n", m).Flatten("."), "n.b", 2, expiresAt)
+	checkExpiry(t, "Unflatten", m.Unflatten("."), "b", 2, expiresAt)
+
+	nested := NewMap().Set("n", NewMap().Set("a", 1))
+	merged := nested.DeepMerge(NewMap().SetTTL("n", NewMap().Set("b", 2), expiresAt))
+	checkExpiry(t, "DeepMerge of nested maps", merged, "n", NewMap().Set("a", 1).Set("b", 2), expiresAt)
+}
+
+func TestMergeKeepsExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+	big := NewMap()
+	for i := 0; i < 10; i++ {
+		big = big.Set(strconv.Itoa(i), i)
+	}
+	small := NewMap().SetTTL("b", 2, expiresAt)
+
+	checkExpiry(t, "Merge of a smaller map", big.Merge(small), "b", 2, expiresAt)
+	checkExpiry(t, "Merge into a larger map", small.Merge(big), "b", 2, expiresAt)
+	checkExpiry(t, "MergeAll", MergeAll(big, small, NewMap().Set("c", 3)), "b", 2, expiresAt)
+}
+
+func TestMergeWithKeepsExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+	sum := func(_ string, a, b Any) Any { return a.(int) + b.(int) }
+	m := NewMap().Set("a", 1).SetTTL("mine", 5, expiresAt)
+	other := NewMap().SetTTL("a", 2, expiresAt).SetTTL("b", 3, expiresAt).Set("mine", 1)
+
+	merged := m.MergeWith(other, sum)
+	checkExpiry(t, "MergeWith of a new key", merged, "b", 3, expiresAt)
+	checkExpiry(t, "MergeWith of a resolved key", merged, "a", 3, expiresAt)
+	if v, ok := merged.LookupAt("mine", expiresAt.Add(time.Hour)); !ok || v != 6 {
+		t.Errorf("a resolved value should take the expiry of other's key, which has none: %v, %v", v, ok)
+	}
+}
+
+func TestIntersectionKeepsExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+	m := NewMap().SetTTL("b", 2, expiresAt)
+	for i := 0; i < 10; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+
+	// a small other rebuilds the result, a large one deletes from m
+	checkExpiry(t, "Intersection with a small map", m.Intersection(NewMap().Set("b", 0)), "b", 2, expiresAt)
+	checkExpiry(t, "Intersection with a large map", m.Intersection(m.Delete("1")), "b", 2, expiresAt)
+}
+
+func TestProjectKeepsExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+	m := NewMap().SetTTL("old", 1, expiresAt).SetTTL("kept", 2, expiresAt)
+
+	projected := m.Project(map[string]string{"old": "new"}, true)
+	checkExpiry(t, "Project of a renamed key", projected, "new", 1, expiresAt)
+	checkExpiry(t, "Project of a kept key", projected, "kept", 2, expiresAt)
+}
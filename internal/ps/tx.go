@@ -0,0 +1,43 @@
+package ps
+
+// Tx stages changes to a map inside Transact. Since maps are immutable, the
+// staged changes build a new version and rolling back simply discards it.
+//
+// A Tx is only valid during the call to Transact which created it.
+type Tx struct {
+	m Map
+}
+
+// Set stages associating key with value. It returns an error if the map
+// doesn't accept key; see Map.SetChecked.
+func (tx *Tx) Set(key string, value Any) error {
+	m, err := tx.m.SetChecked(key, value)
+	if err != nil {
+		return err
+	}
+	tx.m = m
+	return nil
+}
+
+// Delete stages removing key.
+func (tx *Tx) Delete(key string) {
+	tx.m = tx.m.Delete(key)
+}
+
+// Lookup returns the value associated with a key, including staged changes.
+func (tx *Tx) Lookup(key string) (Any, bool) {
+	return tx.m.Lookup(key)
+}
+
+// Map returns the map with the changes staged so far.
+func (tx *Tx) Map() Map {
+	return tx.m
+}
+
+func (t *tree) Transact(fn func(tx *Tx) error) (Map, error) {
+	tx := &Tx{t}
+	if err := fn(tx); err != nil {
+		return t, err
+	}
+	return tx.m, nil
+}
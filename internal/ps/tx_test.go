@@ -0,0 +1,52 @@
+package ps
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransactCommit(t *testing.T) {
+	m := NewMap().Set("balance", 10).Set("stale", true)
+
+	result, err := m.Transact(func(tx *Tx) error {
+		v, _ := tx.Lookup("balance")
+		if err := tx.Set("balance", v.(int)-3); err != nil {
+			return err
+		}
+		tx.Delete("stale")
+		if v, _ := tx.Lookup("balance"); v != 7 {
+			t.Errorf("staged change isn't visible: %v", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !result.Equal(NewMap().Set("balance", 7)) {
+		t.Errorf("wrong result: %s", result)
+	}
+	if !m.Equal(NewMap().Set("balance", 10).Set("stale", true)) {
+		t.Errorf("Transact() modified the receiving map")
+	}
+}
+
+func TestTransactRollback(t *testing.T) {
+	m := NewMap().Set("balance", 10)
+	errInsufficient := errors.New("insufficient funds")
+
+	result, err := m.Transact(func(tx *Tx) error {
+		tx.Set("balance", -5)
+		tx.Set("overdrawn", true)
+		return errInsufficient
+	})
+	if err != errInsufficient {
+		t.Errorf("expected the transaction's error, got %v", err)
+	}
+	if result != m {
+		t.Errorf("rolled back transaction didn't return the original map")
+	}
+	if v, _ := m.Lookup("balance"); v != 10 || m.Size() != 1 {
+		t.Errorf("rolled back transaction modified the map")
+	}
+}
@@ -0,0 +1,236 @@
+package ps
+
+// TypedMap is a persistent map with keys of type K and values of type V,
+// using the same path-copying tree as Map. It avoids boxing values in Any
+// and the type assertions needed to get them back.
+//
+// The name Map is taken by the string keyed map, which is unchanged.
+//
+// Like Map, a TypedMap is immutable and safe to copy; the zero value isn't
+// usable, create one with NewTypedMap.
+type TypedMap[K comparable, V any] struct {
+	root *typedNode[K, V]
+	hash func(K) uint64
+}
+
+type typedNode[K comparable, V any] struct {
+	count    int
+	hash     uint64
+	key      K
+	value    V
+	overflow []typedEntry[K, V] // other keys with the same hash as key
+	children [childCount]*typedNode[K, V]
+}
+
+type typedEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// NewTypedMap returns a new, empty map which places keys using hash.
+//
+// Taking the hash function here, rather than requiring K to implement a
+// Hasher interface, lets built-in types such as string and int be used as
+// keys. The price is that nothing ties a key type to a good hash: keys
+// which are equal must hash equally, and a hash with many collisions makes
+// operations degrade towards O(N). NewTypedMap panics if hash is nil.
+func NewTypedMap[K comparable, V any](hash func(K) uint64) TypedMap[K, V] {
+	if hash == nil {
+		panic("NewTypedMap needs a hash function")
+	}
+	return TypedMap[K, V]{hash: hash}
+}
+
+// IsNil returns true if the map is empty.
+func (m TypedMap[K, V]) IsNil() bool {
+	return m.root == nil
+}
+
+// Size returns the number of key value pairs in the map.
+// This takes O(1) time.
+func (m TypedMap[K, V]) Size() int {
+	if m.root == nil {
+		return 0
+	}
+	return m.root.count
+}
+
+// Set returns a new map in which key and value are associated.
+// This operation is O(log N) in the number of keys.
+func (m TypedMap[K, V]) Set(key K, value V) TypedMap[K, V] {
+	hash := m.hash(key)
+	return TypedMap[K, V]{m.root.set(hash, hash, key, value), m.hash}
+}
+
+// Delete returns a new map with the association for key, if any, removed.
+// This operation is O(log N) in the number of keys.
+func (m TypedMap[K, V]) Delete(key K) TypedMap[K, V] {
+	hash := m.hash(key)
+	root, found := m.root.delete(hash, hash, key)
+	if !found {
+		return m
+	}
+	return TypedMap[K, V]{root, m.hash}
+}
+
+// Lookup returns the value associated with a key, if any.  If the key
+// exists, the second return value is true; otherwise, false.
+// This operation is O(log N) in the number of keys.
+func (m TypedMap[K, V]) Lookup(key K) (V, bool) {
+	hash := m.hash(key)
+	partialHash := hash
+	for n := m.root; n != nil; partialHash >>= shiftSize {
+		if n.hash == hash {
+			if n.key == key {
+				return n.value, true
+			}
+			for _, e := range n.overflow {
+				if e.key == key {
+					return e.value, true
+				}
+			}
+			break
+		}
+		n = n.children[partialHash%childCount]
+	}
+
+	var zero V
+	return zero, false
+}
+
+// ForEach executes a callback on each key value pair in the map.
+func (m TypedMap[K, V]) ForEach(f func(key K, val V)) {
+	m.root.forEach(f)
+}
+
+// Keys returns a slice with all keys in this map.
+// This operation is O(N) in the number of keys.
+func (m TypedMap[K, V]) Keys() []K {
+	keys := make([]K, 0, m.Size())
+	m.ForEach(func(k K, _ V) { keys = append(keys, k) })
+	return keys
+}
+
+func (n *typedNode[K, V]) clone() *typedNode[K, V] {
+	m := *n
+	return &m
+}
+
+func (n *typedNode[K, V]) set(partialHash, hash uint64, key K, value V) *typedNode[K, V] {
+	if n == nil {
+		return &typedNode[K, V]{count: 1, hash: hash, key: key, value: value}
+	}
+
+	m := n.clone()
+	switch {
+	case hash != n.hash:
+		i := partialHash % childCount
+		m.children[i] = n.children[i].set(partialHash>>shiftSize, hash, key, value)
+	case key == n.key:
+		m.value = value
+	default:
+		m.overflow = append([]typedEntry[K, V](nil), n.overflow...)
+		if i := n.overflowIndex(key); i >= 0 {
+			m.overflow[i].value = value
+		} else {
+			m.overflow = append(m.overflow, typedEntry[K, V]{key, value})
+		}
+	}
+	m.recalculateCount()
+	return m
+}
+
+func (n *typedNode[K, V]) delete(partialHash, hash uint64, key K) (*typedNode[K, V], bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	if hash != n.hash {
+		i := partialHash % childCount
+		child, found := n.children[i].delete(partialHash>>shiftSize, hash, key)
+		if !found {
+			return n, false
+		}
+		m := n.clone()
+		m.children[i] = child
+		m.recalculateCount()
+		return m, true
+	}
+
+	i := n.overflowIndex(key)
+	if key != n.key && i < 0 {
+		return n, false
+	}
+	if len(n.overflow) > 0 {
+		m := n.clone()
+		if i < 0 {
+			m.key, m.value = n.overflow[0].key, n.overflow[0].value
+			i = 0
+		}
+		m.overflow = append(append([]typedEntry[K, V](nil), n.overflow[:i]...), n.overflow[i+1:]...)
+		m.recalculateCount()
+		return m, true
+	}
+
+	// replace this node with the leftmost leaf of its largest subtree
+	largest := -1
+	for j, c := range n.children {
+		if c != nil && (largest < 0 || c.count > n.children[largest].count) {
+			largest = j
+		}
+	}
+	if largest < 0 {
+		return nil, true
+	}
+	replacement, child := n.children[largest].deleteLeftmost()
+	m := replacement.clone()
+	m.children = n.children
+	m.children[largest] = child
+	m.recalculateCount()
+	return m, true
+}
+
+func (n *typedNode[K, V]) deleteLeftmost() (deleted, rest *typedNode[K, V]) {
+	for i, c := range n.children {
+		if c != nil {
+			deleted, child := c.deleteLeftmost()
+			m := n.clone()
+			m.children[i] = child
+			m.recalculateCount()
+			return deleted, m
+		}
+	}
+	return n, nil
+}
+
+func (n *typedNode[K, V]) overflowIndex(key K) int {
+	for i, e := range n.overflow {
+		if e.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (n *typedNode[K, V]) recalculateCount() {
+	count := 1 + len(n.overflow)
+	for _, c := range n.children {
+		if c != nil {
+			count += c.count
+		}
+	}
+	n.count = count
+}
+
+func (n *typedNode[K, V]) forEach(f func(key K, val V)) {
+	if n == nil {
+		return
+	}
+	f(n.key, n.value)
+	for _, e := range n.overflow {
+		f(e.key, e.value)
+	}
+	for _, c := range n.children {
+		c.forEach(f)
+	}
+}
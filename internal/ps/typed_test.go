@@ -0,0 +1,101 @@
+package ps
+
+import (
+	"sort"
+	"testing"
+)
+
+func intHash(i int) uint64 { return uint64(i) * 0x9E3779B97F4A7C15 }
+
+func TestTypedMap(t *testing.T) {
+	m := NewTypedMap[int, string](intHash)
+	if !m.IsNil() || m.Size() != 0 {
+		t.Errorf("new map isn't empty")
+	}
+
+	for i := 0; i < 100; i++ {
+		m = m.Set(i, string(rune('a'+i%26)))
+	}
+	if m.Size() != 100 {
+		t.Errorf("wrong size: %d", m.Size())
+	}
+	if v, ok := m.Lookup(27); !ok || v != "b" {
+		t.Errorf("wrong value for 27: %q", v)
+	}
+	if _, ok := m.Lookup(100); ok {
+		t.Errorf("found a missing key")
+	}
+
+	smaller := m.Delete(42).Delete(7).Delete(1000)
+	if smaller.Size() != 98 || m.Size() != 100 {
+		t.Errorf("wrong sizes after Delete: %d, %d", smaller.Size(), m.Size())
+	}
+	if _, ok := smaller.Lookup(42); ok {
+		t.Errorf("42 wasn't deleted")
+	}
+	if _, ok := m.Lookup(42); !ok {
+		t.Errorf("Delete() modified the receiving map")
+	}
+
+	keys := smaller.Keys()
+	sort.Ints(keys)
+	if len(keys) != 98 || keys[7] != 8 {
+		t.Errorf("wrong keys: %v", keys)
+	}
+
+	sum := 0
+	smaller.ForEach(func(k int, v string) { sum += k })
+	if sum != 99*100/2-42-7 {
+		t.Errorf("wrong sum of keys: %d", sum)
+	}
+}
+
+func TestTypedMapStringKeys(t *testing.T) {
+	m := NewTypedMap[string, int](hashKey).Set("one", 1).Set("two", 2)
+	if v, _ := m.Lookup("two"); v != 2 {
+		t.Errorf("wrong value for two: %d", v)
+	}
+}
+
+func TestTypedMapCollisions(t *testing.T) {
+	m := NewTypedMap[int, int](func(int) uint64 { return 7 })
+	for i := 0; i < 10; i++ {
+		m = m.Set(i, i*10)
+	}
+	m = m.Set(3, 33)
+
+	if m.Size() != 10 {
+		t.Errorf("wrong size: %d", m.Size())
+	}
+	for i := 0; i < 10; i++ {
+		expected := i * 10
+		if i == 3 {
+			expected = 33
+		}
+		if v, ok := m.Lookup(i); !ok || v != expected {
+			t.Errorf("wrong value for %d: %d", i, v)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		m = m.Delete(i)
+		if m.Size() != 9-i {
+			t.Fatalf("wrong size after deleting %d: %d", i, m.Size())
+		}
+		if _, ok := m.Lookup(i + 1); !ok && i < 9 {
+			t.Errorf("deleting %d lost %d", i, i+1)
+		}
+	}
+	if !m.IsNil() {
+		t.Errorf("map isn't empty after deleting every key")
+	}
+}
+
+func TestTypedMapNilHash(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic, didn't")
+		}
+	}()
+	NewTypedMap[int, int](nil)
+}
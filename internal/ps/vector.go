@@ -0,0 +1,127 @@
+package ps
+
+import "fmt"
+
+// Vector is a persistent sequence of possibly heterogenous values with
+// indexed access. Element i is stored in a path-copying trie at the path
+// given by the digits of i in base childCount, so reading or replacing any
+// element touches O(log N) nodes.
+type Vector interface {
+	// Len returns the number of elements.  This takes O(1) time.
+	Len() int
+
+	// Append returns a new vector with v added after the last element.
+	// This operation is O(log N) in the length of the vector.
+	Append(v Any) Vector
+
+	// Get returns the element at index i. If i is out of range, the
+	// second return value is false.
+	// This operation is O(log N) in the length of the vector.
+	Get(i int) (Any, bool)
+
+	// Set returns a new vector with the element at index i replaced by v.
+	// Set panics if i is out of range; use Append to add elements.
+	// This operation is O(log N) in the length of the vector.
+	Set(i int, v Any) Vector
+
+	// ForEach executes a callback on each element in index order.
+	ForEach(f func(i int, v Any))
+}
+
+type vector struct {
+	size  int
+	shift uint // how far to shift an index for the root's digit
+	root  *vectorNode
+}
+
+// vectorNode holds either elements, at the bottom level, or the
+// *vectorNodes below it
+type vectorNode struct {
+	slots [childCount]Any
+}
+
+// An empty vector shared by all vectors
+var nilVector = &vector{}
+
+// NewVector returns a new, empty vector.
+func NewVector() Vector {
+	return nilVector
+}
+
+func (v *vector) Len() int {
+	return v.size
+}
+
+func (v *vector) Append(val Any) Vector {
+	m := *v
+	if v.root != nil && v.size == 1<<(v.shift+shiftSize) {
+		// the trie is full, so grow it by a level
+		root := &vectorNode{}
+		root.slots[0] = v.root
+		m.root = root
+		m.shift += shiftSize
+	}
+	m.root = m.root.set(m.shift, v.size, val)
+	m.size++
+	return &m
+}
+
+func (v *vector) Get(i int) (Any, bool) {
+	if i < 0 || i >= v.size {
+		return nil, false
+	}
+	n := v.root
+	for shift := v.shift; shift > 0; shift -= shiftSize {
+		n = n.slots[(i>>shift)%childCount].(*vectorNode)
+	}
+	return n.slots[i%childCount], true
+}
+
+func (v *vector) Set(i int, val Any) Vector {
+	if i < 0 || i >= v.size {
+		panic(fmt.Sprintf("index %d out of range for vector of length %d", i, v.size))
+	}
+	m := *v
+	m.root = v.root.set(v.shift, i, val)
+	return &m
+}
+
+func (v *vector) ForEach(f func(i int, v Any)) {
+	if v.size == 0 {
+		return
+	}
+	i := 0
+	v.root.forEach(v.shift, &i, v.size, f)
+}
+
+// set returns a copy of n with element i, counting from the start of n,
+// replaced. A nil n is treated as an empty node.
+func (n *vectorNode) set(shift uint, i int, val Any) *vectorNode {
+	var m vectorNode
+	if n != nil {
+		m = *n
+	}
+	j := (i >> shift) % childCount
+	if shift == 0 {
+		m.slots[j] = val
+	} else {
+		child, _ := m.slots[j].(*vectorNode)
+		m.slots[j] = child.set(shift-shiftSize, i, val)
+	}
+	return &m
+}
+
+// forEach visits the elements below n while *i is less than size
+func (n *vectorNode) forEach(shift uint, i *int, size int, f func(i int, v Any)) {
+	for _, slot := range n.slots {
+		if *i >= size {
+			return
+		}
+		if shift == 0 {
+			f(*i, slot)
+			*i++
+		} else {
+			slot.(*vectorNode).forEach(shift-shiftSize, i, size, f)
+		}
+	}
+}
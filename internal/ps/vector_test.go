@@ -0,0 +1,98 @@
+package ps
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestVector(t *testing.T) {
+	v := NewVector()
+	if v.Len() != 0 {
+		t.Errorf("new vector should be empty")
+	}
+	v.ForEach(func(int, Any) { t.Errorf("empty vector has elements") })
+
+	// enough elements to need several levels
+	var versions []Vector
+	for i := 0; i < 1000; i++ {
+		versions = append(versions, v)
+		v = v.Append(i)
+	}
+	if v.Len() != 1000 {
+		t.Errorf("wrong length: %d", v.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if got, ok := v.Get(i); !ok || got != i {
+			t.Errorf("wrong element %d: %v", i, got)
+		}
+	}
+	for i, old := range versions {
+		if old.Len() != i {
+			t.Errorf("Append() modified an earlier version")
+		}
+	}
+
+	next := 0
+	v.ForEach(func(i int, val Any) {
+		if i != next || val != i {
+			t.Errorf("wrong element %d visited at %d: %v", i, next, val)
+		}
+		next++
+	})
+	if next != 1000 {
+		t.Errorf("visited %d elements", next)
+	}
+}
+
+func TestVectorSet(t *testing.T) {
+	v := NewVector()
+	for i := 0; i < 100; i++ {
+		v = v.Append(i)
+	}
+
+	changed := v.Set(64, "changed")
+	if got, _ := changed.Get(64); got != "changed" {
+		t.Errorf("wrong element after Set(): %v", got)
+	}
+	if got, _ := v.Get(64); got != 64 {
+		t.Errorf("Set() modified the receiving vector")
+	}
+	if got, _ := changed.Get(63); got != 63 || changed.Len() != 100 {
+		t.Errorf("Set() changed other elements")
+	}
+
+	for _, i := range []int{-1, 100} {
+		if _, ok := v.Get(i); ok {
+			t.Errorf("Get(%d) should be out of range", i)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Set(%d) should panic", i)
+				}
+			}()
+			v.Set(i, nil)
+		}()
+	}
+}
+
+func BenchmarkVectorAppend(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		v := NewVector()
+		for j := 0; j < 100000; j++ {
+			v = v.Append(j)
+		}
+	}
+}
+
+func BenchmarkVectorGet(b *testing.B) {
+	v := NewVector()
+	for j := 0; j < 100000; j++ {
+		v = v.Append(j)
+	}
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.Get(r.Intn(100000))
+	}
+}
@@ -0,0 +1,64 @@
+package ps
+
+// Versioned pairs a Map with a revision number which is incremented every
+// time a new version is derived from it with Set or Delete. Comparing
+// revisions allows conditional writes such as "update only if the revision
+// is still N".
+//
+// Like Map, a Versioned is immutable and safe to copy. The zero value is an
+// empty map at revision 0.
+type Versioned struct {
+	m        Map
+	revision int64
+}
+
+// NewVersioned returns a Versioned wrapping m at revision 0. A nil m is
+// treated as an empty map.
+func NewVersioned(m Map) Versioned {
+	if m == nil {
+		m = NewMap()
+	}
+	return Versioned{m: m}
+}
+
+// Map returns the wrapped map.
+func (v Versioned) Map() Map {
+	if v.m == nil {
+		return NewMap()
+	}
+	return v.m
+}
+
+// Revision returns the number of Set and Delete calls which led to this
+// version.
+func (v Versioned) Revision() int64 {
+	return v.revision
+}
+
+// Set returns a new version with key and value associated and the revision
+// incremented.
+func (v Versioned) Set(key string, value Any) Versioned {
+	return Versioned{v.Map().Set(key, value), v.revision + 1}
+}
+
+// Delete returns a new version without key and with the revision
+// incremented, whether or not key was present.
+func (v Versioned) Delete(key string) Versioned {
+	return Versioned{v.Map().Delete(key), v.revision + 1}
+}
+
+// Lookup returns the value associated with a key, if any.
+func (v Versioned) Lookup(key string) (Any, bool) {
+	return v.Map().Lookup(key)
+}
+
+// Copy returns the same version; the revision isn't incremented.
+func (v Versioned) Copy() Versioned {
+	return v
+}
+
+// Equal returns true if both versions have the same revision and equal
+// maps. Mismatched revisions are rejected without comparing the maps.
+func (v Versioned) Equal(other Versioned) bool {
+	return v.revision == other.revision && v.Map().Equal(other.Map())
+}
@@ -0,0 +1,43 @@
+package ps
+
+import "testing"
+
+func TestVersionedRevision(t *testing.T) {
+	var v Versioned
+	if v.Revision() != 0 {
+		t.Errorf("new version has revision %d", v.Revision())
+	}
+
+	one := v.Set("a", 1)
+	two := one.Set("b", 2)
+	three := two.Delete("a")
+	for i, version := range []Versioned{v, one, two, three} {
+		if version.Revision() != int64(i) {
+			t.Errorf("expected revision %d, got %d", i, version.Revision())
+		}
+	}
+
+	if val, _ := two.Lookup("a"); val != 1 {
+		t.Errorf("wrong value for a: %v", val)
+	}
+	if _, ok := three.Lookup("a"); ok {
+		t.Errorf("a wasn't deleted")
+	}
+}
+
+func TestVersionedCopy(t *testing.T) {
+	v := NewVersioned(NewMap().Set("a", 1)).Set("b", 2)
+	c := v.Copy()
+	if c.Revision() != v.Revision() {
+		t.Errorf("Copy() changed the revision")
+	}
+	if !c.Equal(v) {
+		t.Errorf("copy isn't equal to the original")
+	}
+
+	// same contents, different history
+	other := NewVersioned(NewMap().Set("a", 1).Set("b", 2))
+	if other.Equal(v) {
+		t.Errorf("versions with different revisions are equal")
+	}
+}